@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"strings"
 	"time"
@@ -16,14 +17,47 @@ import (
 
 // Checker 定义了准入控制检查器的接口
 type Checker interface {
-	// CheckContent 检查内容是否合法，如果不合法则返回拒绝原因
-	CheckContent(ctx context.Context, content string) (allowed bool, reason string, err error)
+	// CheckContent 检查内容是否合法，返回的审核结论不会为空，出错时结论表示出错后的默认处理
+	CheckContent(ctx context.Context, content string) (*Verdict, error)
 
 	// CheckPrompt 检查提示词是否合法
-	CheckPrompt(ctx context.Context, prompt string) (allowed bool, reason string, err error)
+	CheckPrompt(ctx context.Context, prompt string) (*Verdict, error)
 
 	// CheckChatMessages 检查聊天消息是否合法
-	CheckChatMessages(ctx context.Context, messages []Message) (allowed bool, reason string, err error)
+	CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error)
+}
+
+// Verdict 表示一次准入控制检查的结论
+type Verdict struct {
+	Allowed    bool          // 是否允许
+	Reason     string        // 拒绝原因，允许时为空
+	Categories []string      // 命中的违规类别
+	Score      float64       // 违规置信度，0~1
+	Rationale  string        // 审核模型给出的判断依据
+	RawOutput  string        // 审核模型的原始输出
+	Model      string        // 审核模型名称
+	Latency    time.Duration // 检查耗时
+	// FailureMode 在审核出错时记录采用的处理方式（open、closed、rules），正常时为空
+	FailureMode string
+	// RuleID 为命中的规则ID
+	RuleID string
+	// Undecided 表示检查器无法确定，Allowed 只是默认放行，例如没有命中任何关键词或规则需要升级判断
+	Undecided bool
+	// Stages 为组合检查器各阶段的结论
+	Stages []StageVerdict
+	// Cached 表示结论来自缓存，没有调用审核模型
+	Cached bool
+	// Obfuscation 为请求中识别出的混淆手段，如 base64、homoglyph
+	Obfuscation []string
+	// Windows 为超长内容分窗口检查时各窗口的结论
+	Windows []WindowVerdict
+	// DLPTypes 为内容中识别出的敏感数据类型
+	DLPTypes []string
+}
+
+// Allow 返回一个允许的结论
+func Allow() *Verdict {
+	return &Verdict{Allowed: true}
 }
 
 // Message 表示聊天消息
@@ -36,14 +70,18 @@ type Message struct {
 type OllamaChecker struct {
 	config config.AdmissionConfig
 	client *http.Client
+	policy *Policy
 }
 
+// minModelTimeout 为调用审核模型的最短超时时间
+const minModelTimeout = 30 * time.Second
+
 // NewOllamaChecker 创建一个新的Ollama准入控制检查器
 func NewOllamaChecker(cfg config.AdmissionConfig) Checker {
 	// 增加超时时间，默认至少30秒
 	timeout := time.Duration(cfg.Timeout) * time.Second
-	if timeout < 30*time.Second {
-		timeout = 30 * time.Second
+	if timeout < minModelTimeout {
+		timeout = minModelTimeout
 		log.Printf("[准入] 警告: 配置的超时时间过短，已自动调整为30秒")
 	}
 
@@ -51,45 +89,55 @@ func NewOllamaChecker(cfg config.AdmissionConfig) Checker {
 		Timeout: timeout,
 	}
 
-	log.Printf("[准入] 初始化Ollama准入控制检查器: URL=%s, 模型=%s, 超时=%v",
-		cfg.OllamaURL, cfg.ModelName, timeout)
+	// 审核策略已在加载配置时校验，这里出错只可能是未经LoadConfig构造的配置
+	policy, err := NewPolicy(cfg.Policy)
+	if err != nil {
+		log.Printf("[准入] 警告: 审核策略无效，使用默认策略: %v", err)
+		policy = DefaultPolicy()
+	}
+
+	log.Printf("[准入] 初始化Ollama准入控制检查器: URL=%s, 模型=%s, 超时=%v, 启用类别=%v",
+		cfg.OllamaURL, cfg.ModelName, timeout, policy.Categories())
 
 	return &OllamaChecker{
 		config: cfg,
 		client: client,
+		policy: policy,
 	}
 }
 
 // CheckContent 检查内容是否合法
-func (oc *OllamaChecker) CheckContent(ctx context.Context, content string) (bool, string, error) {
+func (oc *OllamaChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
 	log.Printf("[准入] 开始检查内容, 内容长度=%d", len(content))
 
 	if !oc.config.Enabled {
 		log.Printf("[准入] 准入控制已禁用")
-		return true, "", nil
+		return Allow(), nil
+	}
+
+	// 超过窗口大小的内容分窗口检查，避免审核模型截断后藏在末尾的内容逃过审核
+	if windows := splitWindows(content, oc.config.Chunking.WindowChars, oc.config.Chunking.OverlapChars); len(windows) > 1 {
+		return oc.checkWindows(ctx, windows)
 	}
+	return oc.checkWindow(ctx, content)
+}
 
+// checkWindow 调用审核模型检查一段内容
+func (oc *OllamaChecker) checkWindow(ctx context.Context, content string) (*Verdict, error) {
 	// 记录使用的模型名称
 	log.Printf("[准入] 使用模型 %s 进行准入控制检查, URL=%s",
 		oc.config.ModelName, oc.config.OllamaURL)
 
-	// 使用系统提示词指导模型进行内容审核
-	systemPrompt := `你是一个安全审核员。你的任务是检查content是否包含不允许的内容。你只需要输出 ALLOW和 DISALLOW`
+	started := time.Now()
 
-	// 聊天请求
+	// 使用审核策略中的系统提示词和少样本示例指导模型进行内容审核，
+	// 并通过format要求模型按JSON Schema输出
 	requestBody := map[string]interface{}{
-		"model": oc.config.ModelName,
-		"messages": []map[string]string{
-			{
-				"role":    "system",
-				"content": systemPrompt,
-			},
-			{
-				"role":    "user",
-				"content": content,
-			},
-		},
-		"stream": false,
+		"model":    oc.config.ModelName,
+		"messages": oc.policy.messages(content),
+		"format":   oc.policy.schema,
+		"options":  map[string]interface{}{"temperature": 0},
+		"stream":   false,
 	}
 
 	// 执行请求前记录
@@ -108,68 +156,140 @@ func (oc *OllamaChecker) CheckContent(ctx context.Context, content string) (bool
 
 		log.Printf("[准入] 请求失败(重试 %d/%d): %v", retry, oc.config.MaxRetries, err)
 		if retry < oc.config.MaxRetries {
-			// 指数退避策略
-			backoffTime := time.Duration(500*(1<<retry)) * time.Millisecond
-			if backoffTime > 5*time.Second {
-				backoffTime = 5 * time.Second
-			}
+			backoffTime := retryBackoff(retry)
 			log.Printf("[准入] 等待 %v 后重试", backoffTime)
-			time.Sleep(backoffTime)
+			// 客户端断开或推测执行取消检查后不再继续重试，及时释放审核模型的并发
+			if !SleepContext(ctx, backoffTime) {
+				log.Printf("[准入] 等待重试时检查已取消: %v", ctx.Err())
+				break
+			}
 		}
 	}
 
 	if err != nil {
 		log.Printf("[准入] 控制失败，允许请求通过: %v", err)
 		// 出错时默认允许，避免阻止正常服务
-		return true, "", err
+		verdict := Allow()
+		verdict.Model = oc.config.ModelName
+		verdict.Latency = time.Since(started)
+		return verdict, err
 	}
 
 	// 记录响应
 	log.Printf("[准入] 收到模型响应: %s", result)
 
 	// 分析结果
-	if strings.HasPrefix(result, "ALLOW") {
-		return true, "", nil
-	} else if strings.HasPrefix(result, "DISALLOW") {
-		reason := strings.TrimPrefix(result, "DISALLOW:")
-		reason = strings.TrimSpace(reason)
+	verdict, err := parseVerdict(result, oc.policy.Categories())
+	if verdict == nil {
+		// 无法解析时同样默认允许，但以错误的形式上报，不再静默放行
+		log.Printf("[准入] 准入控制结果格式异常: %s", result)
+		verdict = Allow()
+	} else {
+		// 按审核策略的类别和阈值做出最终决定
+		oc.policy.decide(verdict)
+	}
+	verdict.RawOutput = result
+	verdict.Model = oc.config.ModelName
+	verdict.Latency = time.Since(started)
+	return verdict, err
+}
+
+// retryBackoff 返回第 retry 次失败后重试前等待的时间，按指数退避，最长5秒
+func retryBackoff(retry int) time.Duration {
+	backoff := time.Duration(500*(1<<retry)) * time.Millisecond
+	if backoff > 5*time.Second {
+		backoff = 5 * time.Second
+	}
+	return backoff
+}
+
+// parseVerdict 解析审核模型的输出。优先按JSON解析，
+// 不支持format参数的旧版本Ollama可能仍输出 ALLOW/DISALLOW，此时按前缀解析
+func parseVerdict(result string, categories []string) (*Verdict, error) {
+	text := strings.TrimSpace(result)
+	// 部分模型会用Markdown代码块包裹JSON
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+	text = strings.TrimSpace(text)
+
+	var out struct {
+		Allowed    *bool    `json:"allowed"`
+		Categories []string `json:"categories"`
+		Score      float64  `json:"score"`
+		Rationale  string   `json:"rationale"`
+	}
+	if err := json.Unmarshal([]byte(text), &out); err == nil && out.Allowed != nil {
+		verdict := &Verdict{
+			Allowed:   *out.Allowed,
+			Score:     math.Max(0, math.Min(1, out.Score)),
+			Rationale: out.Rationale,
+		}
+		for _, c := range out.Categories {
+			if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+				verdict.Categories = append(verdict.Categories, c)
+			}
+		}
+		if !verdict.Allowed {
+			verdict.Reason = verdictReason(verdict.Categories, verdict.Rationale)
+		}
+		return verdict, nil
+	}
+
+	if strings.HasPrefix(text, "ALLOW") {
+		return &Verdict{Allowed: true}, nil
+	} else if strings.HasPrefix(text, "DISALLOW") {
+		reason := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(text, "DISALLOW"), ":"))
+		verdict := &Verdict{Allowed: false, Score: 1, Rationale: reason}
+		for _, c := range categories {
+			if strings.Contains(strings.ToLower(reason), c) {
+				verdict.Categories = append(verdict.Categories, c)
+			}
+		}
+		verdict.Reason = reason
 		if reason == "" {
-			reason = "内容不合规"
+			verdict.Reason = verdictReason(verdict.Categories, "")
 		}
-		return false, reason, nil
+		return verdict, nil
 	}
 
-	// 如果响应格式不符合预期，默认允许并记录
-	log.Printf("准入控制结果格式异常: %s", result)
-	return true, "", nil
+	return nil, fmt.Errorf("无法解析审核结果: %s", result)
+}
+
+// verdictReason 根据违规类别生成拒绝原因
+func verdictReason(categories []string, rationale string) string {
+	if len(categories) > 0 {
+		return strings.Join(categories, ", ")
+	}
+	if rationale != "" {
+		return rationale
+	}
+	return "内容不合规"
 }
 
 // CheckPrompt 检查提示词是否合法
-func (oc *OllamaChecker) CheckPrompt(ctx context.Context, prompt string) (bool, string, error) {
+func (oc *OllamaChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
 	return oc.CheckContent(ctx, prompt)
 }
 
-// CheckChatMessages 检查聊天消息是否合法
-func (oc *OllamaChecker) CheckChatMessages(ctx context.Context, messages []Message) (bool, string, error) {
-	// 组合所有用户消息进行检查
-	var userContents []string
-	for _, msg := range messages {
-		if msg.Role == "user" {
-			userContents = append(userContents, msg.Content)
-		}
-	}
-
-	// 如果没有用户消息，则默认允许
-	if len(userContents) == 0 {
-		return true, "", nil
+// CheckChatMessages 检查聊天消息是否合法，审核整个对话（包括系统和工具消息），
+// 而不只是最后一条用户消息，以识别逐步诱导的越狱
+func (oc *OllamaChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	// 如果没有消息，则默认允许
+	if len(messages) == 0 {
+		return Allow(), nil
 	}
 
-	// 检查最后一条用户消息
-	return oc.CheckContent(ctx, userContents[len(userContents)-1])
+	return oc.CheckContent(ctx, Transcript(messages))
 }
 
 // doRequest 执行Ollama API请求
 func (oc *OllamaChecker) doRequest(ctx context.Context, requestBody map[string]interface{}) (string, error) {
+	return chatRequest(ctx, oc.client, oc.config.OllamaURL, requestBody)
+}
+
+// chatRequest 调用Ollama的 /api/chat 接口，返回模型输出的消息内容
+func chatRequest(ctx context.Context, client *http.Client, ollamaURL string, requestBody map[string]interface{}) (string, error) {
 	// 序列化请求体
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
@@ -177,7 +297,7 @@ func (oc *OllamaChecker) doRequest(ctx context.Context, requestBody map[string]i
 	}
 
 	// 创建HTTP请求
-	apiURL := fmt.Sprintf("%s/api/chat", oc.config.OllamaURL)
+	apiURL := fmt.Sprintf("%s/api/chat", ollamaURL)
 	log.Printf("[准入] 发送请求到: %s, 数据: %s", apiURL, string(jsonData))
 
 	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(jsonData))
@@ -191,7 +311,7 @@ func (oc *OllamaChecker) doRequest(ctx context.Context, requestBody map[string]i
 	startTime := time.Now()
 
 	// 执行请求
-	resp, err := oc.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("执行HTTP请求失败: %w", err)
 	}
@@ -229,49 +349,3 @@ func (oc *OllamaChecker) doRequest(ctx context.Context, requestBody map[string]i
 
 	return response.Message.Content, nil
 }
-
-// CreateDeniedResponse 创建拒绝请求的响应
-func CreateDeniedResponse(reason string, requestPath string) []byte {
-	// 构造与原始API格式一致的响应
-	responseMessage := fmt.Sprintf("很抱歉，我无法处理您的请求。原因：%s", reason)
-
-	// 使用有序的结构体确保JSON字段顺序与原始API一致
-	type ResponseMessage struct {
-		Role    string `json:"role"`
-		Content string `json:"content"`
-	}
-
-	type Response struct {
-		Model              string          `json:"model"`
-		CreatedAt          string          `json:"created_at"`
-		Message            ResponseMessage `json:"message"`
-		DoneReason         string          `json:"done_reason"`
-		Done               bool            `json:"done"`
-		TotalDuration      int64           `json:"total_duration"`
-		LoadDuration       int64           `json:"load_duration"`
-		PromptEvalCount    int             `json:"prompt_eval_count"`
-		PromptEvalDuration int64           `json:"prompt_eval_duration"`
-		EvalCount          int             `json:"eval_count"`
-		EvalDuration       int64           `json:"eval_duration"`
-	}
-
-	response := Response{
-		Model:     "phi3:3.8b", // 使用固定的模型名称，或从配置中获取
-		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
-		Message: ResponseMessage{
-			Role:    "assistant",
-			Content: responseMessage,
-		},
-		DoneReason:         "stop",
-		Done:               true,
-		TotalDuration:      8000000000, // 模拟真实的时间数值
-		LoadDuration:       15000000,
-		PromptEvalCount:    15,
-		PromptEvalDuration: 9000000,
-		EvalCount:          400,
-		EvalDuration:       7900000000,
-	}
-
-	jsonResponse, _ := json.Marshal(response)
-	return jsonResponse
-}
@@ -0,0 +1,45 @@
+package admission
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// TestOllamaCheckerBackoffCanceled 检查取消后不再等待退避和重试
+func TestOllamaCheckerBackoffCanceled(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "model is loading", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	// 5 次重试的退避合计 12.5 秒
+	checker := NewOllamaChecker(config.AdmissionConfig{
+		Enabled:    true,
+		OllamaURL:  srv.URL,
+		ModelName:  "llama-guard3",
+		MaxRetries: 5,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	verdict, err := checker.CheckPrompt(ctx, "hello")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("CheckPrompt returned after %v, want it to stop at cancellation", elapsed)
+	}
+	if err == nil || verdict == nil || !verdict.Allowed {
+		t.Errorf("verdict = %+v, err = %v, want the failed-open verdict and an error", verdict, err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
@@ -0,0 +1,157 @@
+package admission
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+	"github.com/mfzzf/LLM_Based_HoneyPot/dlp"
+)
+
+// Shared 为各准入策略共用的规则集和审核结论缓存。路由策略不能覆盖规则文件和缓存的配置，
+// 共用可以避免每个策略各自监视规则文件、各自持久化同一个缓存文件，缓存统计也覆盖全部策略
+type Shared struct {
+	Rules *RuleSet      // 没有配置规则文件时为空
+	Cache *VerdictCache // 没有开启缓存时为空
+}
+
+// NewShared 按准入控制的顶层配置加载规则文件并创建审核结论缓存
+func NewShared(cfg config.AdmissionConfig) (*Shared, error) {
+	shared := &Shared{}
+	if cfg.RulesFile != "" {
+		rules, err := NewRuleSet(cfg.RulesFile, time.Duration(cfg.RulesReloadSeconds)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		shared.Rules = rules
+	}
+	if cfg.Cache.Enabled {
+		shared.Cache = NewVerdictCache(cfg.Cache)
+	}
+	return shared, nil
+}
+
+// NewChecker 按配置组装完整的准入检查器：规范化、敏感数据、规则、缓存、失败处理，最内层为审核模型或组合检查器。
+// 规则集和缓存来自 shared，由各准入策略共用
+func NewChecker(cfg config.AdmissionConfig, dlpCfg config.DLPConfig, shared *Shared) (Checker, error) {
+	var err error
+
+	// 配置了规则文件时，规则检查在审核模型之前进行，同时作为 failure_mode=rules 的降级方案；
+	// 没有规则文件时降级为内置的关键词检查
+	rules := shared.Rules
+	fallback := NewKeywordChecker(cfg.Policy.Categories)
+	if rules != nil {
+		fallback = NewRuleChecker(rules, nil)
+	}
+
+	// 配置了组合检查器时代替单个审核模型
+	inner := NewOllamaChecker(cfg)
+	if len(cfg.Chain.Stages) > 0 {
+		inner, err = NewChainChecker(cfg, rules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 审核模型出错时按 failure_mode 处理
+	checker := NewFailSafeChecker(inner, cfg.FailureMode, fallback)
+
+	// 缓存位于规则之后，规则文件重新加载后立即生效
+	if shared.Cache != nil {
+		checker = NewCachingChecker(checker, shared.Cache, PolicyVersion(cfg))
+	}
+	if rules != nil {
+		checker = NewRuleChecker(rules, checker)
+	}
+	// 敏感数据检查位于规范化之内，编码后的密钥还原后同样会被识别
+	if dlpCfg.Enabled && len(dlpCfg.DenyTypes) > 0 {
+		scanner, err := dlp.NewScanner(dlpCfg.Types)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := dlp.NewScanner(dlpCfg.DenyTypes); err != nil {
+			return nil, err
+		}
+		enabled := make(map[string]bool)
+		for _, t := range dlpCfg.Types {
+			enabled[t] = true
+		}
+		for _, t := range dlpCfg.DenyTypes {
+			if len(enabled) > 0 && !enabled[t] {
+				return nil, fmt.Errorf("拒绝的敏感数据类型 %q 不在识别范围内", t)
+			}
+		}
+		checker = NewDLPChecker(checker, scanner, dlpCfg.DenyTypes)
+		log.Printf("[初始化] 敏感数据准入检查已启用: 拒绝类型=%v", dlpCfg.DenyTypes)
+	}
+	// 规范化在最外层，规则和审核模型都会检查还原后的文本
+	if cfg.Normalize.Enabled {
+		checker = NewNormalizingChecker(checker, NewNormalizer(cfg.Normalize.MinEncodedLength))
+		log.Printf("[初始化] 混淆还原已启用: 编码片段最小长度=%d", cfg.Normalize.MinEncodedLength)
+	}
+	return checker, nil
+}
+
+// minCheckTimeout 为准入检查时限的下限，只有规则、关键词等本地阶段时使用
+const minCheckTimeout = 10 * time.Second
+
+// CheckTimeout 返回检查 chars 个字符的内容时准入检查的总时限。配置了 check_timeout_seconds 时直接使用；
+// 否则按最坏情况估算：审核模型的超时（至少30秒）乘以重试次数，再乘以分窗口检查的轮数，
+// 组合检查器依次检查的阶段耗时相加，同时检查的取最大值。规范化后的文本与原文同时检查，不增加耗时
+func CheckTimeout(cfg config.AdmissionConfig, chars int) time.Duration {
+	if cfg.CheckTimeout > 0 {
+		return time.Duration(cfg.CheckTimeout) * time.Second
+	}
+
+	// 每轮同时检查 max_workers 个窗口
+	rounds := windowCount(chars, cfg.Chunking.WindowChars, cfg.Chunking.OverlapChars)
+	if workers := cfg.Chunking.MaxWorkers; rounds > 1 && workers > 1 {
+		rounds = (rounds + workers - 1) / workers
+	}
+	model := func(seconds int) time.Duration {
+		timeout := time.Duration(seconds) * time.Second
+		if timeout < minModelTimeout {
+			timeout = minModelTimeout
+		}
+		call := timeout * time.Duration(cfg.MaxRetries+1)
+		for retry := 0; retry < cfg.MaxRetries; retry++ {
+			call += retryBackoff(retry)
+		}
+		return call * time.Duration(rounds)
+	}
+
+	total := model(cfg.Timeout)
+	if len(cfg.Chain.Stages) > 0 {
+		parallel := cfg.Chain.Strategy == "all_allow" || cfg.Chain.Strategy == "majority"
+		total = 0
+		for _, st := range cfg.Chain.Stages {
+			var d time.Duration
+			switch st.Type {
+			case "ollama":
+				seconds := cfg.Timeout
+				if st.Timeout > 0 {
+					seconds = st.Timeout
+				}
+				d = model(seconds)
+			case "http", "knn":
+				// 与 NewHTTPChecker、knn.NewEmbedder 的默认超时一致
+				d = time.Duration(st.Timeout) * time.Second
+				if d <= 0 && st.Type == "http" {
+					d = 10 * time.Second
+				} else if d <= 0 {
+					d = 30 * time.Second
+				}
+			}
+			if !parallel {
+				total += d
+			} else if d > total {
+				total = d
+			}
+		}
+	}
+	if total < minCheckTimeout {
+		total = minCheckTimeout
+	}
+	return total
+}
@@ -0,0 +1,68 @@
+package admission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+func TestCheckTimeout(t *testing.T) {
+	base := config.AdmissionConfig{
+		Timeout:    5,
+		MaxRetries: 1,
+		Chunking:   config.ChunkingConfig{WindowChars: 100, OverlapChars: 10, MaxWorkers: 2},
+	}
+	// 单次调用：2 × 30 秒 + 0.5 秒退避
+	call := 60*time.Second + 500*time.Millisecond
+
+	tests := []struct {
+		name  string
+		cfg   func(c *config.AdmissionConfig)
+		chars int
+		want  time.Duration
+	}{
+		{name: "configured", cfg: func(c *config.AdmissionConfig) { c.CheckTimeout = 7 }, chars: 10000, want: 7 * time.Second},
+		{name: "single model call", chars: 50, want: call},
+		{name: "longer model timeout", cfg: func(c *config.AdmissionConfig) { c.Timeout = 45 }, chars: 50, want: 90*time.Second + 500*time.Millisecond},
+		// 280 个字符切分为 3 个窗口，2 个并发需要 2 轮
+		{name: "windows", chars: 280, want: 2 * call},
+		{name: "sequential chain", cfg: func(c *config.AdmissionConfig) {
+			c.Chain = config.ChainConfig{Strategy: "cascade", Stages: []config.StageConfig{
+				{Name: "rules", Type: "rules"},
+				{Name: "http", Type: "http"},
+				{Name: "llm", Type: "ollama"},
+			}}
+		}, chars: 50, want: 10*time.Second + call},
+		{name: "parallel chain", cfg: func(c *config.AdmissionConfig) {
+			c.Chain = config.ChainConfig{Strategy: "majority", Stages: []config.StageConfig{
+				{Name: "http", Type: "http", Timeout: 90},
+				{Name: "llm", Type: "ollama"},
+			}}
+		}, chars: 50, want: 90 * time.Second},
+		{name: "local stages only", cfg: func(c *config.AdmissionConfig) {
+			c.Chain = config.ChainConfig{Strategy: "first_deny", Stages: []config.StageConfig{{Name: "keywords", Type: "keywords"}}}
+		}, chars: 50, want: minCheckTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			if got := CheckTimeout(cfg, tt.chars); got != tt.want {
+				t.Errorf("CheckTimeout = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowCountMatchesSplit(t *testing.T) {
+	for _, chars := range []int{0, 1, 99, 100, 101, 190, 191, 280, 281, 1000} {
+		content := string(make([]rune, chars))
+		if got, want := windowCount(chars, 100, 10), len(splitWindows(content, 100, 10)); got != want {
+			t.Errorf("windowCount(%d) = %d, want %d", chars, got, want)
+		}
+	}
+}
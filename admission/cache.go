@@ -0,0 +1,338 @@
+package admission
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// CacheStats 为审核结论缓存的统计数据
+type CacheStats struct {
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+	Entries     int   `json:"entries"`
+}
+
+// cachedVerdict 为缓存中保存的结论，不包含各阶段的结论和耗时
+type cachedVerdict struct {
+	Key        string    `json:"key"`
+	Allowed    bool      `json:"allowed"`
+	Reason     string    `json:"reason,omitempty"`
+	Categories []string  `json:"categories,omitempty"`
+	Score      float64   `json:"score"`
+	Rationale  string    `json:"rationale,omitempty"`
+	RawOutput  string    `json:"raw_output,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	RuleID     string    `json:"rule_id,omitempty"`
+	Undecided  bool      `json:"undecided,omitempty"`
+	Expires    time.Time `json:"expires"`
+}
+
+// VerdictCache 为审核结论的LRU缓存，可以由多个准入策略的 CachingChecker 共用，
+// 各策略的缓存键包含各自的策略版本，互不影响
+type VerdictCache struct {
+	maxSize int
+	ttl     time.Duration
+	persist string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // 最近使用的在前
+	stats   CacheStats
+	dirty   bool
+}
+
+// NewVerdictCache 创建审核结论缓存，配置了持久化文件时加载未过期的缓存并定期保存
+func NewVerdictCache(cfg config.CacheConfig) *VerdictCache {
+	vc := &VerdictCache{
+		maxSize: cfg.MaxEntries,
+		ttl:     time.Duration(cfg.TTLSeconds) * time.Second,
+		persist: cfg.PersistFile,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+
+	if vc.persist != "" {
+		if err := vc.load(); err != nil {
+			log.Printf("[缓存] 加载缓存文件失败: %v", err)
+		}
+	}
+	interval := time.Duration(cfg.PersistIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go vc.maintain(interval)
+
+	log.Printf("[缓存] 审核结论缓存已启用: 最大条目=%d, 有效期=%v, 持久化文件=%q", vc.maxSize, vc.ttl, vc.persist)
+	return vc
+}
+
+// CachingChecker 以规范化内容和策略版本的哈希为键，在 VerdictCache 中缓存内部检查器的结论
+type CachingChecker struct {
+	inner   Checker
+	version string
+	cache   *VerdictCache
+}
+
+// NewCachingChecker 创建一个带缓存的检查器，version 为审核策略版本，策略变化后旧的缓存不再命中
+func NewCachingChecker(inner Checker, cache *VerdictCache, version string) *CachingChecker {
+	log.Printf("[缓存] 检查器使用审核结论缓存: 策略版本=%s", version)
+	return &CachingChecker{inner: inner, version: version, cache: cache}
+}
+
+// PolicyVersion 根据影响审核结论的配置计算策略版本，分窗口的大小和重叠决定了实际检查的内容，同样计入
+func PolicyVersion(cfg config.AdmissionConfig) string {
+	data, _ := json.Marshal(struct {
+		ModelName    string
+		Policy       config.PolicyConfig
+		Chain        config.ChainConfig
+		WindowChars  int
+		OverlapChars int
+	}{cfg.ModelName, cfg.Policy, cfg.Chain, cfg.Chunking.WindowChars, cfg.Chunking.OverlapChars})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// CheckContent 检查内容是否合法
+func (cc *CachingChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	return cc.check("content", content, func() (*Verdict, error) {
+		return cc.inner.CheckContent(ctx, content)
+	})
+}
+
+// CheckPrompt 检查提示词是否合法
+func (cc *CachingChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return cc.check("prompt", prompt, func() (*Verdict, error) {
+		return cc.inner.CheckPrompt(ctx, prompt)
+	})
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (cc *CachingChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	var b strings.Builder
+	for _, msg := range messages {
+		b.WriteString(msg.Role)
+		b.WriteString(":")
+		b.WriteString(msg.Content)
+		b.WriteString("\n")
+	}
+	return cc.check("chat", b.String(), func() (*Verdict, error) {
+		return cc.inner.CheckChatMessages(ctx, messages)
+	})
+}
+
+// check 查询缓存，未命中时调用内部检查器并缓存成功的结论
+func (cc *CachingChecker) check(kind, content string, miss func() (*Verdict, error)) (*Verdict, error) {
+	started := time.Now()
+	key := cc.key(kind, content)
+
+	if v := cc.cache.get(key); v != nil {
+		v.Latency = time.Since(started)
+		return v, nil
+	}
+
+	verdict, err := miss()
+	// 出错、按 failure_mode 得出或部分阶段、窗口失败的结论不缓存
+	if err == nil && verdict != nil && verdict.FailureMode == "" && !degraded(verdict) {
+		cc.cache.put(key, verdict)
+	}
+	return verdict, err
+}
+
+// degraded 判断结论是否有阶段或窗口检查失败，组合检查器的阶段中可能包含窗口的结论
+func degraded(v *Verdict) bool {
+	for _, w := range v.Windows {
+		if w.Err != nil {
+			return true
+		}
+	}
+	for _, st := range v.Stages {
+		if st.Err != nil || (st.Verdict != nil && degraded(st.Verdict)) {
+			return true
+		}
+	}
+	return false
+}
+
+// key 计算缓存键：策略版本、检查类型和规范化内容的哈希
+func (cc *CachingChecker) key(kind, content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	sum := sha256.Sum256([]byte(cc.version + "\x00" + kind + "\x00" + normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func (vc *VerdictCache) get(key string) *Verdict {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	elem, ok := vc.entries[key]
+	if !ok {
+		vc.stats.Misses++
+		return nil
+	}
+	entry := elem.Value.(*cachedVerdict)
+	if time.Now().After(entry.Expires) {
+		vc.order.Remove(elem)
+		delete(vc.entries, key)
+		vc.stats.Expirations++
+		vc.stats.Misses++
+		vc.dirty = true
+		return nil
+	}
+
+	vc.order.MoveToFront(elem)
+	vc.stats.Hits++
+	return &Verdict{
+		Allowed:    entry.Allowed,
+		Reason:     entry.Reason,
+		Categories: entry.Categories,
+		Score:      entry.Score,
+		Rationale:  entry.Rationale,
+		RawOutput:  entry.RawOutput,
+		Model:      entry.Model,
+		RuleID:     entry.RuleID,
+		Undecided:  entry.Undecided,
+		Cached:     true,
+	}
+}
+
+func (vc *VerdictCache) put(key string, v *Verdict) {
+	entry := &cachedVerdict{
+		Key:        key,
+		Allowed:    v.Allowed,
+		Reason:     v.Reason,
+		Categories: v.Categories,
+		Score:      v.Score,
+		Rationale:  v.Rationale,
+		RawOutput:  v.RawOutput,
+		Model:      v.Model,
+		RuleID:     v.RuleID,
+		Undecided:  v.Undecided,
+		Expires:    time.Now().Add(vc.ttl),
+	}
+
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.insert(entry)
+	vc.dirty = true
+}
+
+// insert 插入或更新条目，超过容量时淘汰最久未使用的条目，调用方需持有锁
+func (vc *VerdictCache) insert(entry *cachedVerdict) {
+	if elem, ok := vc.entries[entry.Key]; ok {
+		elem.Value = entry
+		vc.order.MoveToFront(elem)
+		return
+	}
+	vc.entries[entry.Key] = vc.order.PushFront(entry)
+	for vc.order.Len() > vc.maxSize {
+		oldest := vc.order.Back()
+		vc.order.Remove(oldest)
+		delete(vc.entries, oldest.Value.(*cachedVerdict).Key)
+		vc.stats.Evictions++
+	}
+}
+
+// Stats 返回缓存的统计数据
+func (vc *VerdictCache) Stats() CacheStats {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	stats := vc.stats
+	stats.Entries = vc.order.Len()
+	return stats
+}
+
+// load 从持久化文件加载未过期的缓存
+func (vc *VerdictCache) load() error {
+	data, err := os.ReadFile(vc.persist)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []*cachedVerdict
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("解析缓存文件失败: %w", err)
+	}
+
+	now := time.Now()
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	// 文件中按最近使用在前的顺序保存，倒序插入以恢复LRU顺序
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Expires.After(now) {
+			vc.insert(entries[i])
+		}
+	}
+	log.Printf("[缓存] 从 %s 加载了 %d 条缓存", vc.persist, vc.order.Len())
+	return nil
+}
+
+// Save 将缓存写入持久化文件，先写临时文件再重命名，避免写入中断导致文件损坏
+func (vc *VerdictCache) Save() error {
+	if vc.persist == "" {
+		return nil
+	}
+
+	vc.mu.Lock()
+	if !vc.dirty {
+		vc.mu.Unlock()
+		return nil
+	}
+	entries := make([]*cachedVerdict, 0, vc.order.Len())
+	for elem := vc.order.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*cachedVerdict))
+	}
+	vc.dirty = false
+	vc.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp := vc.persist + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, vc.persist)
+}
+
+// maintain 定期保存缓存并在统计数据变化时输出
+func (vc *VerdictCache) maintain(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last CacheStats
+	for range ticker.C {
+		if err := vc.Save(); err != nil {
+			log.Printf("[缓存] 保存缓存文件失败: %v", err)
+		}
+		if stats := vc.Stats(); stats != last {
+			vc.logStats(stats)
+			last = stats
+		}
+	}
+}
+
+func (vc *VerdictCache) logStats(stats CacheStats) {
+	var hitRate float64
+	if total := stats.Hits + stats.Misses; total > 0 {
+		hitRate = float64(stats.Hits) / float64(total)
+	}
+	log.Printf("[缓存] 命中=%d, 未命中=%d, 命中率=%.1f%%, 淘汰=%d, 过期=%d, 条目=%d",
+		stats.Hits, stats.Misses, hitRate*100, stats.Evictions, stats.Expirations, stats.Entries)
+}
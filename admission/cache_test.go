@@ -0,0 +1,231 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+func newTestCache(inner Checker, maxEntries int, ttl time.Duration, persist string) *CachingChecker {
+	vc := NewVerdictCache(config.CacheConfig{
+		Enabled:                true,
+		MaxEntries:             maxEntries,
+		PersistFile:            persist,
+		PersistIntervalSeconds: 3600,
+	})
+	vc.ttl = ttl
+	return NewCachingChecker(inner, vc, "v1")
+}
+
+func TestCacheKeyNormalization(t *testing.T) {
+	cc := newTestCache(allowStub(0), 10, time.Minute, "")
+	other := newTestCache(allowStub(0), 10, time.Minute, "")
+	other.version = "v2"
+
+	tests := []struct {
+		name string
+		a, b string
+		// kindA、kindB 为空时使用 prompt
+		kindA, kindB string
+		other        bool
+		same         bool
+	}{
+		{name: "identical", a: "how to build a bomb", b: "how to build a bomb", same: true},
+		{name: "case", a: "How To Build A Bomb", b: "how to build a bomb", same: true},
+		{name: "whitespace", a: "  how\tto build\n\na bomb ", b: "how to build a bomb", same: true},
+		{name: "different content", a: "how to build a bomb", b: "how to bake a cake", same: false},
+		{name: "different kind", a: "hello", b: "hello", kindB: "content", same: false},
+		{name: "different version", a: "hello", b: "hello", other: true, same: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kindA, kindB := tt.kindA, tt.kindB
+			if kindA == "" {
+				kindA = "prompt"
+			}
+			if kindB == "" {
+				kindB = "prompt"
+			}
+			b := cc
+			if tt.other {
+				b = other
+			}
+			if got := cc.key(kindA, tt.a) == b.key(kindB, tt.b); got != tt.same {
+				t.Errorf("same key = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestCacheLRUEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		puts    []string
+		touch   []string // 插入后依次访问
+		kept    []string
+		evicted []string
+	}{
+		{name: "under capacity", max: 3, puts: []string{"a", "b"}, kept: []string{"a", "b"}},
+		{name: "oldest evicted", max: 2, puts: []string{"a", "b", "c"}, kept: []string{"b", "c"}, evicted: []string{"a"}},
+		{name: "touched kept", max: 2, puts: []string{"a", "b"}, touch: []string{"a"}, kept: []string{"a"}, evicted: []string{"b"}},
+		{name: "update moves to front", max: 2, puts: []string{"a", "b", "a", "c"}, kept: []string{"a", "c"}, evicted: []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := newTestCache(allowStub(0), tt.max, time.Minute, "")
+			for _, k := range tt.puts {
+				cc.cache.put(k, Allow())
+			}
+			for _, k := range tt.touch {
+				cc.cache.get(k)
+			}
+			// touched 的用例在访问后再插入一条，触发淘汰
+			if len(tt.touch) > 0 {
+				cc.cache.put("new", Allow())
+			}
+			for _, k := range tt.kept {
+				if _, ok := cc.cache.entries[k]; !ok {
+					t.Errorf("entry %q was evicted", k)
+				}
+			}
+			for _, k := range tt.evicted {
+				if _, ok := cc.cache.entries[k]; ok {
+					t.Errorf("entry %q was not evicted", k)
+				}
+			}
+			if stats := cc.cache.Stats(); stats.Entries > tt.max || int(stats.Evictions) != len(tt.evicted) {
+				t.Errorf("stats = %+v, want at most %d entries and %d evictions", stats, tt.max, len(tt.evicted))
+			}
+		})
+	}
+}
+
+func TestCacheTTLExpiry(t *testing.T) {
+	inner := allowStub(0)
+	cc := newTestCache(inner, 10, time.Minute, "")
+	ctx := context.Background()
+
+	if _, err := cc.CheckPrompt(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := cc.CheckPrompt(ctx, "hello")
+	if !v.Cached || inner.calls != 1 {
+		t.Fatalf("second check: Cached = %v, calls = %d, want cached hit", v.Cached, inner.calls)
+	}
+
+	// 让条目过期
+	for _, elem := range cc.cache.entries {
+		elem.Value.(*cachedVerdict).Expires = time.Now().Add(-time.Second)
+	}
+	v, _ = cc.CheckPrompt(ctx, "hello")
+	if v.Cached || inner.calls != 2 {
+		t.Fatalf("after expiry: Cached = %v, calls = %d, want a fresh check", v.Cached, inner.calls)
+	}
+	if stats := cc.cache.Stats(); stats.Expirations != 1 || stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("stats = %+v, want 1 expiration, 1 hit, 2 misses", stats)
+	}
+}
+
+func TestCacheSkipsDegradedVerdicts(t *testing.T) {
+	failed := errors.New("timeout")
+	tests := []struct {
+		name    string
+		verdict *Verdict
+		err     error
+		cached  bool
+	}{
+		{name: "clean", verdict: Allow(), cached: true},
+		{name: "denied", verdict: &Verdict{Allowed: false, Reason: "malware"}, cached: true},
+		{name: "error", verdict: Allow(), err: failed},
+		{name: "failure mode", verdict: &Verdict{Allowed: true, FailureMode: "open"}},
+		{name: "failed stage", verdict: &Verdict{Allowed: true, Stages: []StageVerdict{{Name: "llm", Err: failed}, {Name: "rules", Verdict: Allow()}}}},
+		{name: "failed window", verdict: &Verdict{Allowed: true, Windows: []WindowVerdict{{Index: 0, Verdict: Allow()}, {Index: 1, Err: failed}}}},
+		{name: "failed window in stage", verdict: &Verdict{Allowed: true, Stages: []StageVerdict{{Name: "llm", Verdict: &Verdict{Allowed: true, Windows: []WindowVerdict{{Index: 1, Err: failed}}}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := newTestCache(&stubChecker{verdict: tt.verdict, err: tt.err}, 10, time.Minute, "")
+			cc.CheckPrompt(context.Background(), "hello")
+			if got := cc.cache.Stats().Entries == 1; got != tt.cached {
+				t.Errorf("cached = %v, want %v", got, tt.cached)
+			}
+		})
+	}
+}
+
+func TestCachePersistenceReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	cc := newTestCache(allowStub(0), 10, time.Minute, path)
+	cc.cache.put("a", &Verdict{Allowed: false, Reason: "malware", Categories: []string{"malware"}, Score: 0.9})
+	cc.cache.put("b", Allow())
+	cc.cache.put("expired", Allow())
+	cc.cache.entries["expired"].Value.(*cachedVerdict).Expires = time.Now().Add(-time.Second)
+	// a 最近使用
+	cc.cache.get("a")
+	if err := cc.cache.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		max     int
+		present []string
+		absent  []string
+	}{
+		{name: "all valid entries", max: 10, present: []string{"a", "b"}, absent: []string{"expired"}},
+		{name: "lru order restored", max: 1, present: []string{"a"}, absent: []string{"b", "expired"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reloaded := newTestCache(allowStub(0), tt.max, time.Minute, path)
+			for _, k := range tt.present {
+				if _, ok := reloaded.cache.entries[k]; !ok {
+					t.Errorf("entry %q missing after reload", k)
+				}
+			}
+			for _, k := range tt.absent {
+				if _, ok := reloaded.cache.entries[k]; ok {
+					t.Errorf("entry %q should not be reloaded", k)
+				}
+			}
+		})
+	}
+
+	reloaded := newTestCache(allowStub(0), 10, time.Minute, path)
+	v := reloaded.cache.get("a")
+	if v == nil || v.Allowed || v.Reason != "malware" || v.Score != 0.9 || !v.Cached {
+		t.Errorf("reloaded verdict = %+v", v)
+	}
+}
+
+func TestSharedCacheAcrossPolicies(t *testing.T) {
+	a := newTestCache(allowStub(0), 10, time.Minute, "")
+	denying := denyStub("malware", 0.9)
+	b := NewCachingChecker(denying, a.cache, "v2")
+	same := NewCachingChecker(denyStub("malware", 0.9), a.cache, "v1")
+	ctx := context.Background()
+
+	if v, _ := a.CheckPrompt(ctx, "hello"); !v.Allowed || v.Cached {
+		t.Fatalf("policy a: %+v", v)
+	}
+	// 策略版本不同，不命中其它策略的缓存
+	if v, _ := b.CheckPrompt(ctx, "hello"); v.Allowed || v.Cached || denying.calls != 1 {
+		t.Fatalf("policy b: %+v", v)
+	}
+	// 策略版本相同的策略共用缓存的结论
+	if v, _ := same.CheckPrompt(ctx, "hello"); !v.Allowed || !v.Cached {
+		t.Fatalf("policy with the same version: %+v", v)
+	}
+	if stats := a.cache.Stats(); stats.Entries != 2 || stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("shared stats = %+v, want 2 entries, 1 hit, 2 misses", stats)
+	}
+}
@@ -0,0 +1,386 @@
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// StageVerdict 表示组合检查器中一个阶段的结论
+type StageVerdict struct {
+	Name    string
+	Verdict *Verdict // 阶段被跳过时为空
+	Err     error
+}
+
+// chainStage 是组合检查器中的一个检查阶段
+type chainStage struct {
+	name    string
+	checker Checker
+	weight  float64
+}
+
+// ChainChecker 按组合方式依次或同时调用多个检查器，并把各阶段的结论附在最终结论中
+type ChainChecker struct {
+	strategy string
+	low      float64
+	high     float64
+	stages   []chainStage
+}
+
+// NewChainChecker 根据配置创建组合检查器，rules 用于 rules 类型的阶段
+func NewChainChecker(cfg config.AdmissionConfig, rules *RuleSet) (Checker, error) {
+	cc := &ChainChecker{
+		strategy: cfg.Chain.Strategy,
+		low:      cfg.Chain.CascadeLow,
+		high:     cfg.Chain.CascadeHigh,
+	}
+
+	for _, st := range cfg.Chain.Stages {
+		var checker Checker
+		switch st.Type {
+		case "ollama":
+			stageCfg := cfg
+			if st.ModelName != "" {
+				stageCfg.ModelName = st.ModelName
+			}
+			if st.OllamaURL != "" {
+				stageCfg.OllamaURL = st.OllamaURL
+			}
+			if st.Timeout > 0 {
+				stageCfg.Timeout = st.Timeout
+			}
+			checker = NewOllamaChecker(stageCfg)
+		case "http":
+			checker = NewHTTPChecker(st.Name, st.URL, time.Duration(st.Timeout)*time.Second)
+		case "rules":
+			if rules == nil {
+				return nil, fmt.Errorf("阶段 %s 为 rules 类型，但没有加载规则文件", st.Name)
+			}
+			checker = NewRuleChecker(rules, nil)
+		case "keywords":
+			checker = NewKeywordChecker(cfg.Policy.Categories)
+		case "knn":
+			var err error
+			if checker, err = newKNNStage(st, cfg); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("阶段 %s 的类型无效: %q", st.Name, st.Type)
+		}
+
+		weight := st.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		cc.stages = append(cc.stages, chainStage{name: st.Name, checker: checker, weight: weight})
+	}
+
+	log.Printf("[准入] 初始化组合检查器: 组合方式=%s, 阶段数=%d", cc.strategy, len(cc.stages))
+	return cc, nil
+}
+
+// CheckContent 检查内容是否合法
+func (cc *ChainChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	return cc.run(ctx, func(c Checker) (*Verdict, error) {
+		return c.CheckContent(ctx, content)
+	})
+}
+
+// CheckPrompt 检查提示词是否合法
+func (cc *ChainChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return cc.run(ctx, func(c Checker) (*Verdict, error) {
+		return c.CheckPrompt(ctx, prompt)
+	})
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (cc *ChainChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	return cc.run(ctx, func(c Checker) (*Verdict, error) {
+		return c.CheckChatMessages(ctx, messages)
+	})
+}
+
+// run 按组合方式调用各阶段并合并结论。任一阶段出错时返回错误，由外层按 failure_mode 处理
+func (cc *ChainChecker) run(ctx context.Context, check func(Checker) (*Verdict, error)) (*Verdict, error) {
+	started := time.Now()
+	results := make([]StageVerdict, len(cc.stages))
+	for i, st := range cc.stages {
+		results[i].Name = st.name
+	}
+
+	switch cc.strategy {
+	case "all_allow", "majority":
+		// 各阶段互不依赖，同时检查
+		var wg sync.WaitGroup
+		for i, st := range cc.stages {
+			wg.Add(1)
+			go func(i int, c Checker) {
+				defer wg.Done()
+				results[i].Verdict, results[i].Err = check(c)
+			}(i, st.checker)
+		}
+		wg.Wait()
+	default:
+		for i, st := range cc.stages {
+			// 超时后剩余的阶段没有检查，按出错处理
+			if err := ctx.Err(); err != nil {
+				results[i].Err = err
+				continue
+			}
+			results[i].Verdict, results[i].Err = check(st.checker)
+			if cc.final(results[i]) {
+				break
+			}
+		}
+	}
+
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			log.Printf("[组合] 阶段 %s 出错: %v", res.Name, res.Err)
+		case res.Verdict == nil:
+			log.Printf("[组合] 阶段 %s 已跳过", res.Name)
+		default:
+			log.Printf("[组合] 阶段 %s: 允许=%v, 无法确定=%v, 类别=%v, 置信度=%.2f",
+				res.Name, res.Verdict.Allowed, res.Verdict.Undecided, res.Verdict.Categories, res.Verdict.Score)
+		}
+	}
+
+	verdict, err := cc.combine(results)
+	verdict.Stages = results
+	verdict.Model = "chain:" + cc.strategy
+	verdict.Latency = time.Since(started)
+	return verdict, err
+}
+
+// final 判断依次检查时该阶段的结论是否可以结束检查
+func (cc *ChainChecker) final(res StageVerdict) bool {
+	if res.Err != nil || res.Verdict == nil {
+		return false
+	}
+	if cc.strategy == "cascade" {
+		return !res.Verdict.Undecided && (res.Verdict.Score <= cc.low || res.Verdict.Score >= cc.high)
+	}
+	return !res.Verdict.Allowed
+}
+
+// combine 合并各阶段的结论。任一阶段出错时仍返回按其余阶段合并的结论，同时返回错误；
+// all_allow 下没有给出结论的阶段同样视为出错
+func (cc *ChainChecker) combine(results []StageVerdict) (*Verdict, error) {
+	var valid []int
+	var errs []string
+	for i, res := range results {
+		switch {
+		case res.Err != nil:
+			errs = append(errs, fmt.Sprintf("%s: %v", res.Name, res.Err))
+		case res.Verdict != nil:
+			valid = append(valid, i)
+		case cc.strategy == "all_allow":
+			errs = append(errs, res.Name+": 没有给出结论")
+		}
+	}
+	if len(valid) == 0 {
+		return Allow(), fmt.Errorf("组合检查器的所有阶段均失败: %s", strings.Join(errs, "; "))
+	}
+
+	verdict := cc.decide(results, valid)
+	if len(errs) > 0 {
+		return verdict, fmt.Errorf("组合检查器的 %d/%d 个阶段失败: %s", len(errs), len(results), strings.Join(errs, "; "))
+	}
+	return verdict, nil
+}
+
+// decide 按组合方式合并给出结论的阶段
+func (cc *ChainChecker) decide(results []StageVerdict, valid []int) *Verdict {
+	switch cc.strategy {
+	case "cascade":
+		// 最后一个给出结论的阶段决定结果
+		v := *results[valid[len(valid)-1]].Verdict
+		return &v
+	case "majority":
+		var allowWeight, denyWeight, scoreSum, weightSum float64
+		var denied []*Verdict
+		for _, i := range valid {
+			v, w := results[i].Verdict, cc.stages[i].weight
+			// 无法确定的阶段不参与投票
+			if v.Undecided {
+				continue
+			}
+			scoreSum += v.Score * w
+			weightSum += w
+			if v.Allowed {
+				allowWeight += w
+			} else {
+				denyWeight += w
+				denied = append(denied, v)
+			}
+		}
+		if weightSum == 0 {
+			verdict := Allow()
+			verdict.Undecided = true
+			return verdict
+		}
+		verdict := mergeDenied(denied)
+		verdict.Allowed = denyWeight <= allowWeight
+		verdict.Score = scoreSum / weightSum
+		if verdict.Allowed {
+			verdict.Reason = ""
+		}
+		return verdict
+	case "all_allow":
+		// 每个阶段都必须明确允许，任一阶段拒绝或无法确定即拒绝
+		var denied []*Verdict
+		var undecided []string
+		var maxScore float64
+		for _, i := range valid {
+			v := results[i].Verdict
+			if !v.Allowed {
+				denied = append(denied, v)
+			} else if v.Undecided {
+				undecided = append(undecided, results[i].Name)
+			}
+			if v.Score > maxScore {
+				maxScore = v.Score
+			}
+		}
+		verdict := mergeDenied(denied)
+		if len(undecided) > 0 {
+			verdict.Allowed = false
+			rationale := "阶段 " + strings.Join(undecided, ", ") + " 无法确定"
+			if verdict.Rationale != "" {
+				rationale = verdict.Rationale + "; " + rationale
+			}
+			verdict.Rationale = rationale
+			verdict.Reason = verdictReason(verdict.Categories, verdict.Rationale)
+		}
+		if verdict.Allowed {
+			verdict.Score = maxScore
+		}
+		return verdict
+	default:
+		// first_deny：任一阶段拒绝即拒绝
+		var denied []*Verdict
+		for _, i := range valid {
+			if !results[i].Verdict.Allowed {
+				denied = append(denied, results[i].Verdict)
+			}
+		}
+		if len(denied) == 0 {
+			verdict := Allow()
+			verdict.Undecided = true
+			for _, i := range valid {
+				v := results[i].Verdict
+				if v.Score > verdict.Score {
+					verdict.Score = v.Score
+				}
+				verdict.Undecided = verdict.Undecided && v.Undecided
+			}
+			return verdict
+		}
+		return mergeDenied(denied)
+	}
+}
+
+// mergeDenied 合并拒绝的结论：类别取并集，置信度取最大值
+func mergeDenied(denied []*Verdict) *Verdict {
+	verdict := &Verdict{Allowed: len(denied) == 0}
+	seen := make(map[string]bool)
+	var rationales []string
+	for _, v := range denied {
+		for _, c := range v.Categories {
+			if !seen[c] {
+				seen[c] = true
+				verdict.Categories = append(verdict.Categories, c)
+			}
+		}
+		if v.Score > verdict.Score {
+			verdict.Score = v.Score
+		}
+		if v.Rationale != "" {
+			rationales = append(rationales, v.Rationale)
+		}
+		if verdict.RuleID == "" {
+			verdict.RuleID = v.RuleID
+		}
+	}
+	verdict.Rationale = strings.Join(rationales, "; ")
+	if !verdict.Allowed {
+		verdict.Reason = verdictReason(verdict.Categories, verdict.Rationale)
+	}
+	return verdict
+}
+
+// HTTPChecker 调用外部分类服务进行检查
+type HTTPChecker struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// NewHTTPChecker 创建一个外部分类服务检查器，服务接收 {"content": "..."}，
+// 返回与审核模型相同格式的JSON结论
+func NewHTTPChecker(name, url string, timeout time.Duration) Checker {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTPChecker{
+		name:   name,
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// CheckContent 检查内容是否合法
+func (hc *HTTPChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	started := time.Now()
+	data, _ := json.Marshal(map[string]string{"content": content})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hc.url, bytes.NewReader(data))
+	if err != nil {
+		return Allow(), fmt.Errorf("创建HTTP请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return Allow(), fmt.Errorf("调用分类服务 %s 失败: %w", hc.name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Allow(), fmt.Errorf("读取分类服务响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Allow(), fmt.Errorf("分类服务 %s 返回错误状态码: %d, 响应: %s", hc.name, resp.StatusCode, string(body))
+	}
+
+	verdict, err := parseVerdict(string(body), nil)
+	if verdict == nil {
+		return Allow(), err
+	}
+	verdict.RawOutput = string(body)
+	verdict.Model = hc.name
+	verdict.Latency = time.Since(started)
+	return verdict, nil
+}
+
+// CheckPrompt 检查提示词是否合法
+func (hc *HTTPChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return hc.CheckContent(ctx, prompt)
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (hc *HTTPChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	return hc.CheckContent(ctx, Transcript(messages))
+}
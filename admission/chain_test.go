@@ -0,0 +1,181 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// stubChecker 返回固定结论的检查器，并记录被调用的次数
+type stubChecker struct {
+	verdict *Verdict
+	err     error
+	calls   int
+}
+
+func (sc *stubChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	sc.calls++
+	if sc.verdict == nil {
+		return nil, sc.err
+	}
+	v := *sc.verdict
+	return &v, sc.err
+}
+
+func (sc *stubChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return sc.CheckContent(ctx, prompt)
+}
+
+func (sc *stubChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	return sc.CheckContent(ctx, Transcript(messages))
+}
+
+func allowStub(score float64) *stubChecker {
+	return &stubChecker{verdict: &Verdict{Allowed: true, Score: score}}
+}
+
+func denyStub(category string, score float64) *stubChecker {
+	return &stubChecker{verdict: &Verdict{Allowed: false, Categories: []string{category}, Score: score, Reason: category}}
+}
+
+func undecidedStub() *stubChecker {
+	return &stubChecker{verdict: &Verdict{Allowed: true, Undecided: true}}
+}
+
+func errorStub() *stubChecker {
+	return &stubChecker{verdict: Allow(), err: errors.New("timeout")}
+}
+
+func skippedStub() *stubChecker {
+	return &stubChecker{}
+}
+
+func newTestChain(strategy string, weights []float64, checkers ...*stubChecker) *ChainChecker {
+	cc := &ChainChecker{strategy: strategy, low: 0.2, high: 0.8}
+	for i, c := range checkers {
+		w := 1.0
+		if i < len(weights) {
+			w = weights[i]
+		}
+		cc.stages = append(cc.stages, chainStage{name: string(rune('a' + i)), checker: c, weight: w})
+	}
+	return cc
+}
+
+func TestChainCombine(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  string
+		weights   []float64
+		stages    []*stubChecker
+		allowed   bool
+		undecided bool
+		wantErr   bool
+		// calls 为每个阶段期望的调用次数，为空时不检查
+		calls []int
+	}{
+		{name: "first_deny/all allow", strategy: "first_deny", stages: []*stubChecker{allowStub(0.1), allowStub(0.3)}, allowed: true},
+		{name: "first_deny/deny stops", strategy: "first_deny", stages: []*stubChecker{denyStub("malware", 0.9), allowStub(0)}, allowed: false, calls: []int{1, 0}},
+		{name: "first_deny/deny after allow", strategy: "first_deny", stages: []*stubChecker{allowStub(0), denyStub("malware", 0.9)}, allowed: false},
+		{name: "first_deny/all undecided", strategy: "first_deny", stages: []*stubChecker{undecidedStub(), undecidedStub()}, allowed: true, undecided: true},
+		{name: "first_deny/error", strategy: "first_deny", stages: []*stubChecker{errorStub(), allowStub(0)}, allowed: true, wantErr: true},
+		{name: "first_deny/error then deny", strategy: "first_deny", stages: []*stubChecker{errorStub(), denyStub("malware", 0.9)}, allowed: false, wantErr: true},
+		{name: "first_deny/all error", strategy: "first_deny", stages: []*stubChecker{errorStub(), errorStub()}, allowed: true, wantErr: true},
+
+		{name: "all_allow/all allow", strategy: "all_allow", stages: []*stubChecker{allowStub(0.1), allowStub(0.2)}, allowed: true},
+		{name: "all_allow/deny", strategy: "all_allow", stages: []*stubChecker{allowStub(0), denyStub("weapons", 0.8)}, allowed: false},
+		{name: "all_allow/undecided", strategy: "all_allow", stages: []*stubChecker{allowStub(0), undecidedStub()}, allowed: false},
+		{name: "all_allow/error", strategy: "all_allow", stages: []*stubChecker{allowStub(0), errorStub()}, allowed: true, wantErr: true},
+		{name: "all_allow/skipped", strategy: "all_allow", stages: []*stubChecker{allowStub(0), skippedStub()}, allowed: true, wantErr: true},
+		{name: "all_allow/error and deny", strategy: "all_allow", stages: []*stubChecker{errorStub(), denyStub("weapons", 0.8)}, allowed: false, wantErr: true},
+
+		{name: "majority/allow wins", strategy: "majority", stages: []*stubChecker{allowStub(0), allowStub(0), denyStub("fraud", 0.9)}, allowed: true},
+		{name: "majority/deny wins", strategy: "majority", stages: []*stubChecker{allowStub(0), denyStub("fraud", 0.9), denyStub("fraud", 0.7)}, allowed: false},
+		{name: "majority/weighted deny", strategy: "majority", weights: []float64{1, 3}, stages: []*stubChecker{allowStub(0), denyStub("fraud", 0.9)}, allowed: false},
+		{name: "majority/undecided ignored", strategy: "majority", stages: []*stubChecker{undecidedStub(), undecidedStub(), denyStub("fraud", 0.9)}, allowed: false},
+		{name: "majority/all undecided", strategy: "majority", stages: []*stubChecker{undecidedStub(), undecidedStub()}, allowed: true, undecided: true},
+		{name: "majority/error", strategy: "majority", stages: []*stubChecker{allowStub(0), errorStub(), allowStub(0)}, allowed: true, wantErr: true},
+
+		{name: "cascade/confident allow", strategy: "cascade", stages: []*stubChecker{allowStub(0.1), denyStub("drugs", 0.9)}, allowed: true, calls: []int{1, 0}},
+		{name: "cascade/confident deny", strategy: "cascade", stages: []*stubChecker{denyStub("drugs", 0.9), allowStub(0)}, allowed: false, calls: []int{1, 0}},
+		{name: "cascade/uncertain escalates", strategy: "cascade", stages: []*stubChecker{allowStub(0.5), denyStub("drugs", 0.6)}, allowed: false, calls: []int{1, 1}},
+		{name: "cascade/undecided escalates", strategy: "cascade", stages: []*stubChecker{undecidedStub(), allowStub(0)}, allowed: true, calls: []int{1, 1}},
+		{name: "cascade/error escalates", strategy: "cascade", stages: []*stubChecker{errorStub(), denyStub("drugs", 0.9)}, allowed: false, wantErr: true, calls: []int{1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := newTestChain(tt.strategy, tt.weights, tt.stages...)
+			verdict, err := cc.CheckContent(context.Background(), "content")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if verdict == nil {
+				t.Fatal("verdict is nil")
+			}
+			if verdict.Allowed != tt.allowed {
+				t.Errorf("Allowed = %v, want %v", verdict.Allowed, tt.allowed)
+			}
+			if verdict.Undecided != tt.undecided {
+				t.Errorf("Undecided = %v, want %v", verdict.Undecided, tt.undecided)
+			}
+			if !verdict.Allowed && verdict.Reason == "" {
+				t.Error("denied verdict has empty Reason")
+			}
+			if len(verdict.Stages) != len(tt.stages) {
+				t.Errorf("len(Stages) = %d, want %d", len(verdict.Stages), len(tt.stages))
+			}
+			for i, want := range tt.calls {
+				if got := tt.stages[i].calls; got != want {
+					t.Errorf("stage %d called %d times, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestChainCanceledStagesFail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cc := newTestChain("first_deny", nil, allowStub(0), allowStub(0))
+	verdict, err := cc.CheckContent(ctx, "content")
+	if err == nil {
+		t.Fatal("expected error when the context is canceled")
+	}
+	for _, st := range verdict.Stages {
+		if !errors.Is(st.Err, context.Canceled) {
+			t.Errorf("stage %s Err = %v, want context.Canceled", st.Name, st.Err)
+		}
+	}
+}
+
+func TestFailSafeChainPartialFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		stages  []*stubChecker
+		allowed bool
+	}{
+		{name: "open/allow", mode: "open", stages: []*stubChecker{errorStub(), allowStub(0)}, allowed: true},
+		{name: "open/keeps deny", mode: "open", stages: []*stubChecker{errorStub(), denyStub("malware", 0.9)}, allowed: false},
+		{name: "closed/denies", mode: "closed", stages: []*stubChecker{errorStub(), allowStub(0)}, allowed: false},
+		{name: "rules/fallback", mode: "rules", stages: []*stubChecker{errorStub(), allowStub(0)}, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain("all_allow", nil, tt.stages...)
+			checker := NewFailSafeChecker(chain, tt.mode, denyStub("fallback", 1))
+			verdict, err := checker.CheckContent(context.Background(), "content")
+			if err == nil {
+				t.Fatal("expected the stage error to be returned")
+			}
+			if verdict.Allowed != tt.allowed {
+				t.Errorf("Allowed = %v, want %v", verdict.Allowed, tt.allowed)
+			}
+			if verdict.FailureMode != tt.mode {
+				t.Errorf("FailureMode = %q, want %q", verdict.FailureMode, tt.mode)
+			}
+		})
+	}
+}
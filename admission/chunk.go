@@ -0,0 +1,145 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WindowVerdict 表示超长内容中一个窗口的结论
+type WindowVerdict struct {
+	Index   int
+	Offset  int      // 窗口在内容中的起始位置（字符）
+	Chars   int      // 窗口的字符数
+	Verdict *Verdict // 已有窗口被拒绝、检查提前结束时为空
+	Err     error
+}
+
+// window 为待检查的一个窗口
+type window struct {
+	offset int
+	text   string
+}
+
+// splitWindows 按字符数把内容切分为相互重叠的窗口，重叠部分保证跨越窗口边界的内容至少完整出现在一个窗口中。
+// size 不大于0或内容不超过 size 时返回整个内容
+func splitWindows(content string, size, overlap int) []window {
+	runes := []rune(content)
+	if size <= 0 || len(runes) <= size {
+		return []window{{text: content}}
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	var windows []window
+	for start := 0; ; start += size - overlap {
+		end := start + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		windows = append(windows, window{offset: start, text: string(runes[start:end])})
+		if end == len(runes) {
+			break
+		}
+	}
+	return windows
+}
+
+// windowCount 返回 chars 个字符的内容按 splitWindows 切分后的窗口数
+func windowCount(chars, size, overlap int) int {
+	if size <= 0 || chars <= size {
+		return 1
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+	step := size - overlap
+	return (chars-size+step-1)/step + 1
+}
+
+// checkWindows 使用有限的并发数同时检查各窗口，任一窗口拒绝即拒绝并停止检查剩余的窗口
+func (oc *OllamaChecker) checkWindows(ctx context.Context, windows []window) (*Verdict, error) {
+	started := time.Now()
+	workers := oc.config.Chunking.MaxWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	log.Printf("[准入] 内容超过窗口大小，分为 %d 个窗口检查, 并发数=%d", len(windows), workers)
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]WindowVerdict, len(windows))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(windows); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if wctx.Err() != nil {
+					results[i].Err = ctx.Err()
+					continue
+				}
+				verdict, err := oc.checkWindow(wctx, windows[i].text)
+				// 因其它窗口被拒绝而取消的检查视为跳过
+				if err != nil && wctx.Err() != nil && ctx.Err() == nil {
+					continue
+				}
+				results[i].Verdict, results[i].Err = verdict, err
+				if err == nil && !verdict.Allowed {
+					cancel()
+				}
+			}
+		}()
+	}
+	for i, w := range windows {
+		results[i].Index = i
+		results[i].Offset = w.offset
+		results[i].Chars = len([]rune(w.text))
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var denied []*Verdict
+	var errs []string
+	var maxScore float64
+	for _, res := range results {
+		switch {
+		case res.Err != nil:
+			log.Printf("[准入] 窗口 %d 检查失败: %v", res.Index, res.Err)
+			errs = append(errs, fmt.Sprintf("窗口 %d: %v", res.Index, res.Err))
+		case res.Verdict == nil:
+			log.Printf("[准入] 窗口 %d 已跳过", res.Index)
+		default:
+			log.Printf("[准入] 窗口 %d: 偏移=%d, 字符数=%d, 允许=%v, 类别=%v, 置信度=%.2f",
+				res.Index, res.Offset, res.Chars, res.Verdict.Allowed, res.Verdict.Categories, res.Verdict.Score)
+			if !res.Verdict.Allowed {
+				denied = append(denied, res.Verdict)
+			}
+			if res.Verdict.Score > maxScore {
+				maxScore = res.Verdict.Score
+			}
+		}
+	}
+
+	verdict := mergeDenied(denied)
+	verdict.Model = oc.config.ModelName
+	verdict.Windows = results
+	verdict.Latency = time.Since(started)
+
+	// 有窗口被拒绝时结论已经确定；否则只要有窗口没有检查成功，就不能认为整个内容合规
+	var err error
+	if verdict.Allowed {
+		verdict.Score = maxScore
+		if len(errs) > 0 {
+			err = fmt.Errorf("%d/%d 个窗口检查失败: %s", len(errs), len(windows), strings.Join(errs, "; "))
+		}
+	}
+	return verdict, err
+}
@@ -0,0 +1,565 @@
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Metrics 表示Ollama响应中的耗时统计字段
+type Metrics struct {
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	LoadDuration       int64 `json:"load_duration,omitempty"`
+	PromptEvalCount    int   `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int   `json:"eval_count,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+}
+
+// TimingProfile 记录真实上游响应的耗时特征，用于让拒绝响应的统计字段和输出节奏看起来真实
+type TimingProfile struct {
+	mu     sync.Mutex
+	models map[string]*modelTiming
+	global modelTiming
+}
+
+// modelTiming 是单个模型的耗时特征，使用指数滑动平均
+type modelTiming struct {
+	samples          int
+	loadDuration     float64 // 纳秒
+	promptNsPerToken float64
+	evalNsPerToken   float64
+	embeddingDim     int
+}
+
+// 没有观测数据时使用的默认值，大致对应消费级显卡上的7B模型
+var defaultTiming = modelTiming{
+	loadDuration:     15e6,
+	promptNsPerToken: 1.5e6,
+	evalNsPerToken:   25e6,
+	embeddingDim:     768,
+}
+
+const timingAlpha = 0.2
+
+// NewTimingProfile 创建耗时特征记录器
+func NewTimingProfile() *TimingProfile {
+	return &TimingProfile{
+		models: make(map[string]*modelTiming),
+		global: defaultTiming,
+	}
+}
+
+// ObserveResponse 从上游的非流式响应或流式响应的最后一个片段中提取耗时统计
+func (t *TimingProfile) ObserveResponse(body []byte) {
+	if t == nil {
+		return
+	}
+
+	var resp struct {
+		Model      string      `json:"model"`
+		Done       *bool       `json:"done"`
+		Embeddings [][]float64 `json:"embeddings"`
+		Embedding  []float64   `json:"embedding"`
+		Metrics
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(body), &resp); err != nil {
+		return
+	}
+
+	dim := 0
+	if len(resp.Embeddings) > 0 {
+		dim = len(resp.Embeddings[0])
+	} else if len(resp.Embedding) > 0 {
+		dim = len(resp.Embedding)
+	}
+	if (resp.Done == nil || !*resp.Done) && dim == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, mt := range []*modelTiming{&t.global, t.model(resp.Model)} {
+		mt.observe(resp.Metrics, dim)
+	}
+}
+
+// model 返回模型对应的耗时特征，调用方需持有锁
+func (t *TimingProfile) model(name string) *modelTiming {
+	mt, ok := t.models[name]
+	if !ok {
+		mt = &modelTiming{}
+		*mt = t.global
+		mt.samples = 0
+		t.models[name] = mt
+	}
+	return mt
+}
+
+func (mt *modelTiming) observe(m Metrics, dim int) {
+	update := func(cur *float64, v float64) {
+		if mt.samples == 0 {
+			*cur = v
+		} else {
+			*cur = (1-timingAlpha)*(*cur) + timingAlpha*v
+		}
+	}
+
+	if m.LoadDuration > 0 {
+		update(&mt.loadDuration, float64(m.LoadDuration))
+	}
+	if m.PromptEvalCount > 0 && m.PromptEvalDuration > 0 {
+		update(&mt.promptNsPerToken, float64(m.PromptEvalDuration)/float64(m.PromptEvalCount))
+	}
+	if m.EvalCount > 0 && m.EvalDuration > 0 {
+		update(&mt.evalNsPerToken, float64(m.EvalDuration)/float64(m.EvalCount))
+	}
+	if dim > 0 {
+		mt.embeddingDim = dim
+	}
+	mt.samples++
+}
+
+// Estimate 根据观测到的耗时特征估算一次生成的统计字段，并加入少量随机抖动
+func (t *TimingProfile) Estimate(model string, promptTokens, evalTokens int) Metrics {
+	mt := t.snapshot(model)
+
+	vary := func(v float64) int64 {
+		return int64(v * (0.9 + rand.Float64()*0.2))
+	}
+
+	m := Metrics{
+		LoadDuration:       vary(mt.loadDuration),
+		PromptEvalCount:    promptTokens,
+		PromptEvalDuration: vary(mt.promptNsPerToken * float64(promptTokens)),
+		EvalCount:          evalTokens,
+		EvalDuration:       vary(mt.evalNsPerToken * float64(evalTokens)),
+	}
+	// 总耗时还包含少量调度开销
+	m.TotalDuration = m.LoadDuration + m.PromptEvalDuration + m.EvalDuration + vary(2e6)
+	return m
+}
+
+// EmbeddingDim 返回模型的嵌入向量维度
+func (t *TimingProfile) EmbeddingDim(model string) int {
+	return t.snapshot(model).embeddingDim
+}
+
+func (t *TimingProfile) snapshot(model string) modelTiming {
+	if t == nil {
+		return defaultTiming
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if mt, ok := t.models[model]; ok && mt.samples > 0 {
+		return *mt
+	}
+	return t.global
+}
+
+// DenialRequest 描述需要生成拒绝响应的请求
+type DenialRequest struct {
+	Path         string
+	Model        string
+	Stream       bool
+	Reason       string
+	PromptTokens int
+	Inputs       int // 嵌入接口的输入条数
+	// Message 为回复给客户端的文本，为空时使用 DeniedMessage
+	Message string
+}
+
+// NewDenialRequest 从原始请求中提取生成拒绝响应所需的信息
+func NewDenialRequest(path string, body []byte, reason string) DenialRequest {
+	req := DenialRequest{
+		Path:   path,
+		Reason: reason,
+		Inputs: 1,
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return req
+	}
+
+	req.Model, _ = data["model"].(string)
+
+	// Ollama的generate和chat接口默认流式输出，OpenAI接口默认非流式
+	if stream, ok := data["stream"].(bool); ok {
+		req.Stream = stream
+	} else {
+		req.Stream = path == "/api/generate" || path == "/api/chat"
+	}
+
+	var text strings.Builder
+	for _, key := range []string{"system", "prompt"} {
+		if s, ok := data[key].(string); ok {
+			text.WriteString(s)
+		}
+	}
+	if messages, ok := data["messages"].([]interface{}); ok {
+		for _, m := range messages {
+			if msg, ok := m.(map[string]interface{}); ok {
+				if s, ok := msg["content"].(string); ok {
+					text.WriteString(s)
+				}
+			}
+		}
+	}
+	switch input := data["input"].(type) {
+	case string:
+		text.WriteString(input)
+	case []interface{}:
+		req.Inputs = len(input)
+		for _, v := range input {
+			if s, ok := v.(string); ok {
+				text.WriteString(s)
+			}
+		}
+	}
+
+	// 对话模板会额外增加一些token
+	req.PromptTokens = CountTokens(text.String()) + 10
+	return req
+}
+
+// Denier 根据请求的接口和输出方式生成拒绝响应
+type Denier struct {
+	timing *TimingProfile
+}
+
+// NewDenier 创建拒绝响应生成器
+func NewDenier(timing *TimingProfile) *Denier {
+	return &Denier{timing: timing}
+}
+
+// DeniedMessage 返回拒绝时回复给客户端的文本
+func DeniedMessage(reason string) string {
+	return fmt.Sprintf("很抱歉，我无法处理您的请求。原因：%s", reason)
+}
+
+// DenialTemplate 按模板生成拒绝时回复给客户端的文本，不同的准入策略可以使用不同的话术
+type DenialTemplate struct {
+	tmpl *template.Template
+}
+
+// NewDenialTemplate 解析拒绝文本模板，模板可使用 {{.Reason}}、{{.Model}}，text 为空时使用 DeniedMessage
+func NewDenialTemplate(text string) (*DenialTemplate, error) {
+	if text == "" {
+		return &DenialTemplate{}, nil
+	}
+	tmpl, err := template.New("denial").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("解析拒绝文本模板失败: %w", err)
+	}
+	return &DenialTemplate{tmpl: tmpl}, nil
+}
+
+// Message 生成拒绝文本，模板执行失败时使用 DeniedMessage
+func (t *DenialTemplate) Message(reason, model string) string {
+	if t == nil || t.tmpl == nil {
+		return DeniedMessage(reason)
+	}
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, struct{ Reason, Model string }{reason, model}); err != nil {
+		return DeniedMessage(reason)
+	}
+	return b.String()
+}
+
+// WriteResponse 输出与请求接口格式一致的拒绝响应，started 为收到请求的时间，
+// 用于让整体耗时与统计字段相符
+func (d *Denier) WriteResponse(ctx context.Context, w http.ResponseWriter, req DenialRequest, started time.Time) {
+	message := req.Message
+	if message == "" {
+		message = DeniedMessage(req.Reason)
+	}
+	tokens := SplitTokens(message)
+	metrics := d.timing.Estimate(req.Model, req.PromptTokens, len(tokens))
+
+	switch req.Path {
+	case "/api/embed", "/api/embeddings", "/v1/embeddings":
+		d.writeEmbeddings(ctx, w, req, metrics, started)
+		return
+	case "/v1/chat/completions", "/v1/completions":
+		d.writeOpenAI(ctx, w, req, message, tokens, metrics, started)
+		return
+	}
+
+	newChunk := func(text string, final *Metrics) interface{} {
+		return chatChunk(req.Model, text, final)
+	}
+	if req.Path == "/api/generate" {
+		newChunk = func(text string, final *Metrics) interface{} {
+			return generateChunk(req.Model, text, final)
+		}
+	}
+
+	if !req.Stream {
+		// 等待到与统计字段相符的总耗时后再返回
+		WaitUntil(ctx, started.Add(time.Duration(metrics.TotalDuration)))
+		writeJSON(w, "application/json; charset=utf-8", newChunk(message, &metrics))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	WaitUntil(ctx, started.Add(time.Duration(metrics.LoadDuration+metrics.PromptEvalDuration)))
+	interval := time.Duration(metrics.EvalDuration / int64(max(len(tokens), 1)))
+	for _, tok := range tokens {
+		if !SleepJitter(ctx, interval) {
+			return
+		}
+		if err := enc.Encode(newChunk(tok, nil)); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+	enc.Encode(newChunk("", &metrics))
+	rc.Flush()
+}
+
+// 以下结构体保持与Ollama一致的JSON字段顺序
+
+type ollamaGenerateChunk struct {
+	Model      string `json:"model"`
+	CreatedAt  string `json:"created_at"`
+	Response   string `json:"response"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+	Context    []int  `json:"context,omitempty"`
+	Metrics
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatChunk struct {
+	Model      string        `json:"model"`
+	CreatedAt  string        `json:"created_at"`
+	Message    ollamaMessage `json:"message"`
+	DoneReason string        `json:"done_reason,omitempty"`
+	Done       bool          `json:"done"`
+	Metrics
+}
+
+func generateChunk(model, text string, final *Metrics) interface{} {
+	chunk := ollamaGenerateChunk{
+		Model:     model,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Response:  text,
+	}
+	if final != nil {
+		chunk.Done = true
+		chunk.DoneReason = "stop"
+		chunk.Metrics = *final
+		chunk.Context = make([]int, final.PromptEvalCount+final.EvalCount)
+		for i := range chunk.Context {
+			chunk.Context[i] = 100 + rand.Intn(128000)
+		}
+	}
+	return chunk
+}
+
+func chatChunk(model, text string, final *Metrics) interface{} {
+	chunk := ollamaChatChunk{
+		Model:     model,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Message:   ollamaMessage{Role: "assistant", Content: text},
+	}
+	if final != nil {
+		chunk.Done = true
+		chunk.DoneReason = "stop"
+		chunk.Metrics = *final
+	}
+	return chunk
+}
+
+// writeEmbeddings 输出随机的单位向量，维度与上游观测到的一致
+func (d *Denier) writeEmbeddings(ctx context.Context, w http.ResponseWriter, req DenialRequest, metrics Metrics, started time.Time) {
+	dim := d.timing.EmbeddingDim(req.Model)
+	vectors := make([][]float64, req.Inputs)
+	for i := range vectors {
+		vectors[i] = randomUnitVector(dim)
+	}
+
+	loadAndPrompt := metrics.LoadDuration + metrics.PromptEvalDuration
+	WaitUntil(ctx, started.Add(time.Duration(loadAndPrompt)))
+
+	switch req.Path {
+	case "/api/embeddings":
+		writeJSON(w, "application/json; charset=utf-8", struct {
+			Embedding []float64 `json:"embedding"`
+		}{vectors[0]})
+	case "/v1/embeddings":
+		type embedding struct {
+			Object    string    `json:"object"`
+			Embedding []float64 `json:"embedding"`
+			Index     int       `json:"index"`
+		}
+		data := make([]embedding, len(vectors))
+		for i, v := range vectors {
+			data[i] = embedding{Object: "embedding", Embedding: v, Index: i}
+		}
+		type usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+			TotalTokens  int `json:"total_tokens"`
+		}
+		writeJSON(w, "application/json", struct {
+			Object string      `json:"object"`
+			Data   []embedding `json:"data"`
+			Model  string      `json:"model"`
+			Usage  usage       `json:"usage"`
+		}{"list", data, req.Model, usage{req.PromptTokens, req.PromptTokens}})
+	default:
+		writeJSON(w, "application/json; charset=utf-8", struct {
+			Model           string      `json:"model"`
+			Embeddings      [][]float64 `json:"embeddings"`
+			TotalDuration   int64       `json:"total_duration"`
+			LoadDuration    int64       `json:"load_duration"`
+			PromptEvalCount int         `json:"prompt_eval_count"`
+		}{req.Model, vectors, loadAndPrompt + 1e6, metrics.LoadDuration, req.PromptTokens})
+	}
+}
+
+func randomUnitVector(dim int) []float64 {
+	v := make([]float64, dim)
+	var norm float64
+	for i := range v {
+		v[i] = rand.NormFloat64()
+		norm += v[i] * v[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+// 以下结构体保持与OpenAI一致的JSON字段顺序
+
+type openAIMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+type openAIChoice struct {
+	Text         *string        `json:"text,omitempty"`
+	Index        int            `json:"index"`
+	Message      *openAIMessage `json:"message,omitempty"`
+	Delta        *openAIMessage `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIResponse struct {
+	ID                string         `json:"id"`
+	Object            string         `json:"object"`
+	Created           int64          `json:"created"`
+	Model             string         `json:"model"`
+	SystemFingerprint string         `json:"system_fingerprint"`
+	Choices           []openAIChoice `json:"choices"`
+	Usage             *openAIUsage   `json:"usage,omitempty"`
+}
+
+// writeOpenAI 输出OpenAI格式的拒绝响应，流式请求以SSE事件输出
+func (d *Denier) writeOpenAI(ctx context.Context, w http.ResponseWriter, req DenialRequest, message string,
+	tokens []string, metrics Metrics, started time.Time) {
+	isChat := req.Path == "/v1/chat/completions"
+	stop := "stop"
+	usage := &openAIUsage{
+		PromptTokens:     metrics.PromptEvalCount,
+		CompletionTokens: metrics.EvalCount,
+		TotalTokens:      metrics.PromptEvalCount + metrics.EvalCount,
+	}
+
+	response := openAIResponse{
+		ID:                fmt.Sprintf("chatcmpl-%d", rand.Intn(999)),
+		Object:            "chat.completion",
+		Created:           time.Now().Unix(),
+		Model:             req.Model,
+		SystemFingerprint: "fp_ollama",
+	}
+	if !isChat {
+		response.ID = fmt.Sprintf("cmpl-%d", rand.Intn(999))
+		response.Object = "text_completion"
+	}
+
+	choice := func(text string, finish *string, full bool) openAIChoice {
+		c := openAIChoice{FinishReason: finish}
+		switch {
+		case !isChat:
+			c.Text = &text
+		case full:
+			c.Message = &openAIMessage{Role: "assistant", Content: text}
+		default:
+			c.Delta = &openAIMessage{Role: "assistant", Content: text}
+		}
+		return c
+	}
+
+	if !req.Stream {
+		WaitUntil(ctx, started.Add(time.Duration(metrics.TotalDuration)))
+		response.Choices = []openAIChoice{choice(message, &stop, true)}
+		response.Usage = usage
+		writeJSON(w, "application/json", response)
+		return
+	}
+
+	if isChat {
+		response.Object = "chat.completion.chunk"
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	writeEvent := func(v interface{}) {
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		rc.Flush()
+	}
+
+	WaitUntil(ctx, started.Add(time.Duration(metrics.LoadDuration+metrics.PromptEvalDuration)))
+	interval := time.Duration(metrics.EvalDuration / int64(max(len(tokens), 1)))
+	for _, tok := range tokens {
+		if !SleepJitter(ctx, interval) {
+			return
+		}
+		response.Choices = []openAIChoice{choice(tok, nil, false)}
+		writeEvent(response)
+	}
+	response.Choices = []openAIChoice{choice("", &stop, false)}
+	writeEvent(response)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	rc.Flush()
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
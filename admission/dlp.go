@@ -0,0 +1,84 @@
+package admission
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/dlp"
+)
+
+// DLPChecker 把敏感数据作为准入信号：内容中出现 deny 类型的敏感数据时直接拒绝，
+// 否则交给内部检查器，识别出的类型记录在结论中
+type DLPChecker struct {
+	inner   Checker
+	scanner *dlp.Scanner
+	deny    map[string]bool
+}
+
+// NewDLPChecker 创建一个敏感数据检查器，denyTypes 为需要拒绝的敏感数据类型
+func NewDLPChecker(inner Checker, scanner *dlp.Scanner, denyTypes []string) Checker {
+	dc := &DLPChecker{
+		inner:   inner,
+		scanner: scanner,
+		deny:    make(map[string]bool),
+	}
+	for _, t := range denyTypes {
+		dc.deny[t] = true
+	}
+	return dc
+}
+
+// CheckContent 检查内容是否合法
+func (dc *DLPChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	return dc.check(content, func() (*Verdict, error) {
+		return dc.inner.CheckContent(ctx, content)
+	})
+}
+
+// CheckPrompt 检查提示词是否合法
+func (dc *DLPChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return dc.check(prompt, func() (*Verdict, error) {
+		return dc.inner.CheckPrompt(ctx, prompt)
+	})
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (dc *DLPChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	return dc.check(Transcript(messages), func() (*Verdict, error) {
+		return dc.inner.CheckChatMessages(ctx, messages)
+	})
+}
+
+// check 识别敏感数据，命中需要拒绝的类型时不再调用内部检查器
+func (dc *DLPChecker) check(content string, next func() (*Verdict, error)) (*Verdict, error) {
+	started := time.Now()
+	types := dlp.FindingTypes(dc.scanner.Scan(content))
+
+	var denied []string
+	for _, t := range types {
+		if dc.deny[t] {
+			denied = append(denied, t)
+		}
+	}
+	if len(denied) > 0 {
+		log.Printf("[DLP] 内容包含敏感数据，拒绝: %v", denied)
+		return &Verdict{
+			Allowed:    false,
+			Reason:     "privacy",
+			Categories: []string{"privacy"},
+			Score:      1,
+			Rationale:  "包含敏感数据: " + strings.Join(denied, ", "),
+			Model:      "dlp",
+			Latency:    time.Since(started),
+			DLPTypes:   types,
+		}, nil
+	}
+
+	verdict, err := next()
+	if verdict != nil {
+		verdict.DLPTypes = types
+	}
+	return verdict, err
+}
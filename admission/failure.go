@@ -0,0 +1,158 @@
+package admission
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// FailSafeChecker 在内部检查器出错时按 failure_mode 决定结论：
+// open 放行，closed 拒绝，rules 降级为规则检查。错误仍会返回给调用方用于记录
+type FailSafeChecker struct {
+	inner    Checker
+	mode     string
+	fallback Checker
+}
+
+// NewFailSafeChecker 创建一个按 failure_mode 处理错误的检查器，mode 为 rules 时使用 fallback 检查
+func NewFailSafeChecker(inner Checker, mode string, fallback Checker) Checker {
+	return &FailSafeChecker{
+		inner:    inner,
+		mode:     mode,
+		fallback: fallback,
+	}
+}
+
+// CheckContent 检查内容是否合法
+func (fc *FailSafeChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	verdict, err := fc.inner.CheckContent(ctx, content)
+	return fc.resolve(verdict, err, func() (*Verdict, error) {
+		return fc.fallback.CheckContent(ctx, content)
+	})
+}
+
+// CheckPrompt 检查提示词是否合法
+func (fc *FailSafeChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	verdict, err := fc.inner.CheckPrompt(ctx, prompt)
+	return fc.resolve(verdict, err, func() (*Verdict, error) {
+		return fc.fallback.CheckPrompt(ctx, prompt)
+	})
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (fc *FailSafeChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	verdict, err := fc.inner.CheckChatMessages(ctx, messages)
+	return fc.resolve(verdict, err, func() (*Verdict, error) {
+		return fc.fallback.CheckChatMessages(ctx, messages)
+	})
+}
+
+// resolve 在出错时按 failure_mode 改写结论
+func (fc *FailSafeChecker) resolve(verdict *Verdict, err error, fallback func() (*Verdict, error)) (*Verdict, error) {
+	if err == nil {
+		return verdict, nil
+	}
+	if verdict == nil {
+		verdict = Allow()
+	}
+	// 组合检查器部分阶段出错时，其余阶段已经给出的拒绝结论不受 failure_mode 影响
+	if !verdict.Allowed {
+		log.Printf("[准入] 审核部分失败，保持已有的拒绝结论: %v", err)
+		verdict.FailureMode = fc.mode
+		return verdict, err
+	}
+
+	switch fc.mode {
+	case "closed":
+		log.Printf("[准入] 审核失败，按 failure_mode=closed 拒绝请求: %v", err)
+		verdict.Allowed = false
+		verdict.Reason = "审核服务暂时不可用"
+	case "rules":
+		fv, ferr := fallback()
+		if ferr != nil || fv == nil {
+			// 规则检查同样失败时放行，与 open 一致
+			log.Printf("[准入] 审核失败且规则检查失败，放行请求: %v, %v", err, ferr)
+			verdict.Allowed = true
+			verdict.Reason = ""
+			break
+		}
+		log.Printf("[准入] 审核失败，降级为规则检查: 允许=%v, 原因=%s", fv.Allowed, fv.Reason)
+		fv.RawOutput = verdict.RawOutput
+		fv.Latency += verdict.Latency
+		verdict = fv
+	default:
+		log.Printf("[准入] 审核失败，按 failure_mode=open 放行请求: %v", err)
+		verdict.Allowed = true
+		verdict.Reason = ""
+	}
+	verdict.FailureMode = fc.mode
+	return verdict, err
+}
+
+// defaultKeywords 为内置的关键词规则，按违规类别划分
+var defaultKeywords = map[string][]string{
+	"weapons":          {"bomb", "explosive", "detonator", "napalm", "炸弹", "炸药", "雷管", "枪支", "爆炸物"},
+	"malware":          {"ransomware", "keylogger", "reverse shell", "rootkit", "botnet", "勒索软件", "木马", "病毒程序", "反弹shell"},
+	"self-harm":        {"suicide", "kill myself", "self-harm", "自杀", "自残", "割腕"},
+	"prompt-injection": {"ignore previous instructions", "ignore all previous", "system prompt", "jailbreak", "dan mode", "忽略之前", "忽略以上", "系统提示词", "越狱"},
+	"violence":         {"terrorist attack", "mass shooting", "恐怖袭击", "杀人"},
+	"drugs":            {"methamphetamine", "synthesize meth", "cocaine", "heroin", "冰毒", "制毒", "海洛因"},
+	"fraud":            {"phishing", "credit card dump", "钓鱼网站", "诈骗话术"},
+	"privacy":          {"doxx", "social security number", "身份证号", "开房记录"},
+}
+
+// KeywordChecker 使用内置关键词进行检查，审核模型不可用时作为降级方案
+type KeywordChecker struct {
+	categories []string
+	keywords   map[string][]string
+}
+
+// NewKeywordChecker 创建一个关键词检查器，只检查启用的类别
+func NewKeywordChecker(categories []string) Checker {
+	kc := &KeywordChecker{keywords: make(map[string][]string)}
+	for _, c := range categories {
+		if words, ok := defaultKeywords[c]; ok {
+			kc.categories = append(kc.categories, c)
+			kc.keywords[c] = words
+		}
+	}
+	return kc
+}
+
+// CheckContent 检查内容是否合法
+func (kc *KeywordChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	lower := strings.ToLower(content)
+	verdict := &Verdict{Allowed: true, Model: "keywords"}
+	for _, category := range kc.categories {
+		for _, w := range kc.keywords[category] {
+			if strings.Contains(lower, w) {
+				verdict.Categories = append(verdict.Categories, category)
+				verdict.Rationale = "命中关键词: " + w
+				break
+			}
+		}
+	}
+	if len(verdict.Categories) > 0 {
+		verdict.Allowed = false
+		verdict.Score = 1
+		verdict.Reason = verdictReason(verdict.Categories, "")
+	} else {
+		// 没有命中关键词不代表内容合规
+		verdict.Undecided = true
+	}
+	return verdict, nil
+}
+
+// CheckPrompt 检查提示词是否合法
+func (kc *KeywordChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return kc.CheckContent(ctx, prompt)
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (kc *KeywordChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	var contents []string
+	for _, msg := range messages {
+		contents = append(contents, msg.Content)
+	}
+	return kc.CheckContent(ctx, strings.Join(contents, "\n"))
+}
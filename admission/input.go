@@ -0,0 +1,164 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// 待审核内容的类型，决定调用 Checker 的哪个方法
+const (
+	InputPrompt  = "prompt"  // 单条提示词，调用 CheckPrompt
+	InputChat    = "chat"    // 多轮对话，调用 CheckChatMessages
+	InputContent = "content" // 其它内容，调用 CheckContent
+)
+
+// Input 表示从请求中提取出的待审核内容，不包含字段名、参数和图片等与审核无关的数据
+type Input struct {
+	Kind     string
+	Text     string    // prompt 和 content 类型的文本
+	Messages []Message // chat 类型的对话
+}
+
+// Empty 判断是否没有需要审核的内容，例如只用于加载模型的空提示词
+func (in Input) Empty() bool {
+	if in.Kind == InputChat {
+		for _, m := range in.Messages {
+			if strings.TrimSpace(m.Content) != "" {
+				return false
+			}
+		}
+		return true
+	}
+	return strings.TrimSpace(in.Text) == ""
+}
+
+// String 返回审核的全部文本，用于日志记录
+func (in Input) String() string {
+	if in.Kind == InputChat {
+		return Transcript(in.Messages)
+	}
+	return in.Text
+}
+
+// ExtractInput 按接口解析请求体，提取需要审核的内容。无法识别的接口或无法解析的请求体按原样审核
+func ExtractInput(path string, body []byte) Input {
+	raw := Input{Kind: InputContent, Text: string(body)}
+
+	var req struct {
+		Prompt   interface{}       `json:"prompt"`
+		System   string            `json:"system"`
+		Suffix   string            `json:"suffix"`
+		Input    interface{}       `json:"input"`
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return raw
+	}
+
+	switch path {
+	case "/api/generate", "/v1/completions":
+		prompt := joinText(req.Prompt)
+		if req.Suffix != "" {
+			prompt += "\n" + req.Suffix
+		}
+		if req.System == "" {
+			return Input{Kind: InputPrompt, Text: prompt}
+		}
+		return Input{Kind: InputChat, Messages: []Message{
+			{Role: "system", Content: req.System},
+			{Role: "user", Content: prompt},
+		}}
+	case "/api/chat", "/v1/chat/completions":
+		in := Input{Kind: InputChat}
+		for _, m := range req.Messages {
+			in.Messages = append(in.Messages, parseMessage(m))
+		}
+		return in
+	case "/api/embed", "/v1/embeddings":
+		return Input{Kind: InputContent, Text: joinText(req.Input)}
+	case "/api/embeddings":
+		return Input{Kind: InputContent, Text: joinText(req.Prompt)}
+	}
+	return raw
+}
+
+// CheckInput 按内容类型调用对应的检查方法，没有需要审核的内容时直接允许
+func CheckInput(ctx context.Context, checker Checker, in Input) (*Verdict, error) {
+	if in.Empty() {
+		return Allow(), nil
+	}
+	switch in.Kind {
+	case InputPrompt:
+		return checker.CheckPrompt(ctx, in.Text)
+	case InputChat:
+		return checker.CheckChatMessages(ctx, in.Messages)
+	default:
+		return checker.CheckContent(ctx, in.Text)
+	}
+}
+
+// Transcript 将多轮对话整理为带角色标记的文本，审核模型据此判断整个对话，
+// 而不只是最后一条消息，用于识别逐步诱导的越狱
+func Transcript(messages []Message) string {
+	var b strings.Builder
+	for i, m := range messages {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n%s", m.Role, m.Content)
+	}
+	return b.String()
+}
+
+// parseMessage 解析Ollama或OpenAI格式的消息，工具调用转换为文本一并审核
+func parseMessage(data json.RawMessage) Message {
+	var m struct {
+		Role      string      `json:"role"`
+		Content   interface{} `json:"content"`
+		ToolCalls []struct {
+			Function struct {
+				Name      string      `json:"name"`
+				Arguments interface{} `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Message{Role: "unknown", Content: string(data)}
+	}
+
+	content := joinText(m.Content)
+	for _, tc := range m.ToolCalls {
+		// Ollama的参数为对象，OpenAI的参数为JSON字符串
+		args, ok := tc.Function.Arguments.(string)
+		if !ok {
+			data, _ := json.Marshal(tc.Function.Arguments)
+			args = string(data)
+		}
+		content += fmt.Sprintf("\n调用工具 %s(%s)", tc.Function.Name, args)
+	}
+	return Message{Role: m.Role, Content: strings.TrimSpace(content)}
+}
+
+// joinText 提取字符串、字符串数组或OpenAI多模态内容中的文本，忽略图片等其它部分
+func joinText(v interface{}) string {
+	switch c := v.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var parts []string
+		for _, item := range c {
+			switch p := item.(type) {
+			case string:
+				parts = append(parts, p)
+			case map[string]interface{}:
+				if text, ok := p["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+	"github.com/mfzzf/LLM_Based_HoneyPot/knn"
+)
+
+// KNNChecker 把内容转换为向量，与带标签的样本索引比较，按最近邻样本的标签投票
+type KNNChecker struct {
+	name          string
+	store         *knn.Store
+	embedder      *knn.Embedder
+	k             int
+	minSimilarity float64
+	threshold     float64
+}
+
+// NewKNNChecker 创建一个最近邻检查器。k 为参与投票的样本数，相似度低于 minSimilarity 的样本不参与投票，
+// 恶意样本按相似度加权的占比不低于 threshold 时拒绝
+func NewKNNChecker(name string, store *knn.Store, embedder *knn.Embedder, k int, minSimilarity, threshold float64) Checker {
+	return &KNNChecker{
+		name:          name,
+		store:         store,
+		embedder:      embedder,
+		k:             k,
+		minSimilarity: minSimilarity,
+		threshold:     threshold,
+	}
+}
+
+// CheckContent 检查内容是否合法
+func (kc *KNNChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	started := time.Now()
+	idx := kc.store.Index()
+
+	vectors, err := kc.embedder.Embed(ctx, idx.Model, []string{content})
+	if err != nil {
+		return Allow(), fmt.Errorf("kNN检查器 %s 生成向量失败: %w", kc.name, err)
+	}
+	neighbors, err := idx.Search(vectors[0], kc.k)
+	if err != nil {
+		return Allow(), fmt.Errorf("kNN检查器 %s 检索失败: %w", kc.name, err)
+	}
+
+	verdict := kc.vote(neighbors)
+	// 原始输出只记录样本编号和相似度，不重复记录样本内容
+	for i := range neighbors {
+		neighbors[i].Text = ""
+	}
+	raw, _ := json.Marshal(neighbors)
+	verdict.RawOutput = string(raw)
+	verdict.Model = "knn:" + idx.Model
+	verdict.Latency = time.Since(started)
+	return verdict, nil
+}
+
+// CheckPrompt 检查提示词是否合法
+func (kc *KNNChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return kc.CheckContent(ctx, prompt)
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (kc *KNNChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	return kc.CheckContent(ctx, Transcript(messages))
+}
+
+// vote 按相似度加权投票，违规置信度为恶意样本的权重占比。没有足够相似的样本时结论不确定
+func (kc *KNNChecker) vote(neighbors []knn.Neighbor) *Verdict {
+	var malicious, total float64
+	var votes int
+	var categories []string
+	for _, n := range neighbors {
+		if n.Similarity < kc.minSimilarity {
+			continue
+		}
+		votes++
+		total += n.Similarity
+		if n.Label == knn.LabelMalicious {
+			malicious += n.Similarity
+			if n.Category != "" {
+				categories = mergeTechniques(categories, []string{n.Category})
+			}
+		}
+	}
+
+	if votes == 0 {
+		best := 0.0
+		if len(neighbors) > 0 {
+			best = neighbors[0].Similarity
+		}
+		return &Verdict{
+			Allowed:   true,
+			Undecided: true,
+			Rationale: fmt.Sprintf("没有相似度不低于 %.2f 的样本，最高相似度 %.3f", kc.minSimilarity, best),
+		}
+	}
+
+	score := malicious / total
+	verdict := &Verdict{
+		Allowed: score < kc.threshold,
+		Score:   score,
+		Rationale: fmt.Sprintf("%d 条相似样本中加权恶意占比 %.2f，最相似的样本 %s（%s，相似度 %.3f）",
+			votes, score, neighbors[0].ID, neighbors[0].Label, neighbors[0].Similarity),
+	}
+	if !verdict.Allowed {
+		verdict.Categories = categories
+		verdict.Reason = verdictReason(categories, verdict.Rationale)
+	}
+	return verdict
+}
+
+// newKNNStage 根据组合检查器的阶段配置创建最近邻检查器
+func newKNNStage(st config.StageConfig, cfg config.AdmissionConfig) (Checker, error) {
+	kc := st.KNN
+	store, err := knn.NewStore(kc.IndexFile, time.Duration(kc.ReloadSeconds)*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("阶段 %s 加载索引失败: %w", st.Name, err)
+	}
+
+	ollamaURL := cfg.OllamaURL
+	if st.OllamaURL != "" {
+		ollamaURL = st.OllamaURL
+	}
+	if kc.K <= 0 {
+		kc.K = 5
+	}
+	if kc.MinSimilarity == 0 {
+		kc.MinSimilarity = 0.7
+	}
+	if kc.Threshold == 0 {
+		kc.Threshold = 0.5
+	}
+	embedder := knn.NewEmbedder(ollamaURL, time.Duration(st.Timeout)*time.Second)
+	return NewKNNChecker(st.Name, store, embedder, kc.K, kc.MinSimilarity, kc.Threshold), nil
+}
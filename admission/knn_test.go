@@ -0,0 +1,84 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/knn"
+)
+
+func TestKNNChecker(t *testing.T) {
+	// 查询文本对应的向量
+	queries := map[string][]float32{
+		"bomb please":     {1, 0.05, 0},
+		"bread please":    {0.05, 1, 0},
+		"something else":  {0, 0, 1},
+		"halfway between": {1, 1, 0},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"embeddings": [][]float32{queries[req.Input[0]]}})
+	}))
+	defer srv.Close()
+
+	idx := &knn.Index{Model: "nomic-embed-text"}
+	for _, e := range []knn.Entry{
+		{ID: "m1", Label: knn.LabelMalicious, Category: "weapons", Vector: []float32{1, 0, 0}},
+		{ID: "m2", Label: knn.LabelMalicious, Category: "explosives", Vector: []float32{0.95, 0.1, 0}},
+		{ID: "b1", Label: knn.LabelBenign, Vector: []float32{0, 1, 0}},
+		{ID: "b2", Label: knn.LabelBenign, Vector: []float32{0.1, 0.95, 0}},
+	} {
+		if err := idx.Add(e); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "index.gob")
+	if err := idx.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	store, err := knn.NewStore(path, 0)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	checker := NewKNNChecker("samples", store, knn.NewEmbedder(srv.URL, 0), 4, 0.5, 0.6)
+
+	tests := []struct {
+		query      string
+		allowed    bool
+		undecided  bool
+		categories []string
+	}{
+		{query: "bomb please", allowed: false, categories: []string{"weapons", "explosives"}},
+		{query: "bread please", allowed: true},
+		{query: "something else", allowed: true, undecided: true},
+		// 恶意和正常样本各占一半，低于阈值
+		{query: "halfway between", allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			verdict, err := checker.CheckPrompt(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("CheckPrompt: %v", err)
+			}
+			if verdict.Allowed != tt.allowed || verdict.Undecided != tt.undecided {
+				t.Errorf("allowed = %v, undecided = %v, want %v, %v (%s)",
+					verdict.Allowed, verdict.Undecided, tt.allowed, tt.undecided, verdict.Rationale)
+			}
+			if !reflect.DeepEqual(verdict.Categories, tt.categories) {
+				t.Errorf("categories = %v, want %v", verdict.Categories, tt.categories)
+			}
+			if verdict.Model != "knn:nomic-embed-text" {
+				t.Errorf("model = %q", verdict.Model)
+			}
+		})
+	}
+}
@@ -0,0 +1,538 @@
+package admission
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 识别出的混淆手段
+const (
+	ObfuscationInvisible = "invisible" // 零宽字符、双向控制符等不可见字符
+	ObfuscationHomoglyph = "homoglyph" // 西里尔、希腊字母等形近字符或全角字符
+	ObfuscationLeetspeak = "leetspeak" // 用数字和符号代替字母，如 b0mb
+	ObfuscationBase64    = "base64"
+	ObfuscationHex       = "hex"
+	ObfuscationURL       = "url"   // URL百分号编码
+	ObfuscationROT13     = "rot13" // ROT13 替换
+)
+
+// Normalized 为规范化后的文本及识别出的混淆手段
+type Normalized struct {
+	Text        string
+	Obfuscation []string
+}
+
+// Normalizer 在审核之前还原常见的混淆手段
+type Normalizer struct {
+	// minEncoded 为识别 base64、hex 编码片段的最小长度，过短的片段容易误判
+	minEncoded int
+	base64Re   *regexp.Regexp
+	hexRe      *regexp.Regexp
+	percentRe  *regexp.Regexp
+}
+
+// NewNormalizer 创建一个规范化器，minEncoded 为编码片段的最小长度
+func NewNormalizer(minEncoded int) *Normalizer {
+	if minEncoded < 8 {
+		minEncoded = 8
+	}
+	return &Normalizer{
+		minEncoded: minEncoded,
+		base64Re:   regexp.MustCompile(`[A-Za-z0-9+/_-]{8,}={0,2}`),
+		hexRe:      regexp.MustCompile(`(?i)(?:0x)?[0-9a-f]{8,}|(?:\\x[0-9a-f]{2}){4,}`),
+		percentRe:  regexp.MustCompile(`[^\s%]*(?:%[0-9A-Fa-f]{2}[^\s%]*){2,}`),
+	}
+}
+
+// invisibleRunes 为会被去除的不可见字符
+var invisibleRunes = map[rune]bool{
+	'\u00AD': true, // 软连字符
+	'\u034F': true,
+	'\u061C': true,
+	'\u115F': true,
+	'\u1160': true,
+	'\u17B4': true,
+	'\u17B5': true,
+	'\u180E': true,
+	'\u200B': true, // 零宽空格
+	'\u200C': true,
+	'\u200D': true,
+	'\u200E': true,
+	'\u200F': true,
+	'\u202A': true, // 双向控制符
+	'\u202B': true,
+	'\u202C': true,
+	'\u202D': true,
+	'\u202E': true,
+	'\u2060': true,
+	'\u2061': true,
+	'\u2062': true,
+	'\u2063': true,
+	'\u2064': true,
+	'\u2066': true,
+	'\u2067': true,
+	'\u2068': true,
+	'\u2069': true,
+	'\u3164': true,
+	'\uFEFF': true, // 字节顺序标记
+	'\uFFA0': true,
+}
+
+// homoglyphs 为常见的形近字符到拉丁字母的映射
+var homoglyphs = map[rune]rune{
+	// 西里尔字母
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ј': 'j',
+	'ԁ': 'd', 'ɡ': 'g', 'ո': 'n', 'ս': 'u', 'ѡ': 'w',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P',
+	'С': 'C', 'Т': 'T', 'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I', 'Ј': 'J',
+	// 希腊字母
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M',
+	'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	// 其它
+	'ı': 'i', 'ℓ': 'l', 'ⅰ': 'i', 'ⅴ': 'v', 'ⅹ': 'x',
+}
+
+// leetRunes 为 leetspeak 中常见的替换字符
+var leetRunes = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't',
+}
+
+// rot13Words 为判断 ROT13 的常见英文单词
+var rot13Words = map[string]bool{
+	"the": true, "and": true, "how": true, "to": true, "make": true, "you": true, "a": true,
+	"is": true, "of": true, "in": true, "for": true, "with": true, "what": true, "can": true,
+	"me": true, "i": true, "it": true, "this": true, "that": true, "build": true, "write": true,
+	"tell": true, "please": true, "ignore": true, "previous": true, "instructions": true,
+}
+
+// Normalize 依次去除不可见字符、还原形近字符和 leetspeak，并解码 base64、hex、URL 编码和 ROT13 片段。
+// 没有识别出混淆手段时返回原文
+func (n *Normalizer) Normalize(text string) Normalized {
+	var techniques []string
+	add := func(t string) {
+		for _, existing := range techniques {
+			if existing == t {
+				return
+			}
+		}
+		techniques = append(techniques, t)
+	}
+
+	s := text
+	if out, changed := stripInvisible(s); changed {
+		s = out
+		add(ObfuscationInvisible)
+	}
+	if out, changed := foldHomoglyphs(s); changed {
+		s = out
+		add(ObfuscationHomoglyph)
+	}
+
+	// 解码可能嵌套，例如 URL 编码的 base64，最多解码3层
+	for i := 0; i < 3; i++ {
+		decoded := false
+		if out, changed := n.decodePercent(s); changed {
+			s, decoded = out, true
+			add(ObfuscationURL)
+		}
+		if out, changed := n.decodeBase64(s); changed {
+			s, decoded = out, true
+			add(ObfuscationBase64)
+		}
+		if out, changed := n.decodeHex(s); changed {
+			s, decoded = out, true
+			add(ObfuscationHex)
+		}
+		if !decoded {
+			break
+		}
+	}
+
+	if out, changed := foldLeetspeak(s); changed {
+		s = out
+		add(ObfuscationLeetspeak)
+	}
+	if out, ok := decodeROT13(s); ok {
+		s = out
+		add(ObfuscationROT13)
+	}
+
+	if len(techniques) == 0 {
+		return Normalized{Text: text}
+	}
+	return Normalized{Text: s, Obfuscation: techniques}
+}
+
+// stripInvisible 去除不可见字符
+func stripInvisible(s string) (string, bool) {
+	changed := false
+	out := strings.Map(func(r rune) rune {
+		if invisibleRunes[r] || (r >= '\uFE00' && r <= '\uFE0F') || (r >= 0xe0000 && r <= 0xe007f) {
+			changed = true
+			return -1
+		}
+		return r
+	}, s)
+	return out, changed
+}
+
+// foldHomoglyphs 将形近字符和全角字符替换为对应的拉丁字母。只替换与拉丁字母混用的单词，
+// 以及英文文本中完全由形近字符组成的单词，避免改写正常的俄文、希腊文
+func foldHomoglyphs(s string) (string, bool) {
+	changed := false
+	words := strings.FieldsFunc(s, func(r rune) bool { return unicode.IsSpace(r) })
+	textLatin := strings.IndexFunc(s, isLatinLetter) >= 0
+	replaced := make(map[string]string)
+	for _, w := range words {
+		var latin, lookalike, fullwidth, foreign bool
+		for _, r := range w {
+			switch {
+			case isFullwidthAlnum(r):
+				fullwidth = true
+			case homoglyphs[r] != 0:
+				lookalike = true
+			case isLatinLetter(r):
+				latin = true
+			case unicode.In(r, unicode.Cyrillic, unicode.Greek):
+				foreign = true
+			}
+		}
+		if !fullwidth && !(lookalike && (latin || (textLatin && !foreign))) {
+			continue
+		}
+		replaced[w] = strings.Map(func(r rune) rune {
+			if isFullwidthAlnum(r) {
+				return r - 0xfee0
+			}
+			if h, ok := homoglyphs[r]; ok {
+				return h
+			}
+			return r
+		}, w)
+		changed = true
+	}
+	if !changed {
+		return s, false
+	}
+	out := s
+	for from, to := range replaced {
+		out = strings.ReplaceAll(out, from, to)
+	}
+	return out, true
+}
+
+// isFullwidthAlnum 判断是否为全角字母或数字。全角标点在中文里很常见，不做替换
+func isFullwidthAlnum(r rune) bool {
+	return (r >= '\uFF10' && r <= '\uFF19') || (r >= '\uFF21' && r <= '\uFF3A') || (r >= '\uFF41' && r <= '\uFF5A')
+}
+
+// foldLeetspeak 还原字母中间夹杂数字、符号的单词，如 b0mb、3xpl0s1v3。
+// 替换字符只出现在末尾的单词（如 gpt4、mp3）和句末标点不做替换
+func foldLeetspeak(s string) (string, bool) {
+	changed := false
+	var b strings.Builder
+	for _, w := range splitKeepSpace(s) {
+		core := strings.TrimRight(w, ".,!?;:")
+		if !isLeetWord(core) {
+			b.WriteString(w)
+			continue
+		}
+		changed = true
+		for _, r := range core {
+			if l, ok := leetRunes[r]; ok {
+				b.WriteRune(l)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+		b.WriteString(w[len(core):])
+	}
+	return b.String(), changed
+}
+
+// isLeetWord 判断单词是否只由字母和替换字符组成，且至少有一个替换字符前后都是字母
+func isLeetWord(w string) bool {
+	runes := []rune(w)
+	interior := false
+	for i, r := range runes {
+		if isLatinLetter(r) {
+			continue
+		}
+		if leetRunes[r] == 0 {
+			return false
+		}
+		if i > 0 && i < len(runes)-1 && hasLetter(runes[:i]) && hasLetter(runes[i+1:]) {
+			interior = true
+		}
+	}
+	return interior
+}
+
+func isLatinLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func hasLetter(runes []rune) bool {
+	for _, r := range runes {
+		if isLatinLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitKeepSpace 按空白切分文本，空白作为单独的片段保留，便于原样拼接
+func splitKeepSpace(s string) []string {
+	var parts []string
+	start := 0
+	space := false
+	for i, r := range s {
+		if unicode.IsSpace(r) != space && i > start {
+			parts = append(parts, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+// decodePercent 解码 URL 百分号编码的片段
+func (n *Normalizer) decodePercent(s string) (string, bool) {
+	changed := false
+	out := n.percentRe.ReplaceAllStringFunc(s, func(m string) string {
+		decoded, err := url.PathUnescape(m)
+		if err != nil || !readable(decoded) {
+			return m
+		}
+		changed = true
+		return decoded
+	})
+	return out, changed
+}
+
+// decodeBase64 解码 base64 片段，只替换解码结果为可读文本的片段
+func (n *Normalizer) decodeBase64(s string) (string, bool) {
+	changed := false
+	out := n.base64Re.ReplaceAllStringFunc(s, func(m string) string {
+		if len(m) < n.minEncoded || !mixedBase64(m) {
+			return m
+		}
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			data, err := enc.DecodeString(m)
+			if err == nil && readable(string(data)) {
+				changed = true
+				return string(data)
+			}
+		}
+		return m
+	})
+	return out, changed
+}
+
+// mixedBase64 判断片段是否同时包含大小写字母或数字，排除普通的长单词
+func mixedBase64(s string) bool {
+	var upper, lower, digit bool
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	return (upper && lower) || (digit && (upper || lower))
+}
+
+// decodeHex 解码十六进制片段
+func (n *Normalizer) decodeHex(s string) (string, bool) {
+	changed := false
+	out := n.hexRe.ReplaceAllStringFunc(s, func(m string) string {
+		h := strings.ReplaceAll(m, `\x`, "")
+		h = strings.TrimPrefix(strings.TrimPrefix(h, "0x"), "0X")
+		if len(h) < n.minEncoded || len(h)%2 != 0 {
+			return m
+		}
+		data, err := hex.DecodeString(h)
+		if err != nil || !readable(string(data)) {
+			return m
+		}
+		changed = true
+		return string(data)
+	})
+	return out, changed
+}
+
+// decodeROT13 在 ROT13 还原后的常见单词明显多于原文时认为使用了 ROT13
+func decodeROT13(s string) (string, bool) {
+	rotated := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+13)%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+13)%26
+		}
+		return r
+	}, s)
+
+	before, after := commonWords(s), commonWords(rotated)
+	if after >= 2 && after > 2*before {
+		return rotated, true
+	}
+	return s, false
+}
+
+// commonWords 统计常见英文单词的数量
+func commonWords(s string) int {
+	count := 0
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if rot13Words[w] {
+			count++
+		}
+	}
+	return count
+}
+
+// readable 判断解码结果是否为可读的UTF-8文本
+func readable(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+	var total, printable int
+	for _, r := range s {
+		total++
+		if unicode.IsPrint(r) || unicode.IsSpace(r) {
+			printable++
+		}
+	}
+	return float64(printable)/float64(total) >= 0.9
+}
+
+// NormalizingChecker 在识别出混淆时同时检查原文和规范化后的文本，任一拒绝即拒绝，
+// 识别出的混淆手段记录在结论中
+type NormalizingChecker struct {
+	inner      Checker
+	normalizer *Normalizer
+}
+
+// NewNormalizingChecker 创建一个先规范化再检查的检查器
+func NewNormalizingChecker(inner Checker, normalizer *Normalizer) Checker {
+	return &NormalizingChecker{
+		inner:      inner,
+		normalizer: normalizer,
+	}
+}
+
+// CheckContent 检查内容是否合法
+func (nc *NormalizingChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	norm := nc.normalizer.Normalize(content)
+	return nc.check(norm.Obfuscation, func() (*Verdict, error) {
+		return nc.inner.CheckContent(ctx, content)
+	}, func() (*Verdict, error) {
+		return nc.inner.CheckContent(ctx, norm.Text)
+	})
+}
+
+// CheckPrompt 检查提示词是否合法
+func (nc *NormalizingChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	norm := nc.normalizer.Normalize(prompt)
+	return nc.check(norm.Obfuscation, func() (*Verdict, error) {
+		return nc.inner.CheckPrompt(ctx, prompt)
+	}, func() (*Verdict, error) {
+		return nc.inner.CheckPrompt(ctx, norm.Text)
+	})
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (nc *NormalizingChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	var techniques []string
+	normalized := make([]Message, len(messages))
+	for i, msg := range messages {
+		norm := nc.normalizer.Normalize(msg.Content)
+		normalized[i] = Message{Role: msg.Role, Content: norm.Text}
+		techniques = mergeTechniques(techniques, norm.Obfuscation)
+	}
+	return nc.check(techniques, func() (*Verdict, error) {
+		return nc.inner.CheckChatMessages(ctx, messages)
+	}, func() (*Verdict, error) {
+		return nc.inner.CheckChatMessages(ctx, normalized)
+	})
+}
+
+// check 没有混淆时只检查原文，否则同时检查原文和规范化后的文本
+func (nc *NormalizingChecker) check(techniques []string, original, normalized func() (*Verdict, error)) (*Verdict, error) {
+	if len(techniques) == 0 {
+		return original()
+	}
+	log.Printf("[规范化] 识别出混淆手段: %v", techniques)
+
+	var wg sync.WaitGroup
+	var nv *Verdict
+	var nerr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		nv, nerr = normalized()
+	}()
+	verdict, err := original()
+	wg.Wait()
+
+	// 原文允许而规范化后的文本拒绝时采用后者。规范化文本的检查出错时，内层已按 failure_mode
+	// 给出结论（closed 时为拒绝），同样参与合并
+	if nv != nil && !nv.Allowed && (verdict == nil || verdict.Allowed) {
+		log.Printf("[规范化] 规范化后的文本被拒绝: 原因=%s", nv.Reason)
+		verdict = nv
+	}
+	if nerr != nil {
+		log.Printf("[规范化] 检查规范化后的文本失败: %v", nerr)
+		nerr = fmt.Errorf("检查规范化后的文本失败: %w", nerr)
+		if err == nil {
+			err = nerr
+		} else {
+			err = errors.Join(err, nerr)
+		}
+	}
+	if verdict == nil {
+		verdict = Allow()
+	}
+	if verdict.FailureMode == "" && nv != nil {
+		verdict.FailureMode = nv.FailureMode
+	}
+	verdict.Obfuscation = techniques
+	return verdict, err
+}
+
+// mergeTechniques 合并混淆手段并去重
+func mergeTechniques(dst, src []string) []string {
+	for _, t := range src {
+		found := false
+		for _, existing := range dst {
+			if existing == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, t)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,91 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExtractResponseText 从Ollama的非流式响应或单个流式片段中提取生成的文本以及是否结束
+func ExtractResponseText(path string, body []byte) (string, bool, error) {
+	var resp struct {
+		Response string `json:"response"`
+		Message  struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		Done bool `json:"done"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", false, err
+	}
+
+	if strings.HasSuffix(path, "/api/chat") {
+		return resp.Message.Content, resp.Done, nil
+	}
+	return resp.Response, resp.Done, nil
+}
+
+// RewriteResponseText 替换非流式响应中生成的文本，输出时保持Ollama的字段顺序
+func RewriteResponseText(path string, body []byte, text string) ([]byte, error) {
+	if strings.HasSuffix(path, "/api/chat") {
+		var resp ollamaChatChunk
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, err
+		}
+		resp.Message.Content = text
+		return json.Marshal(resp)
+	}
+
+	var resp ollamaGenerateChunk
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	resp.Response = text
+	return json.Marshal(resp)
+}
+
+// StreamChunk 构造流式输出的片段，metrics不为空时构造结束片段
+func StreamChunk(path, model, text string, metrics *Metrics) []byte {
+	var chunk interface{}
+	if strings.HasSuffix(path, "/api/chat") {
+		chunk = chatChunk(model, text, metrics)
+	} else {
+		chunk = generateChunk(model, text, metrics)
+	}
+	data, _ := json.Marshal(chunk)
+	return append(data, '\n')
+}
+
+// TruncateToAllowed 以step个字符为粒度二分查找最长的合规前缀，用于截断违规的输出
+func TruncateToAllowed(ctx context.Context, checker Checker, text string, step int) (string, error) {
+	runes := []rune(text)
+	if step <= 0 {
+		step = 200
+	}
+
+	// boundaries[i] 表示第i个候选前缀的长度，前缀越长越可能违规
+	var boundaries []int
+	for n := step; n < len(runes); n += step {
+		boundaries = append(boundaries, n)
+	}
+
+	lo, hi := 0, len(boundaries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		verdict, err := checker.CheckContent(ctx, string(runes[:boundaries[mid]]))
+		if err != nil {
+			return "", fmt.Errorf("截断检查失败: %w", err)
+		}
+		if verdict.Allowed {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	if lo == 0 {
+		return "", nil
+	}
+	return string(runes[:boundaries[lo-1]]), nil
+}
@@ -0,0 +1,143 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// Policy 是编译后的审核策略，决定发送给审核模型的提示词以及如何根据模型输出做出拦截决定
+type Policy struct {
+	cfg          config.PolicyConfig
+	systemPrompt string
+	enabled      map[string]bool
+	schema       map[string]interface{}
+	examples     []map[string]string
+}
+
+// NewPolicy 根据配置编译审核策略
+func NewPolicy(cfg config.PolicyConfig) (*Policy, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New("policy").Funcs(config.PromptFuncs).Parse(cfg.SystemPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("system_prompt 模板无效: %w", err)
+	}
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, struct{ Categories []string }{cfg.Categories}); err != nil {
+		return nil, fmt.Errorf("渲染 system_prompt 失败: %w", err)
+	}
+
+	p := &Policy{
+		cfg:          cfg,
+		systemPrompt: prompt.String(),
+		enabled:      make(map[string]bool),
+	}
+	for _, c := range cfg.Categories {
+		p.enabled[c] = true
+	}
+
+	// 模型输出的JSON Schema，类别限定为启用的类别
+	p.schema = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"allowed": map[string]interface{}{"type": "boolean"},
+			"categories": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string", "enum": cfg.Categories},
+			},
+			"score":     map[string]interface{}{"type": "number", "minimum": 0, "maximum": 1},
+			"rationale": map[string]interface{}{"type": "string"},
+		},
+		"required": []string{"allowed", "categories", "score", "rationale"},
+	}
+
+	// 少样本示例作为对话历史，示例的回答与模型应输出的格式一致
+	for _, ex := range cfg.Examples {
+		categories := ex.Categories
+		if categories == nil {
+			categories = []string{}
+		}
+		answer, _ := json.Marshal(struct {
+			Allowed    bool     `json:"allowed"`
+			Categories []string `json:"categories"`
+			Score      float64  `json:"score"`
+			Rationale  string   `json:"rationale"`
+		}{ex.Allowed, categories, ex.Score, ex.Rationale})
+		p.examples = append(p.examples,
+			map[string]string{"role": "user", "content": ex.Content},
+			map[string]string{"role": "assistant", "content": string(answer)},
+		)
+	}
+
+	return p, nil
+}
+
+// DefaultPolicy 返回默认配置编译出的审核策略
+func DefaultPolicy() *Policy {
+	p, err := NewPolicy(config.DefaultConfig().Admission.Policy)
+	if err != nil {
+		panic(fmt.Sprintf("默认审核策略无效: %v", err))
+	}
+	return p
+}
+
+// Categories 返回启用的违规类别
+func (p *Policy) Categories() []string {
+	return p.cfg.Categories
+}
+
+// messages 构造发送给审核模型的对话
+func (p *Policy) messages(content string) []map[string]string {
+	messages := []map[string]string{{"role": "system", "content": p.systemPrompt}}
+	messages = append(messages, p.examples...)
+	return append(messages, map[string]string{"role": "user", "content": content})
+}
+
+// threshold 返回类别的拦截阈值
+func (p *Policy) threshold(category string) float64 {
+	if t, ok := p.cfg.Thresholds[category]; ok {
+		return t
+	}
+	return p.cfg.DefaultThreshold
+}
+
+// decide 根据启用的类别和阈值重新决定模型给出的结论。
+// 只保留启用的类别；命中启用类别时，置信度达到任一类别的阈值才拦截；
+// 模型只给出了未启用的类别时放行；没有给出类别时按默认阈值判断
+func (p *Policy) decide(v *Verdict) {
+	flagged := len(v.Categories) > 0
+	var categories []string
+	for _, c := range v.Categories {
+		if p.enabled[c] {
+			categories = append(categories, c)
+		}
+	}
+	v.Categories = categories
+
+	switch {
+	case len(categories) > 0:
+		v.Allowed = true
+		for _, c := range categories {
+			if v.Score >= p.threshold(c) {
+				v.Allowed = false
+				break
+			}
+		}
+	case flagged:
+		v.Allowed = true
+	default:
+		v.Allowed = v.Allowed || v.Score < p.cfg.DefaultThreshold
+	}
+
+	if v.Allowed {
+		v.Reason = ""
+	} else if v.Reason == "" || len(categories) > 0 {
+		v.Reason = verdictReason(categories, v.Rationale)
+	}
+}
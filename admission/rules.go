@@ -0,0 +1,364 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 规则的动作
+const (
+	RuleAllow    = "allow"    // 直接放行，不再调用审核模型
+	RuleDeny     = "deny"     // 直接拒绝，不再调用审核模型
+	RuleEscalate = "escalate" // 交给后续的检查器（审核模型）判断
+)
+
+// Rule 表示规则文件中的一条规则。规则中配置的各项条件需要同时满足，keywords 中任一关键词出现即满足
+type Rule struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description,omitempty"`
+	Action      string   `json:"action"`
+	Category    string   `json:"category,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Regex       string   `json:"regex,omitempty"`
+	MinLength   int      `json:"min_length,omitempty"`
+	MaxLength   int      `json:"max_length,omitempty"`
+	// MinSymbolRatio 为非字母、数字、空白字符所占比例的下限，用于识别编码或混淆过的载荷
+	MinSymbolRatio float64 `json:"min_symbol_ratio,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// ruleFile 为规则文件的结构
+type ruleFile struct {
+	// DefaultAction 为没有规则命中时的动作，默认为 escalate
+	DefaultAction string `json:"default_action"`
+	Rules         []Rule `json:"rules"`
+}
+
+// RuleSet 是从规则文件加载的规则集合，文件修改后会自动重新加载
+type RuleSet struct {
+	path string
+
+	mu            sync.RWMutex
+	rules         []Rule
+	defaultAction string
+	modTime       time.Time
+}
+
+// NewRuleSet 加载规则文件，interval 大于0时按该间隔检查文件是否修改并重新加载
+func NewRuleSet(path string, interval time.Duration) (*RuleSet, error) {
+	rs := &RuleSet{path: path}
+	if err := rs.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go rs.watch(interval)
+	}
+	return rs, nil
+}
+
+// Reload 重新加载规则文件，加载失败时保留原有的规则
+func (rs *RuleSet) Reload() error {
+	info, err := os.Stat(rs.path)
+	if err != nil {
+		return fmt.Errorf("读取规则文件失败: %w", err)
+	}
+	data, err := os.ReadFile(rs.path)
+	if err != nil {
+		return fmt.Errorf("读取规则文件失败: %w", err)
+	}
+
+	var file ruleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("解析规则文件失败: %w", err)
+	}
+	if file.DefaultAction == "" {
+		file.DefaultAction = RuleEscalate
+	}
+	if !validRuleAction(file.DefaultAction) {
+		return fmt.Errorf("规则文件的 default_action 无效: %q", file.DefaultAction)
+	}
+
+	ids := make(map[string]bool)
+	for i := range file.Rules {
+		r := &file.Rules[i]
+		if r.ID == "" {
+			return fmt.Errorf("第 %d 条规则缺少 id", i+1)
+		}
+		if ids[r.ID] {
+			return fmt.Errorf("规则 id %q 重复", r.ID)
+		}
+		ids[r.ID] = true
+		if !validRuleAction(r.Action) {
+			return fmt.Errorf("规则 %s 的 action 无效: %q", r.ID, r.Action)
+		}
+		if len(r.Keywords) == 0 && r.Regex == "" && r.MinLength == 0 && r.MaxLength == 0 && r.MinSymbolRatio == 0 {
+			return fmt.Errorf("规则 %s 没有任何条件", r.ID)
+		}
+		if r.Regex != "" {
+			if r.re, err = regexp.Compile(r.Regex); err != nil {
+				return fmt.Errorf("规则 %s 的正则表达式无效: %w", r.ID, err)
+			}
+		}
+		for j, k := range r.Keywords {
+			r.Keywords[j] = strings.ToLower(k)
+		}
+	}
+
+	rs.mu.Lock()
+	rs.rules = file.Rules
+	rs.defaultAction = file.DefaultAction
+	rs.modTime = info.ModTime()
+	rs.mu.Unlock()
+
+	log.Printf("[规则] 已加载规则文件 %s: 规则数=%d, 默认动作=%s", rs.path, len(file.Rules), file.DefaultAction)
+	return nil
+}
+
+// watch 定期检查规则文件的修改时间
+func (rs *RuleSet) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(rs.path)
+		if err != nil {
+			continue
+		}
+		rs.mu.RLock()
+		changed := !info.ModTime().Equal(rs.modTime)
+		rs.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := rs.Reload(); err != nil {
+			log.Printf("[规则] 重新加载规则文件失败，继续使用原有规则: %v", err)
+			// 记录修改时间，避免同一个错误的文件反复加载
+			rs.mu.Lock()
+			rs.modTime = info.ModTime()
+			rs.mu.Unlock()
+		}
+	}
+}
+
+// Match 返回第一条命中的规则及其动作，没有规则命中时返回默认动作
+func (rs *RuleSet) Match(content string) (*Rule, string) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	lower := strings.ToLower(content)
+	for i := range rs.rules {
+		if rs.rules[i].matches(content, lower) {
+			return &rs.rules[i], rs.rules[i].Action
+		}
+	}
+	return nil, rs.defaultAction
+}
+
+// matches 判断内容是否满足规则的全部条件
+func (r *Rule) matches(content, lower string) bool {
+	if len(r.Keywords) > 0 {
+		found := false
+		for _, k := range r.Keywords {
+			if strings.Contains(lower, k) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.re != nil && !r.re.MatchString(content) {
+		return false
+	}
+
+	length := utf8.RuneCountInString(content)
+	if r.MinLength > 0 && length < r.MinLength {
+		return false
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		return false
+	}
+	if r.MinSymbolRatio > 0 && symbolRatio(content) < r.MinSymbolRatio {
+		return false
+	}
+	return true
+}
+
+// symbolRatio 计算非字母、数字、空白字符所占的比例
+func symbolRatio(content string) float64 {
+	var total, symbols int
+	for _, c := range content {
+		total++
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !unicode.IsSpace(c) {
+			symbols++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(symbols) / float64(total)
+}
+
+func validRuleAction(action string) bool {
+	return action == RuleAllow || action == RuleDeny || action == RuleEscalate
+}
+
+// InsertRule 在规则文件的最前面插入一条规则，优先于原有的规则匹配，文件中原有的内容保持不变。
+// 写入临时文件后再替换，开启自动重新加载时新规则随后生效
+func InsertRule(path string, rule Rule) error {
+	if !validRuleAction(rule.Action) {
+		return fmt.Errorf("规则 %s 的 action 无效: %q", rule.ID, rule.Action)
+	}
+	if len(rule.Keywords) == 0 && rule.Regex == "" {
+		return fmt.Errorf("规则 %s 没有任何条件", rule.ID)
+	}
+	if rule.Regex != "" {
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("规则 %s 的正则表达式无效: %w", rule.ID, err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取规则文件失败: %w", err)
+	}
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("解析规则文件失败: %w", err)
+	}
+	var rules []json.RawMessage
+	if raw, ok := file["rules"]; ok {
+		if err := json.Unmarshal(raw, &rules); err != nil {
+			return fmt.Errorf("解析规则文件失败: %w", err)
+		}
+	}
+	for _, raw := range rules {
+		var existing struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(raw, &existing) == nil && existing.ID == rule.ID {
+			return fmt.Errorf("规则 id %q 重复", rule.ID)
+		}
+	}
+
+	raw, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	rules = append([]json.RawMessage{raw}, rules...)
+	if file["rules"], err = json.Marshal(rules); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0644); err != nil {
+		return fmt.Errorf("写入规则文件失败: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("写入规则文件失败: %w", err)
+	}
+	return nil
+}
+
+// MatchText 返回规则匹配的文本：对话为各条消息的内容按行拼接，不包含角色
+func MatchText(in Input) string {
+	if in.Kind != InputChat {
+		return in.Text
+	}
+	var contents []string
+	for _, msg := range in.Messages {
+		contents = append(contents, msg.Content)
+	}
+	return strings.Join(contents, "\n")
+}
+
+// RuleChecker 在审核模型之前使用规则进行快速检查，只有无法确定的内容才交给 next 检查
+type RuleChecker struct {
+	rules *RuleSet
+	next  Checker
+}
+
+// NewRuleChecker 创建一个规则检查器，next 为空时需要升级判断的内容直接放行
+func NewRuleChecker(rules *RuleSet, next Checker) Checker {
+	return &RuleChecker{
+		rules: rules,
+		next:  next,
+	}
+}
+
+// CheckContent 检查内容是否合法
+func (rc *RuleChecker) CheckContent(ctx context.Context, content string) (*Verdict, error) {
+	return rc.check(content, func() (*Verdict, error) {
+		return rc.next.CheckContent(ctx, content)
+	})
+}
+
+// CheckPrompt 检查提示词是否合法
+func (rc *RuleChecker) CheckPrompt(ctx context.Context, prompt string) (*Verdict, error) {
+	return rc.check(prompt, func() (*Verdict, error) {
+		return rc.next.CheckPrompt(ctx, prompt)
+	})
+}
+
+// CheckChatMessages 检查聊天消息是否合法
+func (rc *RuleChecker) CheckChatMessages(ctx context.Context, messages []Message) (*Verdict, error) {
+	return rc.check(MatchText(Input{Kind: InputChat, Messages: messages}), func() (*Verdict, error) {
+		return rc.next.CheckChatMessages(ctx, messages)
+	})
+}
+
+// check 按规则检查内容，需要升级时调用 escalate
+func (rc *RuleChecker) check(content string, escalate func() (*Verdict, error)) (*Verdict, error) {
+	started := time.Now()
+	rule, action := rc.rules.Match(content)
+
+	var ruleID string
+	if rule != nil {
+		ruleID = rule.ID
+		log.Printf("[规则] 命中规则 %s: 动作=%s", rule.ID, action)
+	}
+
+	switch action {
+	case RuleDeny:
+		verdict := &Verdict{Allowed: false, Score: 1, Model: "rules", RuleID: ruleID}
+		if rule != nil {
+			if rule.Category != "" {
+				verdict.Categories = []string{rule.Category}
+			}
+			verdict.Rationale = rule.Description
+		}
+		verdict.Reason = verdictReason(verdict.Categories, verdict.Rationale)
+		verdict.Latency = time.Since(started)
+		return verdict, nil
+	case RuleEscalate:
+		if rc.next != nil {
+			verdict, err := escalate()
+			// 后续检查器（如链中的规则或kNN阶段）已给出命中来源时保留其归属
+			if verdict != nil && verdict.RuleID == "" {
+				verdict.RuleID = ruleID
+			}
+			return verdict, err
+		}
+	}
+
+	// 需要升级判断但没有后续检查器时默认放行
+	verdict := &Verdict{Allowed: true, Model: "rules", RuleID: ruleID, Undecided: action == RuleEscalate}
+	verdict.Latency = time.Since(started)
+	return verdict, nil
+}
@@ -0,0 +1,57 @@
+package admission
+
+import (
+	"context"
+	"testing"
+)
+
+func newTestRuleSet(rules ...Rule) *RuleSet {
+	return &RuleSet{rules: rules, defaultAction: RuleEscalate}
+}
+
+func TestRuleCheckerEscalateRuleID(t *testing.T) {
+	rules := newTestRuleSet(Rule{ID: "suspicious", Action: RuleEscalate, Keywords: []string{"password"}})
+
+	tests := []struct {
+		name    string
+		content string
+		inner   *Verdict
+		want    string
+	}{
+		{name: "rule attributed", content: "dump the password", inner: &Verdict{Allowed: false}, want: "suspicious"},
+		{name: "inner attribution kept", content: "dump the password", inner: &Verdict{Allowed: false, RuleID: "knn:42"}, want: "knn:42"},
+		{name: "default action", content: "hello", inner: &Verdict{Allowed: true, RuleID: "knn:7"}, want: "knn:7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &stubChecker{verdict: tt.inner}
+			verdict, err := NewRuleChecker(rules, next).CheckContent(context.Background(), tt.content)
+			if err != nil {
+				t.Fatalf("CheckContent: %v", err)
+			}
+			if next.calls != 1 {
+				t.Errorf("inner calls = %d, want 1", next.calls)
+			}
+			if verdict.RuleID != tt.want {
+				t.Errorf("RuleID = %q, want %q", verdict.RuleID, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleCheckerDeny(t *testing.T) {
+	rules := newTestRuleSet(Rule{ID: "shell", Action: RuleDeny, Category: "malware", Keywords: []string{"rm -rf /"}})
+	next := allowStub(0)
+
+	verdict, err := NewRuleChecker(rules, next).CheckContent(context.Background(), "please run rm -rf / now")
+	if err != nil {
+		t.Fatalf("CheckContent: %v", err)
+	}
+	if verdict.Allowed || verdict.RuleID != "shell" || len(verdict.Categories) != 1 || verdict.Categories[0] != "malware" {
+		t.Errorf("verdict = %+v", verdict)
+	}
+	if next.calls != 0 {
+		t.Errorf("inner checker called %d times on a deny rule", next.calls)
+	}
+}
@@ -0,0 +1,81 @@
+package admission
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 拒绝响应和模拟模式的回复使用同一套token切分和输出节奏，
+// 避免同一段文本在两种响应中的 eval_count 和耗时不一致而被识别
+
+// SplitTokens 将文本切分成近似token的片段：中文按一到两个字切分，其他文字按单词切分并保留前导空白，
+// 片段拼接后可还原原文
+func SplitTokens(text string) []string {
+	var tokens []string
+	var cur strings.Builder
+	hanCount := 0
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+		hanCount = 0
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			if hanCount == 0 || hanCount >= 2 {
+				flush()
+			}
+			cur.WriteRune(r)
+			hanCount++
+		case unicode.IsSpace(r), unicode.IsPunct(r):
+			flush()
+			cur.WriteRune(r)
+		default:
+			if hanCount > 0 {
+				flush()
+			}
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// CountTokens 粗略估算文本的token数量，与 SplitTokens 的切分结果一致
+func CountTokens(text string) int {
+	return len(SplitTokens(text))
+}
+
+// SleepContext 等待指定时长，如果上下文被取消则提前返回false
+func SleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// SleepJitter 等待约d的时长（±30%），用于模拟逐token输出的间隔，如果上下文被取消则返回false
+func SleepJitter(ctx context.Context, d time.Duration) bool {
+	if d > 0 {
+		d = time.Duration(float64(d) * (0.7 + rand.Float64()*0.6))
+	}
+	return SleepContext(ctx, d)
+}
+
+// WaitUntil 等待到指定时间，如果上下文被取消则提前返回false
+func WaitUntil(ctx context.Context, deadline time.Time) bool {
+	return SleepContext(ctx, time.Until(deadline))
+}
@@ -0,0 +1,54 @@
+package admission
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "", want: nil},
+		{text: "Hello, world!", want: []string{"Hello", ",", " world", "!"}},
+		{text: "  two  spaces", want: []string{" ", " two", " ", " spaces"}},
+		{text: "你好世界啊", want: []string{"你好", "世界", "啊"}},
+		{text: "用Go写", want: []string{"用", "Go", "写"}},
+		{text: "line one\nline two\n", want: []string{"line", " one", "\nline", " two", "\n"}},
+	}
+
+	for _, tt := range tests {
+		got := SplitTokens(tt.text)
+		if strings.Join(got, "") != tt.text {
+			t.Errorf("SplitTokens(%q) joined = %q", tt.text, strings.Join(got, ""))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("SplitTokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+		if n := CountTokens(tt.text); n != len(tt.want) {
+			t.Errorf("CountTokens(%q) = %d, want %d", tt.text, n, len(tt.want))
+		}
+	}
+}
+
+func TestSleepContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if SleepContext(ctx, time.Minute) {
+		t.Error("SleepContext returned true on a canceled context")
+	}
+	if WaitUntil(ctx, time.Now().Add(-time.Second)) {
+		t.Error("WaitUntil returned true on a canceled context")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("SleepContext did not return promptly")
+	}
+	if !SleepJitter(context.Background(), time.Millisecond) {
+		t.Error("SleepJitter returned false on a live context")
+	}
+}
@@ -2,16 +2,32 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"net"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
 )
 
 // Config 表示应用程序配置
 type Config struct {
-	ListenAddr string          `json:"listen_addr"`
-	TargetAddr string          `json:"target_addr"`
-	LogEnabled bool            `json:"log_enabled"`
-	ELK        ELKConfig       `json:"elk"`
-	Admission  AdmissionConfig `json:"admission"`
+	ListenAddr string    `json:"listen_addr"`
+	TargetAddr string    `json:"target_addr"`
+	LogEnabled bool      `json:"log_enabled"`
+	ELK        ELKConfig `json:"elk"`
+	// Sinks 为ELK之外的日志写入目标，每个目标有独立的过滤条件和队列
+	Sinks []SinkConfig `json:"sinks"`
+	// LogSchema 为日志文档的字段格式，对ELK和其它写入目标都生效：ecs（默认）按 Elastic Common Schema 输出，
+	// legacy 为原有字段
+	LogSchema  string           `json:"log_schema"`
+	Admission  AdmissionConfig  `json:"admission"`
+	Standalone StandaloneConfig `json:"standalone"`
+	Detection  DetectionConfig  `json:"detection"`
+	DLP        DLPConfig        `json:"dlp"`
+	Review     ReviewConfig     `json:"review"`
 }
 
 // ELKConfig 表示ELK日志配置
@@ -22,15 +38,413 @@ type ELKConfig struct {
 	Password string `json:"password"`
 	APIKey   string `json:"api_key"`
 	Index    string `json:"index"`
+	// Layout 为文档布局：interaction（默认）把一次交互的请求、响应、准入结论和分析结果合并到以请求ID
+	// 为ID的同一个文档中；split 为原有布局，响应单独写入，准入控制写入 <index>-admission
+	Layout string `json:"layout"`
+	// Bulk 为批量写入配置，日志先进入内存队列，由后台通过 _bulk 接口批量写入
+	Bulk BulkConfig `json:"bulk"`
+	// Spool 为本地缓冲配置，Elasticsearch不可用时日志写入本地文件，恢复后按顺序重放
+	Spool SpoolConfig `json:"spool"`
+	// DataStream 为数据流配置，启动时安装ILM策略和索引模板，index 作为数据流名称
+	DataStream DataStreamConfig `json:"data_stream"`
+}
+
+// DataStreamConfig 表示Elasticsearch数据流配置
+type DataStreamConfig struct {
+	// Enabled 默认关闭。数据流不能与已有的普通索引同名，开启时 index 应使用新的名称，如 logs-ollama_proxy-default
+	Enabled bool `json:"enabled"`
+	// RolloverMaxAge 和 RolloverMaxPrimarySize 为滚动条件，默认 1d 和 50gb
+	RolloverMaxAge         string `json:"rollover_max_age"`
+	RolloverMaxPrimarySize string `json:"rollover_max_primary_size"`
+	// DeleteAfter 为滚动后保留的时间，默认 90d，为空时不删除
+	DeleteAfter string `json:"delete_after"`
+	Shards      int    `json:"shards"`
+	Replicas    int    `json:"replicas"`
+	// MergeWaitSeconds 为 interaction 布局下记录响应或拒绝请求之后，等待输出审核、攻击手法识别等事件的时间，默认 5 秒
+	MergeWaitSeconds int `json:"merge_wait_seconds"`
+	// MaxMergeSeconds 为交互文档在内存中的最长时间，超时后即使没有响应也写入，默认 600 秒
+	MaxMergeSeconds int `json:"max_merge_seconds"`
+}
+
+// SinkConfig 表示一个日志写入目标
+type SinkConfig struct {
+	// Name 用于终端日志，默认与 Type 相同，不能重复
+	Name string `json:"name"`
+	// Type 可选 file、stdout、syslog、webhook
+	Type string `json:"type"`
+	// Events 为写入的事件类型：request、response、admission、output、annotation，为空表示全部
+	Events []string `json:"events"`
+	// DeniedOnly 表示只写入拒绝的准入控制和违规的输出审核事件
+	DeniedOnly bool `json:"denied_only"`
+	// QueueSize 为内存队列能容纳的事件数，队列已满时丢弃新事件，默认 1000
+	QueueSize int `json:"queue_size"`
+
+	File    FileSinkConfig    `json:"file"`
+	Syslog  SyslogSinkConfig  `json:"syslog"`
+	Webhook WebhookSinkConfig `json:"webhook"`
+}
+
+// FileSinkConfig 表示JSONL文件写入目标配置
+type FileSinkConfig struct {
+	Path string `json:"path"`
+	// MaxBytes 为单个文件的大小上限，超过后轮转，默认 100MB
+	MaxBytes int64 `json:"max_bytes"`
+	// MaxFiles 为保留的轮转文件数，默认 5
+	MaxFiles int `json:"max_files"`
+}
+
+// SyslogSinkConfig 表示syslog写入目标配置，消息格式为 RFC 5424
+type SyslogSinkConfig struct {
+	// Network 可选 udp、tcp、tls
+	Network string `json:"network"`
+	Addr    string `json:"addr"`
+	// Facility 为空时默认 16（local0），0（kern）同样可以配置
+	Facility *int `json:"facility"`
+	// AppName 默认 ollama-proxy
+	AppName        string `json:"app_name"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+	// MaxMessageBytes 为 UDP 数据报的最大字节数，超过时截断，默认 2048（RFC 5426 要求接收方至少支持的大小）；
+	// TCP 和 TLS 按长度前缀分帧，不截断
+	MaxMessageBytes int `json:"max_message_bytes"`
+	// CAFile 为校验服务端证书的CA证书，为空时使用系统证书
+	CAFile             string `json:"ca_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// WebhookSinkConfig 表示HTTP webhook写入目标配置，每个事件POST一次
+type WebhookSinkConfig struct {
+	URL            string            `json:"url"`
+	Headers        map[string]string `json:"headers"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+	// MaxRetries 为连接失败、429、5xx 时的重试次数，默认 2
+	MaxRetries int `json:"max_retries"`
+}
+
+// SpoolConfig 表示本地缓冲配置
+type SpoolConfig struct {
+	Enabled bool `json:"enabled"`
+	// Dir 为缓冲目录，默认 spool
+	Dir string `json:"dir"`
+	// SegmentBytes 为单个缓冲文件的大小，超过后写入新文件，默认 16MB
+	SegmentBytes int `json:"segment_bytes"`
+	// MaxBytes 为缓冲目录的容量上限，达到后丢弃新的日志，默认 1GB
+	MaxBytes int64 `json:"max_bytes"`
+	// RetrySeconds 为尝试重放的间隔，默认 5 秒
+	RetrySeconds int `json:"retry_seconds"`
+}
+
+// BulkConfig 表示Elasticsearch批量写入配置
+type BulkConfig struct {
+	// QueueSize 为内存队列能容纳的文档数，默认 10000
+	QueueSize int `json:"queue_size"`
+	// BatchSize 为每批的最大文档数，默认 500
+	BatchSize int `json:"batch_size"`
+	// FlushBytes 为每批的最大字节数，默认 5MB
+	FlushBytes int `json:"flush_bytes"`
+	// FlushIntervalMs 为队列中有文档时的最长等待时间，默认 1000 毫秒
+	FlushIntervalMs int `json:"flush_interval_ms"`
+	// Overflow 为队列已满时的处理方式：drop 直接丢弃新文档（默认），block 等待最多 BlockTimeoutMs 后丢弃
+	Overflow       string `json:"overflow"`
+	BlockTimeoutMs int    `json:"block_timeout_ms"`
+	// MaxRetries 为整批写入失败或文档被限流（429）时的重试次数，默认 3
+	MaxRetries int `json:"max_retries"`
+	// CloseTimeoutSeconds 为关闭时写入剩余文档的最长时间，默认 10 秒
+	CloseTimeoutSeconds int `json:"close_timeout_seconds"`
 }
 
 // AdmissionConfig 表示准入控制配置
 type AdmissionConfig struct {
-	Enabled    bool   `json:"enabled"`
-	ModelName  string `json:"model_name"`
-	OllamaURL  string `json:"ollama_url"`
-	Timeout    int    `json:"timeout_seconds"`
-	MaxRetries int    `json:"max_retries"`
+	Enabled    bool         `json:"enabled"`
+	ModelName  string       `json:"model_name"`
+	OllamaURL  string       `json:"ollama_url"`
+	Timeout    int          `json:"timeout_seconds"`
+	MaxRetries int          `json:"max_retries"`
+	Output     OutputConfig `json:"output"`
+	Policy     PolicyConfig `json:"policy"`
+	// CheckTimeout 为一次准入检查（包括组合阶段、分窗口和规范化后的再次检查）的总时限，
+	// 0 表示按审核模型的超时、重试次数、组合方式和分窗口配置自动计算
+	CheckTimeout int `json:"check_timeout_seconds"`
+	// FailureMode 为审核模型出错时的处理方式：open 放行，closed 拒绝，rules 降级为规则检查
+	FailureMode string `json:"failure_mode"`
+	// RulesFile 为规则文件路径，配置后先按规则检查，只有无法确定的内容才交给审核模型
+	RulesFile string `json:"rules_file"`
+	// RulesReloadSeconds 为检查规则文件是否修改的间隔，0 表示不自动重新加载
+	RulesReloadSeconds int `json:"rules_reload_seconds"`
+	// Chain 为组合检查器配置，配置了检查阶段时代替单个审核模型
+	Chain ChainConfig `json:"chain"`
+	// Cache 为审核结论缓存配置
+	Cache CacheConfig `json:"cache"`
+	// Chunking 为超长内容分窗口检查的配置
+	Chunking ChunkingConfig `json:"chunking"`
+	// Normalize 为混淆还原配置
+	Normalize NormalizeConfig `json:"normalize"`
+	// Speculative 为推测执行模式，上游请求与准入检查同时开始，上游响应在审核结论到达前暂不下发，
+	// 允许时照常转发，拒绝时丢弃。可以省去大部分审核耗时，但被拒绝的请求同样会消耗上游的算力
+	Speculative bool `json:"speculative"`
+	// Shadow 为影子模式，只计算并记录审核结论，不拦截请求也不改写输出，用于在线上流量中试用新的审核模型
+	Shadow bool `json:"shadow"`
+	// DenialMessage 为拒绝时回复给客户端的文本模板（text/template），可使用 {{.Reason}}、{{.Model}}，为空时使用内置文本
+	DenialMessage string `json:"denial_message"`
+	// Routes 为按请求选择的准入策略，第一条匹配的路由生效，没有路由匹配时使用以上配置（default 策略）
+	Routes []RouteConfig `json:"routes"`
+}
+
+// RouteConfig 表示一条准入策略路由。匹配条件各项同时满足，每项的列表中任一值匹配即满足，为空表示不限；
+// 覆盖项为空时继承准入控制的顶层配置
+type RouteConfig struct {
+	Name string `json:"name"`
+	// Paths 为接口路径，支持 * 通配符，如 /api/*
+	Paths []string `json:"paths"`
+	// Models 为请求体中的模型名，支持 * 通配符，如 *coder*
+	Models []string `json:"models"`
+	// APIKeys 为客户端的 API Key（Authorization: Bearer 或 X-Api-Key 请求头），支持 * 通配符
+	APIKeys []string `json:"api_keys"`
+	// UserAgents 为客户端的 User-Agent，支持 * 通配符
+	UserAgents []string `json:"user_agents"`
+	// Networks 为来源网络（CIDR），如 10.0.0.0/8
+	Networks []string `json:"networks"`
+
+	// Skip 表示不做准入检查和输出审核，用于 /api/show、/api/embed 等不需要审核模型判断的接口
+	Skip bool `json:"skip"`
+	// ModelName 为审核模型
+	ModelName string `json:"model_name"`
+	// FailureMode 为审核模型出错时的处理方式
+	FailureMode string `json:"failure_mode"`
+	// Shadow 为路由的影子模式，为空时沿用顶层配置
+	Shadow *bool `json:"shadow"`
+	// Chain 为组合检查器配置
+	Chain *ChainConfig `json:"chain"`
+	// Policy 为审核策略配置，按字段覆盖，没有配置的字段沿用顶层的审核策略
+	Policy *PolicyConfig `json:"policy"`
+	// DenialMessage 为拒绝文本模板
+	DenialMessage string `json:"denial_message"`
+}
+
+// ForRoute 返回路由覆盖后的准入控制配置
+func (a AdmissionConfig) ForRoute(r RouteConfig) AdmissionConfig {
+	if r.ModelName != "" {
+		a.ModelName = r.ModelName
+	}
+	if r.FailureMode != "" {
+		a.FailureMode = r.FailureMode
+	}
+	if r.Shadow != nil {
+		a.Shadow = *r.Shadow
+	}
+	if r.Chain != nil {
+		a.Chain = *r.Chain
+	}
+	if r.Policy != nil {
+		// 审核策略按字段覆盖，例如只覆盖启用的类别而沿用原有的系统提示词
+		if r.Policy.SystemPrompt != "" {
+			a.Policy.SystemPrompt = r.Policy.SystemPrompt
+		}
+		if r.Policy.Categories != nil {
+			a.Policy.Categories = r.Policy.Categories
+		}
+		if r.Policy.Thresholds != nil {
+			a.Policy.Thresholds = r.Policy.Thresholds
+		}
+		if r.Policy.DefaultThreshold != 0 {
+			a.Policy.DefaultThreshold = r.Policy.DefaultThreshold
+		}
+		if r.Policy.Examples != nil {
+			a.Policy.Examples = r.Policy.Examples
+		}
+	}
+	if r.DenialMessage != "" {
+		a.DenialMessage = r.DenialMessage
+	}
+	a.Routes = nil
+	return a
+}
+
+// ChunkingConfig 表示超长内容分窗口检查的配置。审核模型的上下文有限，
+// 超过窗口大小的内容切分为相互重叠的窗口分别检查，任一窗口拒绝即拒绝
+type ChunkingConfig struct {
+	// WindowChars 为每个窗口的字符数，0 表示不分窗口
+	WindowChars int `json:"window_chars"`
+	// OverlapChars 为相邻窗口重叠的字符数
+	OverlapChars int `json:"overlap_chars"`
+	// MaxWorkers 为同时检查的窗口数
+	MaxWorkers int `json:"max_workers"`
+}
+
+// NormalizeConfig 表示混淆还原配置。启用后去除不可见字符、还原形近字符和 leetspeak，
+// 解码 base64、hex、URL 编码和 ROT13，识别出混淆时原文和还原后的文本都会被检查
+type NormalizeConfig struct {
+	Enabled bool `json:"enabled"`
+	// MinEncodedLength 为识别 base64、hex 编码片段的最小长度
+	MinEncodedLength int `json:"min_encoded_length"`
+}
+
+// CacheConfig 表示审核结论缓存配置，相同内容在有效期内不再重复调用审核模型
+type CacheConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxEntries int  `json:"max_entries"`
+	TTLSeconds int  `json:"ttl_seconds"`
+	// PersistFile 为缓存的持久化文件，为空时只保存在内存中
+	PersistFile            string `json:"persist_file"`
+	PersistIntervalSeconds int    `json:"persist_interval_seconds"`
+}
+
+// ChainConfig 表示组合检查器配置
+type ChainConfig struct {
+	// Strategy 为组合方式：first_deny 依次检查，任一拒绝即拒绝；all_allow 同时检查，每个阶段都明确允许才允许；
+	// majority 同时检查，按权重投票；cascade 依次检查，结论不确定时才交给下一阶段
+	Strategy string        `json:"strategy"`
+	Stages   []StageConfig `json:"stages"`
+	// CascadeLow、CascadeHigh 为 cascade 的确定区间：违规置信度不高于 CascadeLow 确定放行，
+	// 不低于 CascadeHigh 确定拒绝，介于两者之间交给下一阶段
+	CascadeLow  float64 `json:"cascade_low"`
+	CascadeHigh float64 `json:"cascade_high"`
+}
+
+// StageConfig 表示组合检查器中的一个检查阶段
+type StageConfig struct {
+	Name string `json:"name"`
+	// Type 为检查器类型：ollama 审核模型，http 外部分类服务，rules 规则文件，keywords 内置关键词，knn 向量最近邻
+	Type string `json:"type"`
+	// ModelName、OllamaURL 用于 ollama 类型，为空时使用准入控制的配置；knn 类型只使用 OllamaURL，
+	// embedding 模型记录在索引文件中
+	ModelName string `json:"model_name"`
+	OllamaURL string `json:"ollama_url"`
+	// URL 用于 http 类型，接收 {"content": "..."}，返回 {"allowed", "categories", "score", "rationale"}
+	URL     string `json:"url"`
+	Timeout int    `json:"timeout_seconds"`
+	// Weight 为 majority 投票时的权重，默认 1
+	Weight float64 `json:"weight"`
+	// KNN 用于 knn 类型
+	KNN KNNConfig `json:"knn"`
+}
+
+// KNNConfig 表示向量最近邻检查器的配置，索引文件由 knn-build 子命令生成
+type KNNConfig struct {
+	IndexFile string `json:"index_file"`
+	// K 为参与投票的最近邻样本数，默认 5
+	K int `json:"k"`
+	// MinSimilarity 为参与投票的最低余弦相似度，默认 0.7，没有样本达到时结论不确定
+	MinSimilarity float64 `json:"min_similarity"`
+	// Threshold 为拒绝时恶意样本按相似度加权的最低占比，默认 0.5
+	Threshold float64 `json:"threshold"`
+	// ReloadSeconds 为检查索引文件是否修改的间隔，0 表示不自动重新加载
+	ReloadSeconds int `json:"reload_seconds"`
+}
+
+// PolicyConfig 表示审核策略配置，用于在不重新编译的情况下按部署调整审核标准
+type PolicyConfig struct {
+	// SystemPrompt 为审核模型的系统提示词模板（text/template），可使用 {{join .Categories ", "}} 引用启用的类别
+	SystemPrompt string `json:"system_prompt"`
+	// SystemPromptFile 为系统提示词模板文件，设置后覆盖 SystemPrompt，相对路径相对于配置文件所在目录
+	SystemPromptFile string `json:"system_prompt_file"`
+	// Categories 为启用的违规类别，模型给出的其它类别会被忽略
+	Categories []string `json:"categories"`
+	// Thresholds 为各类别的拦截阈值，违规置信度达到阈值时拦截，未配置的类别使用 DefaultThreshold
+	Thresholds       map[string]float64 `json:"thresholds"`
+	DefaultThreshold float64            `json:"default_threshold"`
+	// Examples 为少样本示例，按顺序作为对话历史发送给审核模型
+	Examples []PolicyExample `json:"examples"`
+}
+
+// PolicyExample 表示一个少样本审核示例
+type PolicyExample struct {
+	Content    string   `json:"content"`
+	Allowed    bool     `json:"allowed"`
+	Categories []string `json:"categories"`
+	Score      float64  `json:"score"`
+	Rationale  string   `json:"rationale"`
+}
+
+// OutputConfig 表示输出侧准入控制配置，对上游生成的内容进行审核
+type OutputConfig struct {
+	Enabled bool `json:"enabled"`
+	// Action 为非流式响应违规时的处理方式：replace 替换为拒绝回复，truncate 截断到违规内容之前
+	Action string `json:"action"`
+	// StreamPolicy 为流式响应违规时的处理方式：cutoff 中断输出并发送结束片段，log 只记录不中断
+	StreamPolicy string `json:"stream_policy"`
+	// StreamCheckChars 表示流式输出每累积多少字符检查一次，同时也是截断的粒度
+	StreamCheckChars int `json:"stream_check_chars"`
+	// ReplacementText 为替换或中断时输出给客户端的文本，为空时使用默认的拒绝回复
+	ReplacementText string `json:"replacement_text"`
+}
+
+// DetectionConfig 表示攻击手法识别配置。识别结果只用于情报收集，写入请求日志，
+// 不影响准入结论，准入控制禁用时同样生效
+type DetectionConfig struct {
+	Enabled bool `json:"enabled"`
+	// Classifier 表示除特征库外是否同时使用模型分类
+	Classifier bool `json:"classifier"`
+	// ModelName 和 OllamaURL 为空时使用准入控制的配置
+	ModelName string `json:"model_name"`
+	OllamaURL string `json:"ollama_url"`
+	Timeout   int    `json:"timeout_seconds"`
+}
+
+// DLPConfig 表示敏感数据识别配置，识别请求和响应中的密钥、凭证和个人信息
+type DLPConfig struct {
+	Enabled bool `json:"enabled"`
+	// Types 为识别的敏感数据类型，为空时识别全部类型
+	Types []string `json:"types"`
+	// Redact 表示写入日志前把敏感数据替换为 [REDACTED:类型]
+	Redact bool `json:"redact"`
+	// DenyTypes 为作为准入信号的类型，请求或输出中出现这些类型的敏感数据时拒绝
+	DenyTypes []string `json:"deny_types"`
+}
+
+// ReviewConfig 表示人工复核队列配置。结论不确定、置信度处于中间区间或被抽样的准入决定进入队列，
+// 复核人员通过管理接口标注，标注结果可以回流到规则文件和 kNN 样本文件
+type ReviewConfig struct {
+	Enabled bool `json:"enabled"`
+	// AdminAddr 为管理接口的监听地址，与代理的监听地址分开，默认只监听本机
+	AdminAddr string `json:"admin_addr"`
+	// AdminToken 为管理接口的访问令牌，请求需要带上 Authorization: Bearer <token>
+	AdminToken string `json:"admin_token"`
+	// StoreFile 为队列的持久化文件，为空时只保存在内存中
+	StoreFile string `json:"store_file"`
+	// MaxItems 为队列的最大条目数，超过后优先淘汰最早的已复核条目，默认 10000
+	MaxItems int `json:"max_items"`
+	// UncertainLow、UncertainHigh 为需要复核的违规置信度区间，默认 0.3 到 0.7
+	UncertainLow  float64 `json:"uncertain_low"`
+	UncertainHigh float64 `json:"uncertain_high"`
+	// SampleRate 为其余决定的抽样比例，默认 0.01
+	SampleRate float64 `json:"sample_rate"`
+	// KNNSamplesFile 为复核结果追加写入的 kNN 样本文件（JSONL），为空时不写入
+	KNNSamplesFile string `json:"knn_samples_file"`
+	// AuditFile 为复核操作的审计日志文件（JSONL），为空时只输出到终端
+	AuditFile string `json:"audit_file"`
+}
+
+// StandaloneConfig 表示无后端蜜罐模式配置，启用后由代理自身模拟Ollama的响应
+type StandaloneConfig struct {
+	Enabled               bool           `json:"enabled"`
+	Version               string         `json:"version"`
+	Models                []FakeModel    `json:"models"`
+	Responses             []FakeResponse `json:"responses"`
+	DefaultResponse       string         `json:"default_response"`
+	TokensPerSecond       float64        `json:"tokens_per_second"`
+	PromptTokensPerSecond float64        `json:"prompt_tokens_per_second"`
+	LoadDurationMs        int            `json:"load_duration_ms"`
+	KeepAliveSeconds      int            `json:"keep_alive_seconds"`
+}
+
+// FakeModel 表示模拟模式下对外展示的模型
+type FakeModel struct {
+	Name              string `json:"name"`
+	Family            string `json:"family"`
+	ParameterSize     string `json:"parameter_size"`
+	QuantizationLevel string `json:"quantization_level"`
+	Size              int64  `json:"size"`
+	Digest            string `json:"digest"`
+	ModifiedAt        string `json:"modified_at"`
+	ContextLength     int    `json:"context_length"`
+}
+
+// FakeResponse 表示模拟模式下的预设回复，Match为匹配提示词的正则表达式，
+// Response为text/template模板，可使用 {{.Prompt}}、{{.System}}、{{.Model}}、{{.Time}}
+type FakeResponse struct {
+	Match    string `json:"match"`
+	Model    string `json:"model"`
+	Response string `json:"response"`
 }
 
 // DefaultConfig 返回默认配置
@@ -39,24 +453,177 @@ func DefaultConfig() Config {
 		ListenAddr: ":8080",
 		TargetAddr: "http://10.255.248.65:11434",
 		LogEnabled: true,
+		LogSchema:  "ecs",
 		ELK: ELKConfig{
 			Enabled:  true,
 			URL:      "http://10.255.248.65:9200",
 			Username: "elastic",
 			Password: "H3JIfzF2Ic*dbRj4c5Kd",
 			//APIKey:   "",
-			Index: "ollama-proxy",
+			Index:  "ollama-proxy",
+			Layout: "interaction",
+			DataStream: DataStreamConfig{
+				Enabled:                false,
+				RolloverMaxAge:         "1d",
+				RolloverMaxPrimarySize: "50gb",
+				DeleteAfter:            "90d",
+				Shards:                 1,
+				Replicas:               1,
+				MergeWaitSeconds:       5,
+				MaxMergeSeconds:        600,
+			},
+			Bulk: BulkConfig{
+				QueueSize:           10000,
+				BatchSize:           500,
+				FlushBytes:          5 << 20,
+				FlushIntervalMs:     1000,
+				Overflow:            "drop",
+				BlockTimeoutMs:      100,
+				MaxRetries:          3,
+				CloseTimeoutSeconds: 10,
+			},
+			Spool: SpoolConfig{
+				Enabled:      true,
+				Dir:          "spool",
+				SegmentBytes: 16 << 20,
+				MaxBytes:     1 << 30,
+				RetrySeconds: 5,
+			},
 		},
 		Admission: AdmissionConfig{
-			Enabled:    true,
-			ModelName:  "phi3:3.8b", // 使用较小的模型进行验证
-			OllamaURL:  "http://10.255.248.65:11434",
-			Timeout:    5, // 5秒超时
-			MaxRetries: 2,
+			Enabled:            true,
+			ModelName:          "phi3:3.8b", // 使用较小的模型进行验证
+			OllamaURL:          "http://10.255.248.65:11434",
+			Timeout:            5, // 5秒超时
+			MaxRetries:         2,
+			FailureMode:        "open",
+			RulesReloadSeconds: 5,
+			Chunking: ChunkingConfig{
+				WindowChars:  4000,
+				OverlapChars: 400,
+				MaxWorkers:   4,
+			},
+			Normalize: NormalizeConfig{
+				Enabled:          true,
+				MinEncodedLength: 16,
+			},
+			Cache: CacheConfig{
+				Enabled:                false,
+				MaxEntries:             10000,
+				TTLSeconds:             3600,
+				PersistIntervalSeconds: 60,
+			},
+			Chain: ChainConfig{
+				Strategy:    "first_deny",
+				CascadeLow:  0.2,
+				CascadeHigh: 0.8,
+			},
+			Output: OutputConfig{
+				Enabled:          false,
+				Action:           "replace",
+				StreamPolicy:     "cutoff",
+				StreamCheckChars: 200,
+			},
+			Policy: PolicyConfig{
+				SystemPrompt: DefaultSystemPrompt,
+				Categories: []string{
+					"weapons",          // 武器、爆炸物
+					"malware",          // 恶意软件、漏洞利用
+					"self-harm",        // 自残、自杀
+					"prompt-injection", // 提示词注入、越狱
+					"violence",         // 暴力、恐怖主义
+					"hate",             // 仇恨、歧视
+					"sexual",           // 色情内容
+					"drugs",            // 毒品
+					"fraud",            // 诈骗、钓鱼
+					"privacy",          // 隐私数据窃取
+				},
+				DefaultThreshold: 0.5,
+			},
+		},
+		Detection: DetectionConfig{
+			Enabled: true,
+			Timeout: 30,
+		},
+		DLP: DLPConfig{
+			Enabled: true,
+		},
+		Review: ReviewConfig{
+			Enabled:       false,
+			AdminAddr:     "127.0.0.1:11435",
+			MaxItems:      10000,
+			UncertainLow:  0.3,
+			UncertainHigh: 0.7,
+			SampleRate:    0.01,
+		},
+		Standalone: StandaloneConfig{
+			Enabled: false,
+			Version: "0.6.2",
+			Models: []FakeModel{
+				{
+					Name:              "llama3.1:8b",
+					Family:            "llama",
+					ParameterSize:     "8.0B",
+					QuantizationLevel: "Q4_K_M",
+					Size:              4920753328,
+					Digest:            "46e0c10c039e019119339687c3c1757cc81b9da49709a3b3924863ba87ca666e",
+					ModifiedAt:        "2025-02-11T09:42:17.526514883+08:00",
+					ContextLength:     131072,
+				},
+				{
+					Name:              "qwen2.5:7b",
+					Family:            "qwen2",
+					ParameterSize:     "7.6B",
+					QuantizationLevel: "Q4_K_M",
+					Size:              4683087332,
+					Digest:            "845dbda0ea48ed749caafd9e6037047aa19acfcfd82e704d7ca97d631a0b697e",
+					ModifiedAt:        "2025-01-20T16:05:33.190231607+08:00",
+					ContextLength:     32768,
+				},
+				{
+					Name:              "deepseek-r1:7b",
+					Family:            "qwen2",
+					ParameterSize:     "7.6B",
+					QuantizationLevel: "Q4_K_M",
+					Size:              4683075271,
+					Digest:            "0a8c266910232fd3291e71e5ba1e058cc5af9d411192cf88b6d30e92b6e73163",
+					ModifiedAt:        "2025-01-28T21:13:52.064371538+08:00",
+					ContextLength:     131072,
+				},
+				{
+					Name:              "phi3:3.8b",
+					Family:            "phi3",
+					ParameterSize:     "3.8B",
+					QuantizationLevel: "Q4_0",
+					Size:              2176178913,
+					Digest:            "4f222292793889a9a40a020799cfd28d53f3e01af25d48e06c5e708610fc47e9",
+					ModifiedAt:        "2024-12-30T10:21:45.872315472+08:00",
+					ContextLength:     131072,
+				},
+			},
+			DefaultResponse:       "I'm sorry, but I don't have enough information to answer that. Could you provide more details about what you need?",
+			TokensPerSecond:       38,
+			PromptTokensPerSecond: 600,
+			LoadDurationMs:        20,
+			KeepAliveSeconds:      300,
 		},
 	}
 }
 
+// DefaultSystemPrompt 为默认的审核系统提示词模板
+const DefaultSystemPrompt = `你是一个安全审核员。你的任务是检查content是否包含不允许的内容。
+只输出一个JSON对象，字段如下：
+- allowed: 布尔值，内容合规时为true
+- categories: 命中的违规类别数组，可选值为 {{join .Categories ", "}}，合规时为空数组
+- score: 0到1之间的数字，表示内容违规的置信度
+- rationale: 一句话说明判断依据`
+
+// PromptFuncs 为系统提示词模板可用的函数
+var PromptFuncs = template.FuncMap{"join": strings.Join}
+
+// categoryPattern 限定类别名称的格式，类别名称会出现在日志和JSON Schema中
+var categoryPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 // LoadConfig 从文件加载配置
 func LoadConfig(filename string) (Config, error) {
 	config := DefaultConfig()
@@ -68,8 +635,392 @@ func LoadConfig(filename string) (Config, error) {
 	defer file.Close()
 
 	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
-	return config, err
+	if err := decoder.Decode(&config); err != nil {
+		return config, err
+	}
+
+	// 加载系统提示词文件
+	if err := config.Admission.Policy.loadSystemPrompt(filepath.Dir(filename)); err != nil {
+		return config, err
+	}
+	for _, r := range config.Admission.Routes {
+		if r.Policy == nil {
+			continue
+		}
+		if err := r.Policy.loadSystemPrompt(filepath.Dir(filename)); err != nil {
+			return config, fmt.Errorf("路由 %s: %w", r.Name, err)
+		}
+	}
+
+	if err := config.ELK.Validate(); err != nil {
+		return config, err
+	}
+	if err := validateSinks(config.Sinks); err != nil {
+		return config, err
+	}
+	if err := validateLogSchema(config.LogSchema, config.ELK); err != nil {
+		return config, err
+	}
+	if err := config.Admission.Validate(); err != nil {
+		return config, err
+	}
+	if err := config.Review.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// Validate 检查ELK日志配置是否有效
+func (e ELKConfig) Validate() error {
+	if !e.Enabled {
+		return nil
+	}
+	if e.Layout != "interaction" && e.Layout != "split" {
+		return fmt.Errorf("无效的 elk.layout: %q，可选值为 interaction、split", e.Layout)
+	}
+	if err := e.Bulk.Validate(); err != nil {
+		return err
+	}
+	if e.Spool.Enabled {
+		if e.Spool.Dir == "" {
+			return fmt.Errorf("启用本地缓冲时需要配置 elk.spool.dir")
+		}
+		if e.Spool.SegmentBytes <= 0 || e.Spool.MaxBytes < int64(e.Spool.SegmentBytes) || e.Spool.RetrySeconds <= 0 {
+			return fmt.Errorf("elk.spool 的 segment_bytes、retry_seconds 必须大于 0，max_bytes 不能小于 segment_bytes")
+		}
+	}
+	if e.DataStream.Enabled {
+		if err := e.DataStream.Validate(e.Index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	ilmAgePattern  = regexp.MustCompile(`^[0-9]+(d|h|m|s)$`)
+	ilmSizePattern = regexp.MustCompile(`^[0-9]+(b|kb|mb|gb|tb)$`)
+	// dataStreamNamePattern 为数据流名称的限制：小写，不能以 -、_、+、. 开头，不能包含特殊字符
+	dataStreamNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+)
+
+// Validate 检查数据流配置是否有效，index 为数据流名称
+func (d DataStreamConfig) Validate(index string) error {
+	if !dataStreamNamePattern.MatchString(index) {
+		return fmt.Errorf("elk.index %q 不能作为数据流名称，只能包含小写字母、数字和 .、_、-", index)
+	}
+	if d.RolloverMaxAge == "" && d.RolloverMaxPrimarySize == "" {
+		return fmt.Errorf("elk.data_stream 至少需要 rollover_max_age、rollover_max_primary_size 中的一个")
+	}
+	for name, v := range map[string]string{"rollover_max_age": d.RolloverMaxAge, "delete_after": d.DeleteAfter} {
+		if v != "" && !ilmAgePattern.MatchString(v) {
+			return fmt.Errorf("无效的 elk.data_stream.%s: %q，格式如 1d、12h", name, v)
+		}
+	}
+	if d.RolloverMaxPrimarySize != "" && !ilmSizePattern.MatchString(d.RolloverMaxPrimarySize) {
+		return fmt.Errorf("无效的 elk.data_stream.rollover_max_primary_size: %q，格式如 50gb", d.RolloverMaxPrimarySize)
+	}
+	if d.Shards <= 0 || d.Replicas < 0 {
+		return fmt.Errorf("elk.data_stream 的 shards 必须大于 0，replicas 不能为负数")
+	}
+	if d.MergeWaitSeconds <= 0 || d.MaxMergeSeconds < d.MergeWaitSeconds {
+		return fmt.Errorf("elk.data_stream 的 merge_wait_seconds 必须大于 0，max_merge_seconds 不能小于 merge_wait_seconds")
+	}
+	return nil
+}
+
+// validateLogSchema 检查日志格式，数据流的索引模板按 ECS 定义字段映射，只能与 ecs 格式一起使用
+func validateLogSchema(schema string, elk ELKConfig) error {
+	if schema != "ecs" && schema != "legacy" {
+		return fmt.Errorf("无效的 log_schema: %q，可选值为 ecs、legacy", schema)
+	}
+	if elk.Enabled && elk.DataStream.Enabled && schema != "ecs" {
+		return fmt.Errorf("elk.data_stream 需要 log_schema 为 ecs，使用 legacy 格式时请关闭 elk.data_stream")
+	}
+	return nil
+}
+
+// validateSinks 检查日志写入目标配置是否有效
+func validateSinks(sinks []SinkConfig) error {
+	events := map[string]bool{"request": true, "response": true, "admission": true, "output": true, "annotation": true}
+	names := make(map[string]bool)
+	for i, s := range sinks {
+		name := s.Name
+		if name == "" {
+			name = s.Type
+		}
+		if names[name] {
+			return fmt.Errorf("日志写入目标名称 %q 重复", name)
+		}
+		names[name] = true
+		for _, e := range s.Events {
+			if !events[e] {
+				return fmt.Errorf("sinks[%d] 的事件类型 %q 无效，可选值为 request、response、admission、output、annotation", i, e)
+			}
+		}
+
+		switch s.Type {
+		case "file":
+			if s.File.Path == "" {
+				return fmt.Errorf("sinks[%d] 缺少 file.path", i)
+			}
+		case "stdout":
+		case "syslog":
+			if s.Syslog.Network != "udp" && s.Syslog.Network != "tcp" && s.Syslog.Network != "tls" {
+				return fmt.Errorf("sinks[%d] 的 syslog.network 无效: %q，可选值为 udp、tcp、tls", i, s.Syslog.Network)
+			}
+			if _, _, err := net.SplitHostPort(s.Syslog.Addr); err != nil {
+				return fmt.Errorf("sinks[%d] 的 syslog.addr 无效: %w", i, err)
+			}
+			if f := s.Syslog.Facility; f != nil && (*f < 0 || *f > 23) {
+				return fmt.Errorf("sinks[%d] 的 syslog.facility 必须在 0 到 23 之间", i)
+			}
+			// UDP 数据报的负载最多 65507 字节
+			if s.Syslog.MaxMessageBytes < 0 || s.Syslog.MaxMessageBytes > 65507 {
+				return fmt.Errorf("sinks[%d] 的 syslog.max_message_bytes 必须在 0 到 65507 之间", i)
+			}
+		case "webhook":
+			if !strings.HasPrefix(s.Webhook.URL, "http://") && !strings.HasPrefix(s.Webhook.URL, "https://") {
+				return fmt.Errorf("sinks[%d] 的 webhook.url 必须以 http:// 或 https:// 开头", i)
+			}
+		default:
+			return fmt.Errorf("sinks[%d] 的类型无效: %q，可选值为 file、stdout、syslog、webhook", i, s.Type)
+		}
+	}
+	return nil
+}
+
+// Validate 检查批量写入配置是否有效
+func (b BulkConfig) Validate() error {
+	if b.QueueSize <= 0 || b.BatchSize <= 0 || b.FlushBytes <= 0 || b.FlushIntervalMs <= 0 {
+		return fmt.Errorf("elk.bulk 的 queue_size、batch_size、flush_bytes、flush_interval_ms 必须大于 0")
+	}
+	if b.Overflow != "drop" && b.Overflow != "block" {
+		return fmt.Errorf("无效的 elk.bulk.overflow: %q，可选值为 drop、block", b.Overflow)
+	}
+	if b.BlockTimeoutMs < 0 || b.MaxRetries < 0 || b.CloseTimeoutSeconds < 0 {
+		return fmt.Errorf("elk.bulk 的 block_timeout_ms、max_retries、close_timeout_seconds 不能为负数")
+	}
+	return nil
+}
+
+// Validate 检查复核队列配置是否有效
+func (r ReviewConfig) Validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	if r.AdminAddr == "" {
+		return fmt.Errorf("复核队列需要配置 admin_addr")
+	}
+	host, _, err := net.SplitHostPort(r.AdminAddr)
+	if err != nil {
+		return fmt.Errorf("无效的 admin_addr: %w", err)
+	}
+	if r.AdminToken == "" && host != "127.0.0.1" && host != "localhost" && host != "::1" {
+		return fmt.Errorf("管理接口监听于非本机地址 %s 时必须配置 admin_token", r.AdminAddr)
+	}
+	if r.UncertainLow < 0 || r.UncertainHigh > 1 || r.UncertainLow > r.UncertainHigh {
+		return fmt.Errorf("uncertain_low、uncertain_high 必须满足 0 <= uncertain_low <= uncertain_high <= 1")
+	}
+	if r.SampleRate < 0 || r.SampleRate > 1 {
+		return fmt.Errorf("sample_rate 必须在 0 到 1 之间")
+	}
+	return nil
+}
+
+// Validate 检查准入控制配置是否有效
+func (a AdmissionConfig) Validate() error {
+	switch a.FailureMode {
+	case "open", "closed", "rules":
+	default:
+		return fmt.Errorf("无效的 failure_mode: %q，可选值为 open、closed、rules", a.FailureMode)
+	}
+	if err := a.Policy.Validate(); err != nil {
+		return fmt.Errorf("审核策略配置无效: %w", err)
+	}
+	if a.CheckTimeout < 0 {
+		return fmt.Errorf("check_timeout_seconds 不能为负数")
+	}
+	if a.Cache.Enabled && (a.Cache.MaxEntries <= 0 || a.Cache.TTLSeconds <= 0) {
+		return fmt.Errorf("审核结论缓存的 max_entries 和 ttl_seconds 必须大于0")
+	}
+	if a.Chunking.WindowChars < 0 || a.Chunking.OverlapChars < 0 || a.Chunking.MaxWorkers < 0 {
+		return fmt.Errorf("分窗口检查的配置不能为负数")
+	}
+	if a.Chunking.WindowChars > 0 && a.Chunking.OverlapChars >= a.Chunking.WindowChars {
+		return fmt.Errorf("分窗口检查的 overlap_chars 必须小于 window_chars")
+	}
+	if a.Normalize.Enabled && a.Normalize.MinEncodedLength < 8 {
+		return fmt.Errorf("混淆还原的 min_encoded_length 不能小于8")
+	}
+	if err := a.Chain.validate(a.RulesFile); err != nil {
+		return fmt.Errorf("组合检查器配置无效: %w", err)
+	}
+	if _, err := template.New("denial").Parse(a.DenialMessage); err != nil {
+		return fmt.Errorf("denial_message 模板无效: %w", err)
+	}
+
+	names := map[string]bool{"default": true}
+	for i, r := range a.Routes {
+		if r.Name == "" {
+			return fmt.Errorf("routes[%d] 缺少 name", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("路由名称 %q 重复或与默认策略 default 同名", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("路由 %s 配置无效: %w", r.Name, err)
+		}
+		if r.Skip {
+			continue
+		}
+		if err := a.ForRoute(r).Validate(); err != nil {
+			return fmt.Errorf("路由 %s 配置无效: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate 检查路由的匹配条件是否有效
+func (r RouteConfig) validate() error {
+	for _, patterns := range [][]string{r.Paths, r.Models, r.APIKeys, r.UserAgents} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("通配符 %q 无效: %w", p, err)
+			}
+		}
+	}
+	for _, n := range r.Networks {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return fmt.Errorf("networks 中的 %q 不是有效的 CIDR", n)
+		}
+	}
+	return nil
+}
+
+// validate 检查组合检查器配置是否有效
+func (c ChainConfig) validate(rulesFile string) error {
+	if len(c.Stages) == 0 {
+		return nil
+	}
+	switch c.Strategy {
+	case "first_deny", "all_allow", "majority", "cascade":
+	default:
+		return fmt.Errorf("无效的 strategy: %q，可选值为 first_deny、all_allow、majority、cascade", c.Strategy)
+	}
+	if c.Strategy == "cascade" && (c.CascadeLow < 0 || c.CascadeHigh > 1 || c.CascadeLow >= c.CascadeHigh) {
+		return fmt.Errorf("cascade_low 和 cascade_high 需满足 0 <= cascade_low < cascade_high <= 1")
+	}
+
+	names := make(map[string]bool)
+	for i, st := range c.Stages {
+		if st.Name == "" {
+			return fmt.Errorf("stages[%d] 缺少 name", i)
+		}
+		if names[st.Name] {
+			return fmt.Errorf("阶段名称 %q 重复", st.Name)
+		}
+		names[st.Name] = true
+		switch st.Type {
+		case "ollama", "keywords":
+		case "http":
+			if st.URL == "" {
+				return fmt.Errorf("阶段 %s 为 http 类型，需要配置 url", st.Name)
+			}
+		case "rules":
+			if rulesFile == "" {
+				return fmt.Errorf("阶段 %s 为 rules 类型，需要配置 rules_file", st.Name)
+			}
+		case "knn":
+			if st.KNN.IndexFile == "" {
+				return fmt.Errorf("阶段 %s 为 knn 类型，需要配置 knn.index_file", st.Name)
+			}
+			if st.KNN.K < 0 || st.KNN.ReloadSeconds < 0 {
+				return fmt.Errorf("阶段 %s 的 knn.k 和 knn.reload_seconds 不能为负数", st.Name)
+			}
+			if st.KNN.MinSimilarity < -1 || st.KNN.MinSimilarity > 1 || st.KNN.Threshold < 0 || st.KNN.Threshold > 1 {
+				return fmt.Errorf("阶段 %s 的 knn.min_similarity 必须在 -1 到 1 之间，knn.threshold 必须在 0 到 1 之间", st.Name)
+			}
+		default:
+			return fmt.Errorf("阶段 %s 的类型无效: %q，可选值为 ollama、http、rules、keywords、knn", st.Name, st.Type)
+		}
+		if st.Weight < 0 {
+			return fmt.Errorf("阶段 %s 的权重不能为负数", st.Name)
+		}
+	}
+	return nil
+}
+
+// loadSystemPrompt 加载系统提示词文件，相对路径相对于 dir
+func (p *PolicyConfig) loadSystemPrompt(dir string) error {
+	if p.SystemPromptFile == "" {
+		return nil
+	}
+	file := p.SystemPromptFile
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("读取审核策略的系统提示词文件失败: %w", err)
+	}
+	p.SystemPrompt = string(data)
+	return nil
+}
+
+// Validate 检查审核策略配置是否有效
+func (p PolicyConfig) Validate() error {
+	if strings.TrimSpace(p.SystemPrompt) == "" {
+		return fmt.Errorf("system_prompt 不能为空")
+	}
+	if _, err := template.New("policy").Funcs(PromptFuncs).Parse(p.SystemPrompt); err != nil {
+		return fmt.Errorf("system_prompt 模板无效: %w", err)
+	}
+
+	if len(p.Categories) == 0 {
+		return fmt.Errorf("categories 至少需要一个类别")
+	}
+	enabled := make(map[string]bool)
+	for _, c := range p.Categories {
+		if !categoryPattern.MatchString(c) {
+			return fmt.Errorf("类别 %q 格式无效，只能包含小写字母、数字、- 和 _", c)
+		}
+		if enabled[c] {
+			return fmt.Errorf("类别 %q 重复", c)
+		}
+		enabled[c] = true
+	}
+
+	if p.DefaultThreshold <= 0 || p.DefaultThreshold > 1 {
+		return fmt.Errorf("default_threshold 必须在 (0, 1] 范围内，当前为 %v", p.DefaultThreshold)
+	}
+	for c, t := range p.Thresholds {
+		if !enabled[c] {
+			return fmt.Errorf("thresholds 中的类别 %q 未在 categories 中启用", c)
+		}
+		if t <= 0 || t > 1 {
+			return fmt.Errorf("类别 %q 的阈值必须在 (0, 1] 范围内，当前为 %v", c, t)
+		}
+	}
+
+	for i, ex := range p.Examples {
+		if strings.TrimSpace(ex.Content) == "" {
+			return fmt.Errorf("examples[%d] 的 content 不能为空", i)
+		}
+		if ex.Score < 0 || ex.Score > 1 {
+			return fmt.Errorf("examples[%d] 的 score 必须在 [0, 1] 范围内", i)
+		}
+		if ex.Allowed && len(ex.Categories) > 0 {
+			return fmt.Errorf("examples[%d] 标记为允许但包含违规类别", i)
+		}
+		for _, c := range ex.Categories {
+			if !enabled[c] {
+				return fmt.Errorf("examples[%d] 中的类别 %q 未在 categories 中启用", i, c)
+			}
+		}
+	}
+	return nil
 }
 
 // SaveConfig 保存配置到文件
@@ -0,0 +1,79 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+)
+
+// 样本标签，与 knn-build 的样本文件相同，同一份数据集可以同时用于构建索引和评估
+const (
+	LabelMalicious = "malicious"
+	LabelBenign    = "benign"
+)
+
+// Case 为评估数据集中的一条样本。text 按提示词审核，messages 按多轮对话审核
+type Case struct {
+	ID       string              `json:"id"`
+	Text     string              `json:"text,omitempty"`
+	Messages []admission.Message `json:"messages,omitempty"`
+	Label    string              `json:"label"`
+	// Category 为恶意样本的违规类别，用于计算各类别的精确率和召回率
+	Category string `json:"category,omitempty"`
+}
+
+// Input 返回样本对应的待审核内容
+func (c Case) Input() admission.Input {
+	if len(c.Messages) > 0 {
+		return admission.Input{Kind: admission.InputChat, Messages: c.Messages}
+	}
+	return admission.Input{Kind: admission.InputPrompt, Text: c.Text}
+}
+
+// Malicious 判断样本是否为恶意样本
+func (c Case) Malicious() bool {
+	return c.Label == LabelMalicious
+}
+
+// ReadDataset 读取 JSONL 格式的数据集，忽略空行
+func ReadDataset(r io.Reader) ([]Case, error) {
+	var cases []Case
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	ids := make(map[string]bool)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var c Case
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("第 %d 行解析失败: %w", line, err)
+		}
+		if c.Input().Empty() {
+			return nil, fmt.Errorf("第 %d 行缺少 text 或 messages", line)
+		}
+		if c.Label != LabelMalicious && c.Label != LabelBenign {
+			return nil, fmt.Errorf("第 %d 行的 label 无效: %q，可选值为 %s、%s", line, c.Label, LabelMalicious, LabelBenign)
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("line-%d", line)
+		}
+		if ids[c.ID] {
+			return nil, fmt.Errorf("第 %d 行的 id %q 重复", line, c.ID)
+		}
+		ids[c.ID] = true
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取数据集失败: %w", err)
+	}
+	return cases, nil
+}
@@ -0,0 +1,313 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Confusion 为混淆矩阵，恶意样本为正类：拒绝恶意样本为 TP，拒绝正常样本为 FP
+type Confusion struct {
+	TP int `json:"true_positive"`
+	FP int `json:"false_positive"`
+	TN int `json:"true_negative"`
+	FN int `json:"false_negative"`
+}
+
+// CategoryReport 为一个违规类别的指标。精确率的分母为结论中包含该类别的样本，
+// 召回率的分母为标注为该类别的样本，两者都要求结论中的类别与标注一致；DenyRate 只要求拒绝
+type CategoryReport struct {
+	Category  string  `json:"category"`
+	Support   int     `json:"support"`
+	Predicted int     `json:"predicted"`
+	Correct   int     `json:"correct"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	DenyRate  float64 `json:"deny_rate"`
+}
+
+// LatencyReport 为检查耗时的统计，单位为毫秒
+type LatencyReport struct {
+	Mean float64 `json:"mean_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P95  float64 `json:"p95_ms"`
+	P99  float64 `json:"p99_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+// Report 为一个检查器在数据集上的评估结果
+type Report struct {
+	Name      string `json:"name"`
+	Total     int    `json:"total"`
+	Evaluated int    `json:"evaluated"`
+	Errors    int    `json:"errors"`
+
+	Confusion         Confusion        `json:"confusion"`
+	Accuracy          float64          `json:"accuracy"`
+	Precision         float64          `json:"precision"`
+	Recall            float64          `json:"recall"`
+	F1                float64          `json:"f1"`
+	FalsePositiveRate float64          `json:"false_positive_rate"`
+	Categories        []CategoryReport `json:"categories"`
+	Latency           LatencyReport    `json:"latency"`
+
+	Results []Result `json:"results"`
+}
+
+// Disagreement 为两个检查器结论不同的一条样本
+type Disagreement struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	Category string `json:"category,omitempty"`
+	Text     string `json:"text"`
+	A        Result `json:"a"`
+	B        Result `json:"b"`
+}
+
+// Summary 为一次评估的全部结果，用于导出
+type Summary struct {
+	Dataset       string         `json:"dataset"`
+	Started       time.Time      `json:"started"`
+	Reports       []*Report      `json:"reports"`
+	Disagreements []Disagreement `json:"disagreements,omitempty"`
+}
+
+// Summarize 根据检查结果计算指标
+func Summarize(name string, results []Result) *Report {
+	r := &Report{Name: name, Total: len(results), Results: results}
+
+	type counter struct{ support, predicted, correct, denied int }
+	categories := make(map[string]*counter)
+	get := func(c string) *counter {
+		if categories[c] == nil {
+			categories[c] = &counter{}
+		}
+		return categories[c]
+	}
+
+	var latencies []time.Duration
+	for _, res := range results {
+		latencies = append(latencies, res.Latency)
+		if res.Error != "" {
+			r.Errors++
+			continue
+		}
+		r.Evaluated++
+
+		malicious := res.Label == LabelMalicious
+		switch {
+		case malicious && res.Denied():
+			r.Confusion.TP++
+		case malicious:
+			r.Confusion.FN++
+		case res.Denied():
+			r.Confusion.FP++
+		default:
+			r.Confusion.TN++
+		}
+
+		if malicious && res.Category != "" {
+			c := get(res.Category)
+			c.support++
+			if res.Denied() {
+				c.denied++
+			}
+		}
+		if res.Denied() {
+			for _, pc := range res.Categories {
+				c := get(pc)
+				c.predicted++
+				if malicious && pc == res.Category {
+					c.correct++
+				}
+			}
+		}
+	}
+
+	cm := r.Confusion
+	r.Accuracy = ratio(cm.TP+cm.TN, r.Evaluated)
+	r.Precision = ratio(cm.TP, cm.TP+cm.FP)
+	r.Recall = ratio(cm.TP, cm.TP+cm.FN)
+	if r.Precision+r.Recall > 0 {
+		r.F1 = 2 * r.Precision * r.Recall / (r.Precision + r.Recall)
+	}
+	r.FalsePositiveRate = ratio(cm.FP, cm.FP+cm.TN)
+
+	for name, c := range categories {
+		r.Categories = append(r.Categories, CategoryReport{
+			Category:  name,
+			Support:   c.support,
+			Predicted: c.predicted,
+			Correct:   c.correct,
+			Precision: ratio(c.correct, c.predicted),
+			Recall:    ratio(c.correct, c.support),
+			DenyRate:  ratio(c.denied, c.support),
+		})
+	}
+	sort.Slice(r.Categories, func(i, j int) bool {
+		if r.Categories[i].Support != r.Categories[j].Support {
+			return r.Categories[i].Support > r.Categories[j].Support
+		}
+		return r.Categories[i].Category < r.Categories[j].Category
+	})
+
+	r.Latency = latencyReport(latencies)
+	return r
+}
+
+// Compare 返回两个检查器都没有出错、但是否拒绝的结论不同的样本
+func Compare(cases []Case, a, b *Report) []Disagreement {
+	var diffs []Disagreement
+	for i, c := range cases {
+		ra, rb := a.Results[i], b.Results[i]
+		if ra.Error != "" || rb.Error != "" || ra.Allowed == rb.Allowed {
+			continue
+		}
+		diffs = append(diffs, Disagreement{
+			ID:       c.ID,
+			Label:    c.Label,
+			Category: c.Category,
+			Text:     excerpt(c.Input().String(), 200),
+			A:        ra,
+			B:        rb,
+		})
+	}
+	return diffs
+}
+
+// WriteJSON 以JSON格式导出评估结果
+func (s *Summary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// WriteMarkdown 以Markdown格式导出评估结果，不包含逐条样本的结果
+func (s *Summary) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# 审核模型评估报告\n\n")
+	fmt.Fprintf(&b, "- 数据集: `%s`\n- 开始时间: %s\n\n", s.Dataset, s.Started.Format(time.RFC3339))
+
+	b.WriteString("## 总体指标\n\n")
+	b.WriteString("| 检查器 | 样本数 | 出错 | TP | FP | TN | FN | 准确率 | 精确率 | 召回率 | F1 | 误报率 |\n")
+	b.WriteString("|--------|--------|------|----|----|----|----|--------|--------|--------|----|--------|\n")
+	for _, r := range s.Reports {
+		cm := r.Confusion
+		fmt.Fprintf(&b, "| %s | %d | %d | %d | %d | %d | %d | %s | %s | %s | %s | %s |\n",
+			r.Name, r.Total, r.Errors, cm.TP, cm.FP, cm.TN, cm.FN,
+			percent(r.Accuracy), percent(r.Precision), percent(r.Recall), percent(r.F1), percent(r.FalsePositiveRate))
+	}
+
+	b.WriteString("\n## 耗时（毫秒）\n\n")
+	b.WriteString("| 检查器 | 平均 | P50 | P90 | P95 | P99 | 最大 |\n")
+	b.WriteString("|--------|------|-----|-----|-----|-----|------|\n")
+	for _, r := range s.Reports {
+		l := r.Latency
+		fmt.Fprintf(&b, "| %s | %.0f | %.0f | %.0f | %.0f | %.0f | %.0f |\n", r.Name, l.Mean, l.P50, l.P90, l.P95, l.P99, l.Max)
+	}
+
+	for _, r := range s.Reports {
+		fmt.Fprintf(&b, "\n## 各类别指标: %s\n\n", r.Name)
+		b.WriteString("| 类别 | 标注数 | 判定数 | 正确 | 精确率 | 召回率 | 拒绝率 |\n")
+		b.WriteString("|------|--------|--------|------|--------|--------|--------|\n")
+		for _, c := range r.Categories {
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %s | %s | %s |\n",
+				c.Category, c.Support, c.Predicted, c.Correct, percent(c.Precision), percent(c.Recall), percent(c.DenyRate))
+		}
+	}
+
+	if len(s.Reports) == 2 {
+		a, bb := s.Reports[0], s.Reports[1]
+		fmt.Fprintf(&b, "\n## 结论不同的样本（%d 条）\n\n", len(s.Disagreements))
+		if len(s.Disagreements) > 0 {
+			fmt.Fprintf(&b, "| 样本 | 标注 | %s | %s | 内容 |\n", a.Name, bb.Name)
+			b.WriteString("|------|------|----|----|------|\n")
+			for _, d := range s.Disagreements {
+				label := d.Label
+				if d.Category != "" {
+					label += "/" + d.Category
+				}
+				fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n",
+					d.ID, label, describe(d.A), describe(d.B), markdownCell(d.Text))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// describe 以简短的文字描述一条结论
+func describe(r Result) string {
+	if r.Allowed {
+		return fmt.Sprintf("允许 %.2f", r.Score)
+	}
+	if len(r.Categories) == 0 {
+		return fmt.Sprintf("拒绝 %.2f", r.Score)
+	}
+	return fmt.Sprintf("拒绝 %.2f %s", r.Score, strings.Join(r.Categories, ","))
+}
+
+// latencyReport 计算耗时的平均值和分位数
+func latencyReport(latencies []time.Duration) LatencyReport {
+	if len(latencies) == 0 {
+		return LatencyReport{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	// 最近秩法计算分位数
+	pct := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ms(sorted[i])
+	}
+	return LatencyReport{
+		Mean: ms(sum) / float64(len(sorted)),
+		P50:  pct(0.50),
+		P90:  pct(0.90),
+		P95:  pct(0.95),
+		P99:  pct(0.99),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+}
+
+// ratio 计算比例，分母为0时返回0
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// percent 把比例格式化为百分数
+func percent(v float64) string {
+	return fmt.Sprintf("%.1f%%", v*100)
+}
+
+// excerpt 截取前 n 个字符
+func excerpt(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
+
+// markdownCell 转义表格单元格中的竖线和换行
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
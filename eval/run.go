@@ -0,0 +1,89 @@
+package eval
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+)
+
+// Result 为一条样本的检查结果
+type Result struct {
+	ID         string        `json:"id"`
+	Label      string        `json:"label"`
+	Category   string        `json:"category,omitempty"`
+	Allowed    bool          `json:"allowed"`
+	Categories []string      `json:"categories,omitempty"`
+	Score      float64       `json:"score"`
+	Rationale  string        `json:"rationale,omitempty"`
+	Latency    time.Duration `json:"latency_ns"`
+	// Error 为检查出错的原因，出错的样本不计入混淆矩阵
+	Error string `json:"error,omitempty"`
+}
+
+// Denied 判断检查器是否拒绝了样本
+func (r Result) Denied() bool {
+	return !r.Allowed
+}
+
+// Run 以 concurrency 个并发把数据集逐条交给检查器，timeout 大于0时限制每条样本的检查时间。
+// 返回的结果与数据集一一对应
+func Run(ctx context.Context, checker admission.Checker, cases []Case, concurrency int, timeout time.Duration) []Result {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]Result, len(cases))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = check(ctx, checker, cases[i], timeout)
+
+				mu.Lock()
+				done++
+				if done%50 == 0 || done == len(cases) {
+					log.Printf("[评估] 已完成 %d/%d", done, len(cases))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range cases {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// check 检查一条样本
+func check(ctx context.Context, checker admission.Checker, c Case, timeout time.Duration) Result {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	res := Result{ID: c.ID, Label: c.Label, Category: c.Category, Allowed: true}
+	started := time.Now()
+	verdict, err := admission.CheckInput(ctx, checker, c.Input())
+	res.Latency = time.Since(started)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Allowed = verdict.Allowed
+	res.Categories = verdict.Categories
+	res.Score = verdict.Score
+	res.Rationale = verdict.Rationale
+	return res
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+	"github.com/mfzzf/LLM_Based_HoneyPot/eval"
+)
+
+// runEval 实现 eval 子命令：用带标签的数据集评估按配置组装的准入检查器，可以同时评估两个检查器并列出结论不同的样本
+func runEval(args []string) {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	dataset := fs.String("dataset", "", "JSONL数据集，每行为 {\"id\", \"text\" 或 \"messages\", \"label\": \"malicious|benign\", \"category\"}")
+	configFile := fs.String("config", "", "检查器A的配置文件，为空时使用默认配置")
+	model := fs.String("model", "", "覆盖检查器A的审核模型")
+	compareConfig := fs.String("compare-config", "", "检查器B的配置文件")
+	compareModel := fs.String("compare-model", "", "覆盖检查器B的审核模型，没有 -compare-config 时使用检查器A的配置")
+	concurrency := fs.Int("concurrency", 4, "并发检查的样本数")
+	timeout := fs.Int("timeout", 0, "每条样本的检查超时时间（秒），0 表示只使用配置中的超时时间")
+	useCache := fs.Bool("cache", false, "使用配置中的审核结论缓存，默认关闭以免影响耗时统计")
+	jsonOut := fs.String("json", "", "以JSON格式导出结果的文件，包含逐条样本的结论")
+	markdownOut := fs.String("markdown", "", "以Markdown格式导出报告的文件，为空时输出到标准输出")
+	fs.Parse(args)
+
+	if *dataset == "" {
+		fmt.Fprintln(os.Stderr, "用法: hp eval -dataset cases.jsonl [-config config.json] [-model phi3:3.8b] [-compare-model qwen2.5:7b] [-json report.json] [-markdown report.md]")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*dataset)
+	if err != nil {
+		log.Fatalf("无法打开数据集: %v", err)
+	}
+	cases, err := eval.ReadDataset(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("无法读取数据集: %v", err)
+	}
+	if len(cases) == 0 {
+		log.Fatalf("数据集中没有样本")
+	}
+
+	type target struct {
+		name    string
+		checker admission.Checker
+	}
+	var targets []target
+	add := func(file, model string) {
+		cfg := config.DefaultConfig()
+		if file != "" {
+			if cfg, err = config.LoadConfig(file); err != nil {
+				log.Fatalf("无法加载配置文件: %v", err)
+			}
+		}
+		if model != "" {
+			cfg.Admission.ModelName = model
+		}
+		if !*useCache {
+			cfg.Admission.Cache.Enabled = false
+		}
+		shared, err := admission.NewShared(cfg.Admission)
+		if err != nil {
+			log.Fatalf("无法创建检查器: %v", err)
+		}
+		checker, err := admission.NewChecker(cfg.Admission, cfg.DLP, shared)
+		if err != nil {
+			log.Fatalf("无法创建检查器: %v", err)
+		}
+		targets = append(targets, target{name: checkerName(file, cfg.Admission), checker: checker})
+	}
+
+	add(*configFile, *model)
+	if *compareConfig != "" || *compareModel != "" {
+		file := *compareConfig
+		if file == "" {
+			file = *configFile
+		}
+		add(file, *compareModel)
+		if targets[0].name == targets[1].name {
+			targets[0].name += " (A)"
+			targets[1].name += " (B)"
+		}
+	}
+
+	summary := &eval.Summary{Dataset: *dataset, Started: time.Now()}
+	for _, t := range targets {
+		log.Printf("[评估] 开始评估 %s: 样本数=%d, 并发=%d", t.name, len(cases), *concurrency)
+		results := eval.Run(context.Background(), t.checker, cases, *concurrency, time.Duration(*timeout)*time.Second)
+		summary.Reports = append(summary.Reports, eval.Summarize(t.name, results))
+	}
+	if len(summary.Reports) == 2 {
+		summary.Disagreements = eval.Compare(cases, summary.Reports[0], summary.Reports[1])
+	}
+
+	if *jsonOut != "" {
+		writeReport(*jsonOut, summary.WriteJSON)
+	}
+	if *markdownOut != "" {
+		writeReport(*markdownOut, summary.WriteMarkdown)
+	} else if err := summary.WriteMarkdown(os.Stdout); err != nil {
+		log.Fatalf("输出报告失败: %v", err)
+	}
+}
+
+// checkerName 返回报告中检查器的名称：单个审核模型时为模型名，组合检查器时为各阶段的名称
+func checkerName(file string, cfg config.AdmissionConfig) string {
+	if len(cfg.Chain.Stages) == 0 {
+		return cfg.ModelName
+	}
+	var stages []string
+	for _, st := range cfg.Chain.Stages {
+		stages = append(stages, st.Name)
+	}
+	name := cfg.Chain.Strategy + "(" + strings.Join(stages, ",") + ")"
+	if file != "" {
+		name = filepath.Base(file) + ":" + name
+	}
+	return name
+}
+
+// writeReport 把报告写入文件
+func writeReport(path string, write func(w io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("无法创建报告文件: %v", err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		log.Fatalf("写入报告失败: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("写入报告失败: %v", err)
+	}
+	log.Printf("[评估] 报告已写入 %s", path)
+}
@@ -0,0 +1,90 @@
+package knn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Sample 为 JSONL 样本文件中的一行
+type Sample struct {
+	ID       string `json:"id"`
+	Text     string `json:"text"`
+	Label    string `json:"label"`
+	Category string `json:"category"`
+}
+
+// ReadSamples 读取 JSONL 格式的样本，忽略空行
+func ReadSamples(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var s Sample
+		if err := json.Unmarshal([]byte(text), &s); err != nil {
+			return nil, fmt.Errorf("第 %d 行解析失败: %w", line, err)
+		}
+		if strings.TrimSpace(s.Text) == "" {
+			return nil, fmt.Errorf("第 %d 行缺少 text", line)
+		}
+		if s.Label != LabelMalicious && s.Label != LabelBenign {
+			return nil, fmt.Errorf("第 %d 行的 label 无效: %q，可选值为 %s、%s", line, s.Label, LabelMalicious, LabelBenign)
+		}
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("line-%d", line)
+		}
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取样本文件失败: %w", err)
+	}
+	return samples, nil
+}
+
+// Build 使用 model 为样本批量生成向量并构建索引，batchSize 为每次请求 embedding 接口的样本数
+func Build(ctx context.Context, embedder *Embedder, model string, samples []Sample, batchSize int) (*Index, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("没有样本")
+	}
+	if batchSize <= 0 {
+		batchSize = 32
+	}
+
+	idx := &Index{Model: model, Built: time.Now()}
+	for start := 0; start < len(samples); start += batchSize {
+		end := start + batchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		batch := samples[start:end]
+
+		inputs := make([]string, len(batch))
+		for i, s := range batch {
+			inputs[i] = s.Text
+		}
+		vectors, err := embedder.Embed(ctx, model, inputs)
+		if err != nil {
+			return nil, fmt.Errorf("为第 %d-%d 条样本生成向量失败: %w", start+1, end, err)
+		}
+		for i, s := range batch {
+			entry := Entry{ID: s.ID, Text: s.Text, Label: s.Label, Category: s.Category, Vector: vectors[i]}
+			if err := idx.Add(entry); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("[kNN] 已生成向量: %d/%d", end, len(samples))
+	}
+	return idx, nil
+}
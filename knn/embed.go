@@ -0,0 +1,70 @@
+package knn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Embedder 调用 Ollama 的 /api/embed 接口生成向量
+type Embedder struct {
+	ollamaURL string
+	client    *http.Client
+}
+
+// NewEmbedder 创建一个 embedding 客户端
+func NewEmbedder(ollamaURL string, timeout time.Duration) *Embedder {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &Embedder{
+		ollamaURL: ollamaURL,
+		client:    &http.Client{Timeout: timeout},
+	}
+}
+
+// Embed 使用指定的模型为一批文本生成向量，返回的向量与输入一一对应
+func (e *Embedder) Embed(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"model": model,
+		"input": inputs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.ollamaURL+"/api/embed", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("创建HTTP请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("调用 embedding 接口失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding 接口返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("解析 embedding 响应失败: %w", err)
+	}
+	if len(result.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("embedding 接口返回了 %d 个向量，请求了 %d 个", len(result.Embeddings), len(inputs))
+	}
+	return result.Embeddings, nil
+}
@@ -0,0 +1,169 @@
+package knn
+
+import (
+	"encoding/gob"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 样本标签
+const (
+	LabelMalicious = "malicious"
+	LabelBenign    = "benign"
+)
+
+// indexVersion 为索引文件的格式版本，格式不兼容时递增
+const indexVersion = 1
+
+// Entry 为索引中的一条带标签样本，Vector 已归一化为单位向量
+type Entry struct {
+	ID       string
+	Text     string
+	Label    string
+	Category string
+	Vector   []float32
+}
+
+// Index 为带标签的向量索引。样本量在数万条以内时逐条计算余弦相似度已足够快，
+// 因此采用平铺存储，不维护近似最近邻的图结构
+type Index struct {
+	Version int
+	// Model 为生成向量使用的 embedding 模型，查询时必须使用同一个模型
+	Model   string
+	Dim     int
+	Built   time.Time
+	Entries []Entry
+}
+
+// Neighbor 为一条最近邻样本及其与查询的余弦相似度
+type Neighbor struct {
+	ID         string  `json:"id"`
+	Label      string  `json:"label"`
+	Category   string  `json:"category,omitempty"`
+	Similarity float64 `json:"similarity"`
+	Text       string  `json:"text,omitempty"`
+}
+
+// Add 归一化向量后加入索引，第一条样本决定向量维度
+func (idx *Index) Add(e Entry) error {
+	if len(e.Vector) == 0 {
+		return fmt.Errorf("样本 %s 的向量为空", e.ID)
+	}
+	if idx.Dim == 0 {
+		idx.Dim = len(e.Vector)
+	}
+	if len(e.Vector) != idx.Dim {
+		return fmt.Errorf("样本 %s 的向量维度为 %d，与索引的维度 %d 不一致", e.ID, len(e.Vector), idx.Dim)
+	}
+	if !normalize(e.Vector) {
+		return fmt.Errorf("样本 %s 的向量为零向量", e.ID)
+	}
+	idx.Entries = append(idx.Entries, e)
+	return nil
+}
+
+// Search 返回与查询向量最相似的 k 条样本，按相似度从高到低排序
+func (idx *Index) Search(query []float32, k int) ([]Neighbor, error) {
+	if len(query) != idx.Dim {
+		return nil, fmt.Errorf("查询向量的维度为 %d，与索引的维度 %d 不一致", len(query), idx.Dim)
+	}
+	q := append([]float32(nil), query...)
+	if !normalize(q) {
+		return nil, fmt.Errorf("查询向量为零向量")
+	}
+
+	neighbors := make([]Neighbor, 0, len(idx.Entries))
+	for i := range idx.Entries {
+		e := &idx.Entries[i]
+		neighbors = append(neighbors, Neighbor{
+			ID:         e.ID,
+			Label:      e.Label,
+			Category:   e.Category,
+			Similarity: dot(q, e.Vector),
+			Text:       e.Text,
+		})
+	}
+	sort.SliceStable(neighbors, func(i, j int) bool {
+		return neighbors[i].Similarity > neighbors[j].Similarity
+	})
+	if k > 0 && len(neighbors) > k {
+		neighbors = neighbors[:k]
+	}
+	return neighbors, nil
+}
+
+// Load 读取索引文件
+func Load(path string) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取索引文件失败: %w", err)
+	}
+	defer f.Close()
+
+	var idx Index
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("解析索引文件失败: %w", err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("索引文件的格式版本为 %d，当前支持的版本为 %d，请重新构建", idx.Version, indexVersion)
+	}
+	if len(idx.Entries) == 0 {
+		return nil, fmt.Errorf("索引文件中没有样本")
+	}
+	return &idx, nil
+}
+
+// Save 写入索引文件。先写入同目录下的临时文件再重命名，正在监视该文件的进程不会读到写了一半的索引
+func (idx *Index) Save(path string) error {
+	idx.Version = indexVersion
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("创建索引文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("创建索引文件失败: %w", err)
+	}
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入索引文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("写入索引文件失败: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("写入索引文件失败: %w", err)
+	}
+	return nil
+}
+
+// normalize 把向量归一化为单位向量，零向量返回 false
+func normalize(v []float32) bool {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return false
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return true
+}
+
+// dot 计算两个向量的点积，两者均为单位向量时即为余弦相似度
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,83 @@
+package knn
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store 持有当前使用的索引，索引文件被替换后自动加载新的索引，加载期间查询继续使用原有的索引
+type Store struct {
+	path string
+
+	mu      sync.RWMutex
+	index   *Index
+	modTime time.Time
+}
+
+// NewStore 加载索引文件，interval 大于0时按该间隔检查文件是否修改并重新加载
+func NewStore(path string, interval time.Duration) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go s.watch(interval)
+	}
+	return s, nil
+}
+
+// Index 返回当前使用的索引
+func (s *Store) Index() *Index {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index
+}
+
+// Reload 重新加载索引文件，加载失败时保留原有的索引
+func (s *Store) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	idx, err := Load(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.index = idx
+	s.modTime = info.ModTime()
+	s.mu.Unlock()
+
+	log.Printf("[kNN] 已加载索引文件 %s: 样本数=%d, 维度=%d, 模型=%s, 构建时间=%s",
+		s.path, len(idx.Entries), idx.Dim, idx.Model, idx.Built.Format(time.RFC3339))
+	return nil
+}
+
+// watch 定期检查索引文件的修改时间
+func (s *Store) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			continue
+		}
+		s.mu.RLock()
+		changed := !info.ModTime().Equal(s.modTime)
+		s.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			log.Printf("[kNN] 重新加载索引文件失败，继续使用原有索引: %v", err)
+			// 记录修改时间，避免同一个错误的文件反复加载
+			s.mu.Lock()
+			s.modTime = info.ModTime()
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/knn"
+)
+
+// runKNNBuild 实现 knn-build 子命令：读取带标签的 JSONL 样本，生成向量并写入索引文件。
+// 正在运行的代理会按 reload_seconds 检测到索引文件被替换并加载新的索引
+func runKNNBuild(args []string) {
+	fs := flag.NewFlagSet("knn-build", flag.ExitOnError)
+	input := fs.String("input", "", "JSONL样本文件，每行为 {\"id\", \"text\", \"label\": \"malicious|benign\", \"category\"}")
+	output := fs.String("output", "knn.index", "输出的索引文件路径")
+	model := fs.String("model", "nomic-embed-text", "embedding模型")
+	ollamaURL := fs.String("ollama", "http://localhost:11434", "Ollama服务地址")
+	batch := fs.Int("batch", 32, "每次请求embedding接口的样本数")
+	timeout := fs.Int("timeout", 120, "每次请求embedding接口的超时时间（秒）")
+	fs.Parse(args)
+
+	if *input == "" {
+		fmt.Fprintln(os.Stderr, "用法: hp knn-build -input samples.jsonl -output knn.index [-model nomic-embed-text] [-ollama http://localhost:11434]")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*input)
+	if err != nil {
+		log.Fatalf("无法打开样本文件: %v", err)
+	}
+	samples, err := knn.ReadSamples(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("无法读取样本文件: %v", err)
+	}
+
+	malicious := 0
+	for _, s := range samples {
+		if s.Label == knn.LabelMalicious {
+			malicious++
+		}
+	}
+	log.Printf("[kNN] 已读取样本: 总数=%d, 恶意=%d, 正常=%d", len(samples), malicious, len(samples)-malicious)
+
+	embedder := knn.NewEmbedder(*ollamaURL, time.Duration(*timeout)*time.Second)
+	idx, err := knn.Build(context.Background(), embedder, *model, samples, *batch)
+	if err != nil {
+		log.Fatalf("构建索引失败: %v", err)
+	}
+	if err := idx.Save(*output); err != nil {
+		log.Fatalf("保存索引失败: %v", err)
+	}
+	log.Printf("[kNN] 索引已写入 %s: 样本数=%d, 维度=%d, 模型=%s", *output, len(idx.Entries), idx.Dim, idx.Model)
+}
@@ -0,0 +1,516 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// BulkStats 为批量写入的统计数据
+type BulkStats struct {
+	Queued  int   `json:"queued"`  // 当前队列中等待写入的文档数
+	Indexed int64 `json:"indexed"` // 写入成功的文档数
+	Failed  int64 `json:"failed"`  // 重试后仍写入失败的文档数
+	Dropped int64 `json:"dropped"` // 队列已满或关闭超时而丢弃的文档数
+	Retried int64 `json:"retried"` // 重试的文档数
+	Batches int64 `json:"batches"` // 发送的批次数
+
+	Spooled       int64 `json:"spooled"`        // 写入本地缓冲的文档数
+	Replayed      int64 `json:"replayed"`       // 从本地缓冲重放的文档数
+	SpoolSegments int   `json:"spool_segments"` // 等待重放的缓冲文件数
+	SpoolBytes    int64 `json:"spool_bytes"`    // 等待重放的缓冲字节数
+}
+
+// bulkOp 为一条批量写入操作
+type bulkOp struct {
+	action string // index、create 或 update
+	index  string
+	id     string // 文档ID，重复写入时覆盖同一文档
+	body   []byte
+}
+
+// bulkWriter 把文档放入有界队列，由后台协程按批次通过 _bulk 接口写入，
+// 只有一个写入协程，同一请求的文档按提交顺序写入（先写入请求日志，再补充分析结果）。
+// 配置了本地缓冲时，重试后仍无法写入的批次写入缓冲；缓冲中有日志时新的批次也追加到缓冲，
+// 由写入协程按顺序重放，保证至少写入一次
+type bulkWriter struct {
+	client       *elasticsearch.Client
+	queue        chan bulkOp
+	batchSize    int
+	flushBytes   int
+	interval     time.Duration
+	block        bool
+	blockTimeout time.Duration
+	maxRetries   int
+	closeTimeout time.Duration
+
+	// setup 在第一次写入前执行（如安装数据流的索引模板），成功后置为空；暂时失败时本批按写入失败处理，
+	// 下次写入前重试；Elasticsearch拒绝安装时不再重试，文档照常写入同名的索引
+	setup func(ctx context.Context) error
+
+	spool         *spool // 为空时不缓冲，重试后仍失败的文档计为失败
+	retryInterval time.Duration
+	kick          chan struct{} // 重放成功且还有缓冲时立即继续重放
+	down          bool          // Elasticsearch不可用，用于只在状态变化时输出日志
+
+	// ctx 在关闭超时后取消，中断正在进行的写入
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu      sync.RWMutex // 保护 closed，关闭后不再接收文档
+	closed  bool
+	once    sync.Once
+	statsMu sync.Mutex
+	stats   BulkStats
+}
+
+// newBulkWriter 创建批量写入器并启动后台写入协程，sp 为空时不使用本地缓冲，setup 为空时不需要初始化
+func newBulkWriter(client *elasticsearch.Client, cfg config.BulkConfig, sp *spool, retryInterval time.Duration, setup func(context.Context) error) *bulkWriter {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &bulkWriter{
+		client:        client,
+		setup:         setup,
+		queue:         make(chan bulkOp, cfg.QueueSize),
+		batchSize:     cfg.BatchSize,
+		flushBytes:    cfg.FlushBytes,
+		interval:      time.Duration(cfg.FlushIntervalMs) * time.Millisecond,
+		block:         cfg.Overflow == "block",
+		blockTimeout:  time.Duration(cfg.BlockTimeoutMs) * time.Millisecond,
+		maxRetries:    cfg.MaxRetries,
+		closeTimeout:  time.Duration(cfg.CloseTimeoutSeconds) * time.Second,
+		spool:         sp,
+		retryInterval: retryInterval,
+		kick:          make(chan struct{}, 1),
+		ctx:           ctx,
+		cancel:        cancel,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	if sp != nil && sp.pending() {
+		w.kick <- struct{}{}
+	}
+	log.Printf("Elasticsearch批量写入已启用: 队列=%d, 批次=%d, 刷新间隔=%s, 队列满时=%s",
+		cfg.QueueSize, cfg.BatchSize, w.interval, cfg.Overflow)
+	go w.run()
+	return w
+}
+
+// add 把文档放入队列，不会等待写入完成；队列已满时按配置丢弃或短暂等待
+func (w *bulkWriter) add(op bulkOp) {
+	if op.id == "" {
+		op.id = docID(op.index, op.body)
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.drop(1)
+		return
+	}
+
+	select {
+	case w.queue <- op:
+		return
+	default:
+	}
+	if w.block && w.blockTimeout > 0 {
+		timer := time.NewTimer(w.blockTimeout)
+		defer timer.Stop()
+		select {
+		case w.queue <- op:
+			return
+		case <-timer.C:
+		}
+	}
+	w.drop(1)
+}
+
+// docID 根据索引和文档内容生成确定的ID，重试或重放时覆盖已写入的文档而不是重复写入
+func docID(index string, doc []byte) string {
+	h := sha256.New()
+	h.Write([]byte(index))
+	h.Write([]byte{0})
+	h.Write(doc)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// index 写入一个文档，id 为空时根据文档内容生成
+func (w *bulkWriter) index(index, id string, doc []byte) {
+	w.add(bulkOp{action: "index", index: index, id: id, body: doc})
+}
+
+// create 写入一个文档，文档ID已存在时视为已经写入，用于只能追加的数据流
+func (w *bulkWriter) create(index, id string, doc []byte) {
+	w.add(bulkOp{action: "create", index: index, id: id, body: doc})
+}
+
+// update 以部分更新的方式修改文档
+func (w *bulkWriter) update(index, id string, doc []byte) {
+	w.add(bulkOp{action: "update", index: index, id: id, body: doc})
+}
+
+func (w *bulkWriter) drop(n int) {
+	w.statsMu.Lock()
+	w.stats.Dropped += int64(n)
+	w.statsMu.Unlock()
+}
+
+// Stats 返回批量写入的统计数据
+func (w *bulkWriter) Stats() BulkStats {
+	w.statsMu.Lock()
+	stats := w.stats
+	w.statsMu.Unlock()
+	stats.Queued = len(w.queue)
+	if w.spool != nil {
+		stats.SpoolSegments, stats.SpoolBytes = w.spool.stats()
+	}
+	return stats
+}
+
+// run 收集文档，达到批次大小或刷新间隔时写入
+func (w *bulkWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	statsTicker := time.NewTicker(time.Minute)
+	defer statsTicker.Stop()
+	// 没有本地缓冲时不需要重放
+	var replayC <-chan time.Time
+	if w.spool != nil {
+		replayTicker := time.NewTicker(w.retryInterval)
+		defer replayTicker.Stop()
+		replayC = replayTicker.C
+	}
+
+	var batch []bulkOp
+	size := 0
+	appendOp := func(op bulkOp) {
+		batch = append(batch, op)
+		size += len(op.body)
+		if len(batch) >= w.batchSize || size >= w.flushBytes {
+			w.flush(batch)
+			batch, size = nil, 0
+		}
+	}
+
+	var last BulkStats
+	for {
+		select {
+		case op := <-w.queue:
+			appendOp(op)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch, size = nil, 0
+			}
+		case <-replayC:
+			w.replay()
+		case <-w.kick:
+			w.replay()
+		case <-statsTicker.C:
+			// 只在出现失败、丢弃或缓冲时输出统计，避免正常运行时刷屏
+			stats := w.Stats()
+			if stats.Failed != last.Failed || stats.Dropped != last.Dropped ||
+				stats.Spooled != last.Spooled || stats.Replayed != last.Replayed {
+				w.logStats(stats)
+			}
+			last = stats
+		case <-w.stop:
+			// 关闭时写入队列中剩余的文档
+			for {
+				select {
+				case op := <-w.queue:
+					appendOp(op)
+				default:
+					if len(batch) > 0 {
+						w.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush 写入一个批次，整批请求失败或文档被限流时重试
+func (w *bulkWriter) flush(batch []bulkOp) {
+	// 缓冲中还有没重放的日志时直接追加到缓冲，保证写入顺序
+	if w.spool != nil && w.spool.pending() {
+		w.spoolOps(batch)
+		return
+	}
+
+	pending := batch
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > w.maxRetries || w.ctx.Err() != nil {
+				w.giveUp(pending)
+				return
+			}
+			w.statsMu.Lock()
+			w.stats.Retried += int64(len(pending))
+			w.statsMu.Unlock()
+
+			backoff := time.Duration(1<<(attempt-1)) * 500 * time.Millisecond
+			select {
+			case <-time.After(backoff):
+			case <-w.ctx.Done():
+				w.giveUp(pending)
+				return
+			}
+		}
+
+		retry, err := w.send(pending)
+		if err != nil {
+			log.Printf("批量写入Elasticsearch失败（第%d次）: %v", attempt+1, err)
+		}
+		pending = retry
+	}
+}
+
+// giveUp 处理重试后仍无法写入的文档，有本地缓冲时写入缓冲，否则计为失败
+func (w *bulkWriter) giveUp(ops []bulkOp) {
+	if w.spool != nil {
+		w.spoolOps(ops)
+		return
+	}
+	w.fail(len(ops))
+}
+
+// spoolOps 把文档写入本地缓冲，缓冲已满或写入失败时丢弃
+func (w *bulkWriter) spoolOps(ops []bulkOp) {
+	if err := w.spool.append(ops); err != nil {
+		log.Printf("写入本地缓冲失败，丢弃%d个文档: %v", len(ops), err)
+		w.drop(len(ops))
+		return
+	}
+	w.statsMu.Lock()
+	w.stats.Spooled += int64(len(ops))
+	w.statsMu.Unlock()
+}
+
+// replay 重放最早的一个缓冲文件，全部写入后删除；失败时保留该文件等待下次重试，
+// 已写入的部分在重试时按文档ID覆盖
+func (w *bulkWriter) replay() {
+	if !w.spool.pending() || !w.available() {
+		return
+	}
+	seg, ops, err := w.spool.oldest()
+	if err != nil {
+		log.Printf("读取本地缓冲失败: %v", err)
+		return
+	}
+	if seg.path == "" {
+		return
+	}
+
+	for start := 0; start < len(ops); start += w.batchSize {
+		end := start + w.batchSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+		retry, err := w.send(ops[start:end])
+		if err != nil || len(retry) > 0 {
+			if err != nil {
+				log.Printf("重放本地缓冲失败，%s 后重试: %v", w.retryInterval, err)
+			}
+			return
+		}
+	}
+
+	if err := w.spool.remove(seg); err != nil {
+		log.Printf("删除本地缓冲文件失败: %v", err)
+	}
+	w.statsMu.Lock()
+	w.stats.Replayed += int64(len(ops))
+	w.statsMu.Unlock()
+	log.Printf("已重放本地缓冲文件 %s，文档数=%d", filepath.Base(seg.path), len(ops))
+
+	if w.spool.pending() {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// available 检查Elasticsearch是否已恢复。不可用时不读取缓冲，避免每次重试都切换新的缓冲文件
+func (w *bulkWriter) available() bool {
+	res, err := w.client.Info(w.client.Info.WithContext(w.ctx))
+	if err == nil {
+		if res.IsError() {
+			err = fmt.Errorf("elasticsearch返回错误: %s", res.Status())
+		}
+		res.Body.Close()
+	}
+	if err != nil {
+		if !w.down {
+			log.Printf("Elasticsearch不可用，日志写入本地缓冲，每%s检查一次: %v", w.retryInterval, err)
+			w.down = true
+		}
+		return false
+	}
+	if w.down {
+		log.Printf("Elasticsearch已恢复，开始重放本地缓冲")
+		w.down = false
+	}
+	return true
+}
+
+func (w *bulkWriter) fail(n int) {
+	w.statsMu.Lock()
+	w.stats.Failed += int64(n)
+	w.statsMu.Unlock()
+}
+
+// bulkResponse 为 _bulk 接口的响应，每个操作对应 items 中的一项
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// send 发送一次 _bulk 请求，返回需要重试的操作。整个请求失败时全部重试，
+// 单个文档被限流（429）时重试该文档以及本批中之后写入同一文档的操作，其它错误（如字段映射冲突）计为失败。
+// 同一文档之后的操作即使已经成功也要按顺序重新执行，否则重试的 index 会覆盖已经合并进来的 update
+func (w *bulkWriter) send(ops []bulkOp) ([]bulkOp, error) {
+	if w.setup != nil {
+		if err := w.setup(w.ctx); err != nil {
+			var rejected setupRejected
+			if !errors.As(err, &rejected) {
+				return ops, err
+			}
+			// 运行中无法终止启动，改为写入普通索引，避免每个批次都失败直到缓冲写满
+			log.Printf("错误: %v。不再重试安装数据流，日志写入同名的普通索引", err)
+		}
+		w.setup = nil
+	}
+
+	var buf bytes.Buffer
+	for _, op := range ops {
+		meta := map[string]interface{}{"_index": op.index, "_id": op.id}
+		if op.action == "update" {
+			meta["retry_on_conflict"] = 3
+		}
+		line, _ := json.Marshal(map[string]interface{}{op.action: meta})
+		buf.Write(line)
+		buf.WriteByte('\n')
+		buf.Write(op.body)
+		buf.WriteByte('\n')
+	}
+
+	w.statsMu.Lock()
+	w.stats.Batches++
+	w.statsMu.Unlock()
+
+	res, err := w.client.Bulk(&buf, w.client.Bulk.WithContext(w.ctx))
+	if err != nil {
+		return ops, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return ops, fmt.Errorf("elasticsearch返回错误: %s", res.String())
+	}
+
+	var br bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&br); err != nil {
+		// 无法确认各文档的结果，全部重试，已写入的文档按ID覆盖
+		return ops, fmt.Errorf("解析批量写入响应失败: %w", err)
+	}
+
+	var retry []bulkOp
+	var indexed, failed int64
+	var firstErr error
+	throttled := make(map[[2]string]bool) // 被限流的文档（索引和ID）
+	for i, item := range br.Items {
+		if i >= len(ops) {
+			break
+		}
+		doc := [2]string{ops[i].index, ops[i].id}
+		if throttled[doc] {
+			retry = append(retry, ops[i])
+			continue
+		}
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+				indexed++
+			case result.Status == 409 && ops[i].action == "create":
+				// 重试或重放时文档已经写入
+				indexed++
+			case result.Status == 429:
+				throttled[doc] = true
+				retry = append(retry, ops[i])
+			default:
+				failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("文档写入 %s 失败（%d）: %s", ops[i].index, result.Status, result.Error)
+				}
+			}
+		}
+	}
+
+	w.statsMu.Lock()
+	w.stats.Indexed += indexed
+	w.stats.Failed += failed
+	w.statsMu.Unlock()
+	if failed > 0 {
+		log.Printf("批量写入Elasticsearch时有%d个文档失败，首个错误: %v", failed, firstErr)
+	}
+	return retry, nil
+}
+
+// close 停止接收文档并在超时前写入剩余文档，超时后中断写入，
+// 未写入的文档有本地缓冲时写入缓冲，否则丢弃
+func (w *bulkWriter) close() error {
+	var err error
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+		close(w.stop)
+
+		timer := time.NewTimer(w.closeTimeout)
+		defer timer.Stop()
+		select {
+		case <-w.done:
+		case <-timer.C:
+			w.cancel()
+			<-w.done
+			err = fmt.Errorf("关闭日志记录器超时（%s），部分日志未写入Elasticsearch", w.closeTimeout)
+		}
+		w.cancel()
+
+		// 超时中断后留在队列中的文档计为丢弃
+		if n := len(w.queue); n > 0 {
+			w.drop(n)
+		}
+		if w.spool != nil {
+			w.spool.close()
+		}
+		w.logStats(w.Stats())
+	})
+	return err
+}
+
+func (w *bulkWriter) logStats(stats BulkStats) {
+	log.Printf("Elasticsearch批量写入统计: 已写入=%d, 失败=%d, 丢弃=%d, 重试=%d, 批次=%d, 排队=%d, 缓冲=%d, 重放=%d, 待重放文件=%d",
+		stats.Indexed, stats.Failed, stats.Dropped, stats.Retried, stats.Batches, stats.Queued,
+		stats.Spooled, stats.Replayed, stats.SpoolSegments)
+}
@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// TestBulkThrottledOrder 请求日志被限流时，同一文档之后的更新要在重试的请求日志之后重新执行
+func TestBulkThrottledOrder(t *testing.T) {
+	fake := &fakeBulkServer{throttled: 1}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &bulkWriter{client: client, maxRetries: 1, ctx: context.Background()}
+	w.flush([]bulkOp{
+		{action: "index", index: "hp", id: "req-1", body: []byte(`{"request":{}}`)},
+		{action: "update", index: "hp", id: "req-1", body: []byte(`{"doc":{"response":{}},"doc_as_upsert":true}`)},
+		{action: "index", index: "hp", id: "req-2", body: []byte(`{"request":{}}`)},
+		{action: "update", index: "hp-admission", id: "req-1", body: []byte(`{"doc":{},"doc_as_upsert":true}`)},
+		{action: "update", index: "hp", id: "req-1", body: []byte(`{"doc":{"verdicts":[]},"doc_as_upsert":true}`)},
+	})
+
+	want := []string{"req-1", "req-1", "req-2", "req-1", "req-1", "req-1", "req-1", "req-1"}
+	if got := fake.received(); !equalIDs(got, want) {
+		t.Errorf("received %v, want %v", got, want)
+	}
+	// 重试的批次先写入请求日志，再按原来的顺序执行两次更新
+	wantActions := []string{"index", "update", "index", "update", "update", "index", "update", "update"}
+	if !equalIDs(fake.actions, wantActions) {
+		t.Errorf("actions %v, want %v", fake.actions, wantActions)
+	}
+	// 第一批写入了 req-2 和另一个索引中的 req-1，重试的三个操作全部成功
+	if stats := w.Stats(); stats.Indexed != 5 || stats.Retried != 3 || stats.Failed != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,322 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// ecsMappings 为 ECS 字段和 llm 命名空间的映射，未列出的字段使用动态映射
+const ecsMappings = `{
+  "dynamic": true,
+  "properties": {
+    "@timestamp": {"type": "date"},
+    "ecs": {"properties": {"version": {"type": "keyword"}}},
+    "event": {"properties": {
+      "kind": {"type": "keyword"},
+      "category": {"type": "keyword"},
+      "type": {"type": "keyword"},
+      "action": {"type": "keyword"},
+      "outcome": {"type": "keyword"},
+      "module": {"type": "keyword"},
+      "dataset": {"type": "keyword"},
+      "start": {"type": "date"},
+      "end": {"type": "date"},
+      "duration": {"type": "long"}
+    }},
+    "observer": {"properties": {
+      "type": {"type": "keyword"},
+      "product": {"type": "keyword"},
+      "hostname": {"type": "keyword"}
+    }},
+    "source": {"properties": {
+      "address": {"type": "keyword"},
+      "ip": {"type": "ip"},
+      "port": {"type": "long"}
+    }},
+    "http": {"properties": {
+      "version": {"type": "keyword"},
+      "request": {"properties": {
+        "id": {"type": "keyword"},
+        "method": {"type": "keyword"},
+        "mime_type": {"type": "keyword"},
+        "headers": {"type": "flattened"},
+        "body": {"properties": {
+          "content": {"type": "wildcard", "fields": {"text": {"type": "match_only_text"}}},
+          "bytes": {"type": "long"}
+        }}
+      }},
+      "response": {"properties": {
+        "status_code": {"type": "long"},
+        "mime_type": {"type": "keyword"},
+        "headers": {"type": "flattened"},
+        "body": {"properties": {
+          "content": {"type": "wildcard", "fields": {"text": {"type": "match_only_text"}}},
+          "bytes": {"type": "long"}
+        }}
+      }}
+    }},
+    "url": {"properties": {
+      "original": {"type": "wildcard", "fields": {"text": {"type": "match_only_text"}}},
+      "path": {"type": "wildcard"},
+      "query": {"type": "keyword"}
+    }},
+    "user_agent": {"properties": {
+      "original": {"type": "keyword", "fields": {"text": {"type": "match_only_text"}}}
+    }},
+    "threat": {"properties": {
+      "framework": {"type": "keyword"},
+      "technique": {"properties": {
+        "id": {"type": "keyword"},
+        "name": {"type": "keyword", "fields": {"text": {"type": "match_only_text"}}}
+      }}
+    }},
+    "llm": {"properties": {
+      "request": {"properties": {
+        "model": {"type": "keyword"},
+        "prompt": {"type": "text"},
+        "system": {"type": "text"},
+        "messages": {"properties": {
+          "role": {"type": "keyword"},
+          "content": {"type": "text"}
+        }},
+        "stream": {"type": "boolean"},
+        "temperature": {"type": "float"}
+      }},
+      "response": {"properties": {
+        "model": {"type": "keyword"},
+        "generated_text": {"type": "text"},
+        "response": {"type": "text"},
+        "finished": {"type": "boolean"},
+        "total_duration": {"type": "long"},
+        "prompt_eval_count": {"type": "long"},
+        "eval_count": {"type": "long"},
+        "eval_duration": {"type": "long"}
+      }},
+      "stream": {"properties": {
+        "chunks": {"type": "long"},
+        "first_chunk_ms": {"type": "long"}
+      }},
+      "admission": {"properties": {
+        "stage": {"type": "keyword"},
+        "allowed": {"type": "boolean"},
+        "content": {"type": "text"},
+        "reason": {"type": "text"},
+        "model_name": {"type": "keyword"},
+        "categories": {"type": "keyword"},
+        "score": {"type": "float"},
+        "rationale": {"type": "text"},
+        "raw_output": {"type": "text", "index": false},
+        "latency_ms": {"type": "long"},
+        "failure_mode": {"type": "keyword"},
+        "shadow": {"type": "boolean"},
+        "rule_id": {"type": "keyword"},
+        "cached": {"type": "boolean"},
+        "obfuscation": {"type": "keyword"},
+        "dlp_types": {"type": "keyword"},
+        "policy": {"type": "keyword"},
+        "skipped": {"type": "boolean"}
+      }},
+      "output_moderation": {"properties": {
+        "stage": {"type": "keyword"},
+        "path": {"type": "keyword"},
+        "model": {"type": "keyword"},
+        "stream": {"type": "boolean"},
+        "allowed": {"type": "boolean"},
+        "reason": {"type": "text"},
+        "categories": {"type": "keyword"},
+        "score": {"type": "float"},
+        "action": {"type": "keyword"},
+        "checked_chars": {"type": "long"},
+        "checks": {"type": "long"},
+        "policy": {"type": "keyword"}
+      }},
+      "techniques": {"properties": {
+        "tag": {"type": "keyword"},
+        "id": {"type": "keyword"},
+        "name": {"type": "keyword"},
+        "sources": {"type": "keyword"},
+        "signature_id": {"type": "keyword"},
+        "role": {"type": "keyword"},
+        "confidence": {"type": "float"},
+        "evidence": {"type": "text"}
+      }},
+      "detection_error": {"type": "text"},
+      "dlp": {"properties": {
+        "request": {"properties": {"types": {"type": "keyword"}}},
+        "response": {"properties": {"types": {"type": "keyword"}}}
+      }}
+    }}
+  }
+}`
+
+// setupRejected 表示Elasticsearch拒绝安装数据流，如同名的普通索引已存在、权限不足或配置无效，重试没有意义
+type setupRejected struct {
+	error
+}
+
+// rejectedStatus 判断安装请求的错误状态码是否为重试也不会成功的客户端错误，超时和限流除外
+func rejectedStatus(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// dataStreamSetup 安装数据流需要的ILM策略、组件模板和可组合索引模板，并在数据流不存在时创建。
+// 策略和模板每次都按当前配置覆盖，只影响之后滚动创建的后备索引
+type dataStreamSetup struct {
+	client *elasticsearch.Client
+	name   string
+	cfg    config.DataStreamConfig
+	// patterns 为模板匹配的数据流，split 布局下还包括 <index>-admission
+	patterns []string
+}
+
+func newDataStreamSetup(client *elasticsearch.Client, cfg config.ELKConfig) *dataStreamSetup {
+	s := &dataStreamSetup{client: client, name: cfg.Index, cfg: cfg.DataStream, patterns: []string{cfg.Index}}
+	if cfg.Layout == "split" {
+		s.patterns = append(s.patterns, cfg.Index+"-admission")
+	}
+	return s
+}
+
+// run 依次安装ILM策略、组件模板、索引模板，然后创建数据流
+func (s *dataStreamSetup) run(ctx context.Context) error {
+	policy := s.name + "-policy"
+	if err := s.put(ctx, "ILM策略 "+policy, s.policy(), func(body *bytes.Reader) (*esapi.Response, error) {
+		return s.client.ILM.PutLifecycle(policy, s.client.ILM.PutLifecycle.WithBody(body), s.client.ILM.PutLifecycle.WithContext(ctx))
+	}); err != nil {
+		return err
+	}
+
+	settings := map[string]interface{}{"template": map[string]interface{}{"settings": map[string]interface{}{
+		"index.lifecycle.name":     policy,
+		"index.number_of_shards":   s.cfg.Shards,
+		"index.number_of_replicas": s.cfg.Replicas,
+	}}}
+	mappings := map[string]interface{}{"template": map[string]interface{}{"mappings": json.RawMessage(ecsMappings)}}
+	components := []struct {
+		name string
+		body interface{}
+	}{{s.name + "@settings", settings}, {s.name + "@mappings", mappings}}
+	for _, c := range components {
+		name := c.name
+		if err := s.put(ctx, "组件模板 "+name, c.body, func(body *bytes.Reader) (*esapi.Response, error) {
+			return s.client.Cluster.PutComponentTemplate(name, body, s.client.Cluster.PutComponentTemplate.WithContext(ctx))
+		}); err != nil {
+			return err
+		}
+	}
+
+	// 优先级高于Elasticsearch内置的 logs-*-* 模板（100）
+	template := map[string]interface{}{
+		"index_patterns": s.patterns,
+		"data_stream":    map[string]interface{}{},
+		"composed_of":    []string{s.name + "@settings", s.name + "@mappings"},
+		"priority":       200,
+		"_meta":          map[string]interface{}{"description": "LLM_Based_HoneyPot 蜜罐日志（ECS）", "ecs_version": ecsVersion},
+	}
+	if err := s.put(ctx, "索引模板 "+s.name, template, func(body *bytes.Reader) (*esapi.Response, error) {
+		return s.client.Indices.PutIndexTemplate(s.name, body, s.client.Indices.PutIndexTemplate.WithContext(ctx))
+	}); err != nil {
+		return err
+	}
+
+	for _, name := range s.patterns {
+		if err := s.createDataStream(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// policy 生成ILM策略：hot 阶段按配置滚动，配置了 delete_after 时滚动后到期删除
+func (s *dataStreamSetup) policy() map[string]interface{} {
+	rollover := map[string]interface{}{}
+	if s.cfg.RolloverMaxAge != "" {
+		rollover["max_age"] = s.cfg.RolloverMaxAge
+	}
+	if s.cfg.RolloverMaxPrimarySize != "" {
+		rollover["max_primary_shard_size"] = s.cfg.RolloverMaxPrimarySize
+	}
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{"actions": map[string]interface{}{"rollover": rollover}},
+	}
+	if s.cfg.DeleteAfter != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": s.cfg.DeleteAfter,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	return map[string]interface{}{"policy": map[string]interface{}{"phases": phases}}
+}
+
+// put 发送一个创建或覆盖请求
+func (s *dataStreamSetup) put(ctx context.Context, what string, v interface{}, do func(*bytes.Reader) (*esapi.Response, error)) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("序列化%s失败: %w", what, err)
+	}
+	res, err := do(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("安装%s失败: %w", what, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		err := fmt.Errorf("安装%s失败: %s", what, res.String())
+		if rejectedStatus(res.StatusCode) {
+			return setupRejected{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// createDataStream 在数据流不存在时创建。同名的普通索引已存在时返回错误，数据流不能与普通索引同名
+func (s *dataStreamSetup) createDataStream(ctx context.Context, name string) error {
+	res, err := s.client.Indices.GetDataStream(s.client.Indices.GetDataStream.WithName(name), s.client.Indices.GetDataStream.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("查询数据流 %s 失败: %w", name, err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		log.Printf("Elasticsearch数据流 %s 已存在，索引模板和ILM策略已更新", name)
+		return nil
+	}
+
+	exists, err := s.client.Indices.Exists([]string{name}, s.client.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("查询索引 %s 失败: %w", name, err)
+	}
+	exists.Body.Close()
+	if exists.StatusCode == http.StatusOK {
+		return setupRejected{fmt.Errorf("已存在名为 %s 的普通索引，不能创建同名数据流，请修改 elk.index（如 logs-ollama_proxy-default），或关闭 elk.data_stream 继续使用普通索引", name)}
+	}
+
+	res, err = s.client.Indices.CreateDataStream(name, s.client.Indices.CreateDataStream.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("创建数据流 %s 失败: %w", name, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		body := res.String()
+		// 其它实例同时创建了该数据流
+		if strings.Contains(body, "resource_already_exists_exception") {
+			log.Printf("Elasticsearch数据流 %s 已存在，索引模板和ILM策略已更新", name)
+			return nil
+		}
+		err := fmt.Errorf("创建数据流 %s 失败: %s", name, body)
+		if rejectedStatus(res.StatusCode) {
+			return setupRejected{err}
+		}
+		return err
+	}
+	log.Printf("已创建Elasticsearch数据流 %s", name)
+	return nil
+}
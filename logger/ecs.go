@@ -0,0 +1,313 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/dlp"
+)
+
+// ecsVersion 为日志文档遵循的 Elastic Common Schema 版本
+const ecsVersion = "8.11.0"
+
+// ecsTimeFormat 为 ECS 文档中的时间格式，精确到毫秒
+const ecsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// ecsDoc 为 ECS 格式的日志文档，只包含用到的字段，大模型相关的字段放在自定义的 llm 命名空间。
+// 每个事件生成的 ecsDoc 只包含该事件的字段，合并到交互文档时不会覆盖其它事件的字段
+type ecsDoc struct {
+	Timestamp string        `json:"@timestamp,omitempty"`
+	ECS       *ecsMeta      `json:"ecs,omitempty"`
+	Event     *ecsEvent     `json:"event,omitempty"`
+	Observer  *ecsObserver  `json:"observer,omitempty"`
+	Source    *ecsSource    `json:"source,omitempty"`
+	HTTP      *ecsHTTP      `json:"http,omitempty"`
+	URL       *ecsURL       `json:"url,omitempty"`
+	UserAgent *ecsUserAgent `json:"user_agent,omitempty"`
+	Threat    *ecsThreat    `json:"threat,omitempty"`
+	LLM       *ecsLLM       `json:"llm,omitempty"`
+}
+
+type ecsMeta struct {
+	Version string `json:"version"`
+}
+
+type ecsEvent struct {
+	Kind     string   `json:"kind,omitempty"`
+	Category []string `json:"category,omitempty"`
+	Type     []string `json:"type,omitempty"`
+	Action   string   `json:"action,omitempty"`
+	Outcome  string   `json:"outcome,omitempty"`
+	Module   string   `json:"module,omitempty"`
+	Dataset  string   `json:"dataset,omitempty"`
+	Start    string   `json:"start,omitempty"`
+	End      string   `json:"end,omitempty"`
+	Duration int64    `json:"duration,omitempty"` // 纳秒
+}
+
+type ecsObserver struct {
+	Type     string `json:"type"`
+	Product  string `json:"product"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+type ecsSource struct {
+	Address string `json:"address"`
+	IP      string `json:"ip,omitempty"`
+	Port    int    `json:"port,omitempty"`
+}
+
+type ecsHTTP struct {
+	Version  string           `json:"version,omitempty"`
+	Request  *ecsHTTPRequest  `json:"request,omitempty"`
+	Response *ecsHTTPResponse `json:"response,omitempty"`
+}
+
+// ecsHTTPRequest 中的 headers 不是 ECS 字段，索引模板中映射为 flattened 类型
+type ecsHTTPRequest struct {
+	ID       string            `json:"id"`
+	Method   string            `json:"method,omitempty"`
+	MimeType string            `json:"mime_type,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     *ecsBody          `json:"body,omitempty"`
+}
+
+type ecsHTTPResponse struct {
+	StatusCode int               `json:"status_code"`
+	MimeType   string            `json:"mime_type,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       *ecsBody          `json:"body,omitempty"`
+}
+
+type ecsBody struct {
+	Content string `json:"content,omitempty"`
+	Bytes   int    `json:"bytes"`
+}
+
+type ecsURL struct {
+	Original string `json:"original"`
+	Path     string `json:"path"`
+	Query    string `json:"query,omitempty"`
+}
+
+type ecsUserAgent struct {
+	Original string `json:"original"`
+}
+
+// ecsThreat 为识别出的攻击手法，编号为 MITRE ATLAS 技术编号
+type ecsThreat struct {
+	Framework string             `json:"framework"`
+	Technique ecsThreatTechnique `json:"technique"`
+}
+
+type ecsThreatTechnique struct {
+	ID   []string `json:"id"`
+	Name []string `json:"name"`
+}
+
+// ecsLLM 为自定义的 llm 命名空间，沿用原有格式的结构
+type ecsLLM struct {
+	Request          *LLMRequestInfo      `json:"request,omitempty"`
+	Response         *LLMResponseInfo     `json:"response,omitempty"`
+	Stream           *StreamStats         `json:"stream,omitempty"`
+	Admission        *AdmissionLog        `json:"admission,omitempty"`
+	OutputModeration *OutputModerationLog `json:"output_moderation,omitempty"`
+	Techniques       []TechniqueLog       `json:"techniques,omitempty"`
+	DetectionError   string               `json:"detection_error,omitempty"`
+	DLP              *ecsDLP              `json:"dlp,omitempty"`
+}
+
+// ecsDLP 为请求和响应中识别出的敏感数据
+type ecsDLP struct {
+	Request  *ecsDLPFindings `json:"request,omitempty"`
+	Response *ecsDLPFindings `json:"response,omitempty"`
+}
+
+type ecsDLPFindings struct {
+	Findings []dlp.Finding `json:"findings"`
+	Types    []string      `json:"types"`
+}
+
+// ecsEventDefaults 为单独写入的事件默认的 event.category 和 event.type
+var ecsEventDefaults = map[string]struct{ category, typ []string }{
+	EventRequest:    {[]string{"web"}, []string{"access"}},
+	EventResponse:   {[]string{"web"}, []string{"access"}},
+	EventAdmission:  {[]string{"intrusion_detection"}, nil},
+	EventOutput:     {[]string{"intrusion_detection"}, nil},
+	EventAnnotation: {[]string{"threat"}, []string{"indicator"}},
+}
+
+var observerHostname, _ = os.Hostname()
+
+// standalone 补充 ECS 的公共字段，生成可以单独写入的事件文档：@timestamp、ecs.version、
+// event.kind、event.action 等，以及用于关联同一交互的 http.request.id。不修改 d 本身
+func (d *ecsDoc) standalone(typ, reqID string, t time.Time, denied bool) *ecsDoc {
+	out := *d
+	out.Timestamp = t.UTC().Format(ecsTimeFormat)
+	out.ECS = &ecsMeta{Version: ecsVersion}
+	out.Observer = &ecsObserver{Type: "honeypot", Product: "LLM_Based_HoneyPot", Hostname: observerHostname}
+
+	ev := ecsEvent{}
+	if d.Event != nil {
+		ev = *d.Event
+	}
+	ev.Action = typ
+	ev.Module = "ollama_proxy"
+	ev.Dataset = "ollama_proxy.access"
+	if ev.Kind == "" {
+		ev.Kind = "event"
+	}
+	defaults := ecsEventDefaults[typ]
+	if ev.Category == nil {
+		ev.Category = defaults.category
+	}
+	if ev.Type == nil {
+		ev.Type = defaults.typ
+		if ev.Type == nil {
+			ev.Type = []string{verdictType(!denied)}
+		}
+	}
+	out.Event = &ev
+
+	if d.HTTP == nil || d.HTTP.Request == nil {
+		h := ecsHTTP{}
+		if d.HTTP != nil {
+			h = *d.HTTP
+		}
+		h.Request = &ecsHTTPRequest{ID: reqID}
+		out.HTTP = &h
+	}
+	return &out
+}
+
+func verdictType(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+func newECSBody(content string) *ecsBody {
+	if content == "" {
+		return nil
+	}
+	return &ecsBody{Content: content, Bytes: len(content)}
+}
+
+func newECSDLP(findings []dlp.Finding) *ecsDLPFindings {
+	if len(findings) == 0 {
+		return nil
+	}
+	return &ecsDLPFindings{Findings: findings, Types: dlp.FindingTypes(findings)}
+}
+
+// ecsRequest 生成请求的 ECS 字段，reqLog 中的请求头和请求体已经过敏感数据处理
+func (l *MultiLogger) ecsRequest(req *http.Request, reqLog RequestLog, started time.Time) *ecsDoc {
+	d := &ecsDoc{
+		Event: &ecsEvent{Start: started.UTC().Format(ecsTimeFormat)},
+		HTTP: &ecsHTTP{
+			Version: fmt.Sprintf("%d.%d", req.ProtoMajor, req.ProtoMinor),
+			Request: &ecsHTTPRequest{
+				ID:       reqLog.ID,
+				Method:   req.Method,
+				MimeType: reqLog.Headers["Content-Type"],
+				Headers:  reqLog.Headers,
+				Body:     newECSBody(reqLog.Body),
+			},
+		},
+		URL: &ecsURL{
+			Original: l.redactText(req.URL.RequestURI()),
+			Path:     req.URL.Path,
+			Query:    l.redactText(req.URL.RawQuery),
+		},
+		Source: &ecsSource{Address: req.RemoteAddr},
+		LLM:    &ecsLLM{Request: reqLog.LLMRequest},
+	}
+
+	// RemoteAddr 为 host:port，分别写入 source.ip 和 source.port
+	if host, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		d.Source.Address = host
+		d.Source.IP = host
+		d.Source.Port, _ = strconv.Atoi(port)
+	}
+	if ua := reqLog.Headers["User-Agent"]; ua != "" {
+		d.UserAgent = &ecsUserAgent{Original: ua}
+	}
+	if f := newECSDLP(reqLog.DLPFindings); f != nil {
+		d.LLM.DLP = &ecsDLP{Request: f}
+	}
+	return d
+}
+
+// ecsResponse 生成响应的 ECS 字段，包括交互的结束时间、耗时和结果
+func ecsResponse(respLog ResponseLog, mimeType string, end time.Time, duration time.Duration) *ecsDoc {
+	outcome := "success"
+	if respLog.Status >= 400 {
+		outcome = "failure"
+	}
+	d := &ecsDoc{
+		Event: &ecsEvent{
+			End:      end.UTC().Format(ecsTimeFormat),
+			Duration: duration.Nanoseconds(),
+			Outcome:  outcome,
+		},
+		HTTP: &ecsHTTP{Response: &ecsHTTPResponse{
+			StatusCode: respLog.Status,
+			MimeType:   mimeType,
+			Headers:    respLog.Headers,
+			Body:       newECSBody(respLog.Body),
+		}},
+		LLM: &ecsLLM{Response: respLog.LLMResponse, Stream: respLog.Stream},
+	}
+	if f := newECSDLP(respLog.DLPFindings); f != nil {
+		d.LLM.DLP = &ecsDLP{Response: f}
+	}
+	return d
+}
+
+// ecsAdmission 生成输入侧准入结论的 ECS 字段，拒绝时（包括影子模式下只记录的拒绝）event.kind 为 alert
+func ecsAdmission(entry AdmissionLog) *ecsDoc {
+	entry.RequestID, entry.Timestamp = "", ""
+	d := &ecsDoc{
+		Event: &ecsEvent{
+			Category: []string{"web", "intrusion_detection"},
+			Type:     []string{"access", verdictType(entry.Allowed)},
+		},
+		LLM: &ecsLLM{Admission: &entry},
+	}
+	if !entry.Allowed {
+		d.Event.Kind = "alert"
+	}
+	return d
+}
+
+// ecsOutputModeration 生成输出审核结论的 ECS 字段，输出违规时 event.kind 为 alert
+func ecsOutputModeration(entry OutputModerationLog) *ecsDoc {
+	entry.RequestID, entry.Timestamp = "", ""
+	d := &ecsDoc{LLM: &ecsLLM{OutputModeration: &entry}}
+	if !entry.Allowed {
+		d.Event = &ecsEvent{Kind: "alert"}
+	}
+	return d
+}
+
+// ecsAnnotation 生成攻击手法识别结果的 ECS 字段，技术编号同时写入 threat.technique 便于使用通用的检测规则
+func ecsAnnotation(ann RequestAnnotation) *ecsDoc {
+	d := &ecsDoc{LLM: &ecsLLM{Techniques: ann.Techniques, DetectionError: ann.DetectionError}}
+	if len(ann.TechniqueIDs) > 0 {
+		t := &ecsThreat{Framework: "MITRE ATLAS", Technique: ecsThreatTechnique{ID: ann.TechniqueIDs}}
+		seen := make(map[string]bool)
+		for _, tech := range ann.Techniques {
+			if !seen[tech.ID] {
+				seen[tech.ID] = true
+				t.Technique.Name = append(t.Technique.Name, tech.Name)
+			}
+		}
+		d.Threat = t
+	}
+	return d
+}
@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// ELKSink 把事件批量写入Elasticsearch。interaction 布局下请求日志以请求ID为文档ID，响应、准入结论、
+// 输出审核和分析结果以部分更新的方式合并到该文档；split 布局下请求和响应写入 <index>，
+// 准入控制和输出审核写入 <index>-admission，只有分析结果更新请求日志。
+// 写入数据流时文档只能追加：interaction 布局在内存中合并后写入一次，split 布局每个事件写入一个文档
+type ELKSink struct {
+	bulk       *bulkWriter
+	index      string
+	merged     bool // interaction 布局
+	dataStream bool
+	merger     *interactionMerger // 数据流的 interaction 布局
+}
+
+// NewELKSink 创建Elasticsearch写入目标
+func NewELKSink(cfg config.ELKConfig) (*ELKSink, error) {
+	// 配置Elasticsearch客户端
+	esCfg := elasticsearch.Config{
+		Addresses: []string{cfg.URL},
+	}
+
+	// 设置认证方式：优先使用API Key（如果提供），否则使用用户名/密码
+	if cfg.APIKey != "" {
+		esCfg.APIKey = cfg.APIKey
+		log.Println("使用API Key认证Elasticsearch")
+	} else if cfg.Username != "" {
+		esCfg.Username = cfg.Username
+		esCfg.Password = cfg.Password
+		log.Println("使用用户名/密码认证Elasticsearch")
+	}
+
+	client, err := elasticsearch.NewClient(esCfg)
+	if err != nil {
+		return nil, fmt.Errorf("创建elasticsearch客户端失败: %w", err)
+	}
+
+	// 检查连接，启用本地缓冲时连接失败不影响启动，日志先写入缓冲，恢复后重放
+	connErr := checkConnection(client)
+	if connErr != nil {
+		if !cfg.Spool.Enabled {
+			return nil, connErr
+		}
+		log.Printf("警告: %v，日志先写入本地缓冲目录 %s，恢复后重放", connErr, cfg.Spool.Dir)
+	} else {
+		log.Printf("成功连接到Elasticsearch: %s", cfg.URL)
+	}
+
+	// 安装数据流的索引模板和ILM策略，连接不上或暂时失败时在第一次写入前安装。
+	// Elasticsearch拒绝安装时返回错误，由调用方终止启动，不能静默地丢弃日志
+	var setup func(context.Context) error
+	if cfg.DataStream.Enabled {
+		ds := newDataStreamSetup(client, cfg)
+		if connErr != nil {
+			setup = ds.run
+		} else if err := ds.run(context.Background()); err != nil {
+			var rejected setupRejected
+			if errors.As(err, &rejected) || !cfg.Spool.Enabled {
+				return nil, err
+			}
+			log.Printf("警告: 安装数据流失败，第一次写入前重试: %v", err)
+			setup = ds.run
+		}
+	}
+
+	var sp *spool
+	if cfg.Spool.Enabled {
+		if sp, err = openSpool(cfg.Spool); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Elasticsearch文档布局: %s, 数据流: %v", cfg.Layout, cfg.DataStream.Enabled)
+	s := &ELKSink{
+		bulk:       newBulkWriter(client, cfg.Bulk, sp, time.Duration(cfg.Spool.RetrySeconds)*time.Second, setup),
+		index:      cfg.Index,
+		merged:     cfg.Layout != "split",
+		dataStream: cfg.DataStream.Enabled,
+	}
+	if s.merged && s.dataStream {
+		s.merger = newInteractionMerger(
+			time.Duration(cfg.DataStream.MergeWaitSeconds)*time.Second,
+			time.Duration(cfg.DataStream.MaxMergeSeconds)*time.Second,
+			func(reqID string, doc []byte) { s.bulk.create(s.index, reqID, doc) },
+			s.writeEvent)
+	}
+	return s, nil
+}
+
+// checkConnection 检查Elasticsearch是否可用
+func checkConnection(client *elasticsearch.Client) error {
+	res, err := client.Info()
+	if err != nil {
+		return fmt.Errorf("连接elasticsearch失败: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch返回错误: %s", res.String())
+	}
+	return nil
+}
+
+// Write 把事件放入批量写入队列
+func (s *ELKSink) Write(ev Event) {
+	switch {
+	case s.merger != nil:
+		s.merger.add(ev)
+	case s.merged && ev.Type != EventRequest:
+		// 请求日志写入失败时仍以部分内容创建文档，不丢失准入结论
+		doc := append([]byte(`{"doc":`), ev.Patch...)
+		s.bulk.update(s.index, ev.RequestID, append(doc, `,"doc_as_upsert":true}`...))
+	default:
+		s.writeEvent(ev)
+	}
+}
+
+// writeEvent 按 split 布局写入一个事件，数据流中的分析结果也作为单独的文档写入
+func (s *ELKSink) writeEvent(ev Event) {
+	// 输入侧和输出侧准入控制共用索引，通过stage字段区分；interaction 布局下单独写入的事件与交互文档在同一个数据流中
+	index := s.index
+	if !s.merged && (ev.Type == EventAdmission || ev.Type == EventOutput) {
+		index += "-admission"
+	}
+
+	// 请求日志以请求ID为文档ID，供分析结果更新
+	id := ""
+	if ev.Type == EventRequest {
+		id = ev.RequestID
+	}
+	switch {
+	case s.dataStream:
+		s.bulk.create(index, id, ev.Doc)
+	case ev.Type == EventAnnotation:
+		// 与请求日志经同一队列按顺序写入，更新时请求日志已经写入
+		doc := append([]byte(`{"doc":`), ev.Patch...)
+		s.bulk.update(index, ev.RequestID, append(doc, '}'))
+	default:
+		s.bulk.index(index, id, ev.Doc)
+	}
+}
+
+// Stats 返回批量写入的统计数据
+func (s *ELKSink) Stats() BulkStats {
+	return s.bulk.Stats()
+}
+
+// Close 写入等待合并的交互和队列中剩余的日志，最多等待 close_timeout_seconds
+func (s *ELKSink) Close() error {
+	if s.merger != nil {
+		s.merger.close()
+	}
+	return s.bulk.close()
+}
@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// fileWriter 把事件按行写入JSONL文件，文件超过大小上限后轮转为 path.1、path.2……
+type fileWriter struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+func newFileWriter(cfg config.FileSinkConfig) (*fileWriter, error) {
+	w := &fileWriter{path: cfg.Path, maxBytes: cfg.MaxBytes, maxFiles: cfg.MaxFiles}
+	if w.maxBytes <= 0 {
+		w.maxBytes = 100 << 20
+	}
+	if w.maxFiles <= 0 {
+		w.maxFiles = 5
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return nil, fmt.Errorf("创建日志目录失败: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open 以追加方式打开日志文件，日志中包含攻击者的原始请求，只允许本用户读写
+func (w *fileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开日志文件失败: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("读取日志文件失败: %w", err)
+	}
+	w.f = f
+	w.size = info.Size()
+	return nil
+}
+
+func (w *fileWriter) WriteEvent(ev Event) error {
+	line, err := ev.Line()
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if w.size > 0 && w.size+int64(len(line)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if w.f == nil {
+		// 上次轮转后重新打开失败，再试一次
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// rotate 把 path.N 依次重命名为 path.N+1，最早的文件被覆盖，然后打开新的文件
+func (w *fileWriter) rotate() error {
+	w.f.Close()
+	w.f = nil
+	for i := w.maxFiles - 1; i >= 1; i-- {
+		src := fmt.Sprintf("%s.%d", w.path, i)
+		if _, err := os.Stat(src); err == nil {
+			os.Rename(src, fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return fmt.Errorf("轮转日志文件失败: %w", err)
+	}
+	return w.open()
+}
+
+func (w *fileWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	w.f.Sync()
+	return w.f.Close()
+}
+
+// stdoutWriter 把事件按行写入标准输出，便于由容器日志收集；终端日志写入标准错误，不会混在一起
+type stdoutWriter struct {
+	w io.Writer
+}
+
+func newStdoutWriter() *stdoutWriter {
+	return &stdoutWriter{w: os.Stdout}
+}
+
+func (w *stdoutWriter) WriteEvent(ev Event) error {
+	line, err := ev.Line()
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
+
+func (w *stdoutWriter) Close() error {
+	return nil
+}
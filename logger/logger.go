@@ -1,32 +1,42 @@
 package logger
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+	"github.com/mfzzf/LLM_Based_HoneyPot/dlp"
 )
 
 // Logger 是日志记录器接口
 type Logger interface {
 	LogRequest(req *http.Request) string
-	LogResponse(reqID string, resp *http.Response, body []byte)
-	LogAdmission(reqID string, allowed bool, reason string)
+	// LogResponse 记录响应，stream 为流式响应的统计，非流式响应为空
+	LogResponse(reqID string, resp *http.Response, body []byte, stream *StreamStats)
+	LogAdmission(reqID string, entry AdmissionLog)
+	LogOutputModeration(reqID string, entry OutputModerationLog)
+	// AnnotateRequest 把请求记录之后得到的分析结果补充到请求日志中
+	AnnotateRequest(reqID string, ann RequestAnnotation)
 	Close() error
 }
 
-// ELKLogger 实现了使用ELK的日志记录
-type ELKLogger struct {
-	esClient *elasticsearch.Client
-	index    string
-	enabled  bool
+// MultiLogger 生成日志文档并分发到各个写入目标（ELK、文件、syslog等），
+// 请求ID、敏感数据识别和终端日志只处理一次，每个写入目标按各自的过滤条件接收事件
+type MultiLogger struct {
+	sinks  []*sinkEntry
+	dlp    *dlp.Scanner // 为空时不识别敏感数据
+	redact bool         // 写入前替换敏感数据
+	ecs    bool         // 按 ECS 格式生成日志文档
 }
 
 // RequestLog 请求日志结构
@@ -41,6 +51,34 @@ type RequestLog struct {
 
 	// Ollama 特定字段
 	LLMRequest *LLMRequestInfo `json:"llm_request,omitempty"`
+
+	// DLPFindings 为请求中的敏感数据（已脱敏），DLPTypes 为去重后的类型
+	DLPFindings []dlp.Finding `json:"dlp_findings,omitempty"`
+	DLPTypes    []string      `json:"dlp_types,omitempty"`
+
+	// 以下字段在请求记录之后通过 AnnotateRequest 补充
+	RequestAnnotation
+}
+
+// RequestAnnotation 为后台分析请求得到的结果
+type RequestAnnotation struct {
+	// Techniques 为识别出的攻击手法，TechniqueIDs 为对应的 MITRE ATLAS 编号，便于聚合
+	Techniques   []TechniqueLog `json:"techniques,omitempty"`
+	TechniqueIDs []string       `json:"technique_ids,omitempty"`
+	// DetectionError 为模型分类失败的错误信息
+	DetectionError string `json:"detection_error,omitempty"`
+}
+
+// TechniqueLog 识别出的一种攻击手法
+type TechniqueLog struct {
+	Tag         string   `json:"tag"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Sources     []string `json:"sources"`
+	SignatureID string   `json:"signature_id,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Confidence  float64  `json:"confidence"`
+	Evidence    string   `json:"evidence,omitempty"`
 }
 
 // LLMRequestInfo 存储大模型请求的特定信息
@@ -69,81 +107,233 @@ type ResponseLog struct {
 
 	// Ollama 特定字段
 	LLMResponse *LLMResponseInfo `json:"llm_response,omitempty"`
+
+	// DLPFindings 为响应中的敏感数据（已脱敏），模型可能把提示词中的密钥原样输出
+	DLPFindings []dlp.Finding `json:"dlp_findings,omitempty"`
+	DLPTypes    []string      `json:"dlp_types,omitempty"`
+
+	// DurationMs 为从收到请求到响应完成的耗时，包括准入检查
+	DurationMs int64        `json:"duration_ms"`
+	Stream     *StreamStats `json:"stream,omitempty"`
+}
+
+// StreamStats 为流式响应的统计
+type StreamStats struct {
+	Chunks     int       `json:"chunks"`
+	FirstChunk time.Time `json:"-"`
+	// FirstChunkMs 为从收到请求到输出第一个片段的耗时
+	FirstChunkMs int64 `json:"first_chunk_ms"`
 }
 
 // LLMResponseInfo 存储大模型响应的特定信息
 type LLMResponseInfo struct {
-	Model         string `json:"model,omitempty"`
-	GeneratedText string `json:"generated_text,omitempty"`
-	Response      string `json:"response,omitempty"` // chat API返回
-	Finished      bool   `json:"finished,omitempty"`
-	TotalDuration int64  `json:"total_duration,omitempty"`
+	Model           string `json:"model,omitempty"`
+	GeneratedText   string `json:"generated_text,omitempty"`
+	Response        string `json:"response,omitempty"` // chat API返回
+	Finished        bool   `json:"finished,omitempty"`
+	TotalDuration   int64  `json:"total_duration,omitempty"`
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"`
+	EvalCount       int    `json:"eval_count,omitempty"`
+	EvalDuration    int64  `json:"eval_duration,omitempty"`
 }
 
 // AdmissionLog 准入控制日志结构
 type AdmissionLog struct {
-	RequestID string `json:"request_id"`
-	Timestamp string `json:"@timestamp"`
-	Allowed   bool   `json:"allowed"`
-	Content   string `json:"content"`
-	Reason    string `json:"reason,omitempty"`
-	ModelName string `json:"model_name,omitempty"`
+	RequestID  string   `json:"request_id,omitempty"`
+	Timestamp  string   `json:"@timestamp,omitempty"`
+	Stage      string   `json:"stage"`
+	Allowed    bool     `json:"allowed"`
+	Content    string   `json:"content"`
+	Reason     string   `json:"reason,omitempty"`
+	ModelName  string   `json:"model_name,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Score      float64  `json:"score"`
+	Rationale  string   `json:"rationale,omitempty"`
+	RawOutput  string   `json:"raw_output,omitempty"`
+	LatencyMs  int64    `json:"latency_ms"`
+	Error      string   `json:"error,omitempty"`
+	// FailureMode 为审核出错时采用的处理方式，Shadow 表示结论只记录未执行
+	FailureMode string `json:"failure_mode,omitempty"`
+	Shadow      bool   `json:"shadow"`
+	RuleID      string `json:"rule_id,omitempty"`
+	// Cached 表示结论来自缓存，没有调用审核模型
+	Cached bool `json:"cached"`
+	// Obfuscation 为请求中识别出的混淆手段，本身就是可疑的信号
+	Obfuscation []string `json:"obfuscation,omitempty"`
+	// WindowCount 和 Windows 为超长内容分窗口检查时的窗口数和各窗口的结论
+	WindowCount int         `json:"window_count,omitempty"`
+	Windows     []WindowLog `json:"windows,omitempty"`
+	// DLPTypes 为作为准入信号识别出的敏感数据类型
+	DLPTypes []string `json:"dlp_types,omitempty"`
+	// Policy 为按请求选中的准入策略，Skipped 表示该策略不需要准入检查
+	Policy  string `json:"policy,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+	// Checkers 为组合检查器各阶段的结论，便于比较不同检查器
+	Checkers []CheckerLog `json:"checkers,omitempty"`
 }
 
-// NewELKLogger 创建一个新的ELK日志记录器
-func NewELKLogger(cfg config.ELKConfig) (Logger, error) {
-	if !cfg.Enabled {
-		log.Println("ELK日志已禁用")
-		return &ELKLogger{enabled: false}, nil
+// WindowLog 超长内容中单个窗口的结论
+type WindowLog struct {
+	Index      int      `json:"index"`
+	Offset     int      `json:"offset"`
+	Chars      int      `json:"chars"`
+	Skipped    bool     `json:"skipped"`
+	Allowed    bool     `json:"allowed"`
+	Categories []string `json:"categories,omitempty"`
+	Score      float64  `json:"score"`
+	RawOutput  string   `json:"raw_output,omitempty"`
+	LatencyMs  int64    `json:"latency_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// CheckerLog 组合检查器中单个阶段的结论
+type CheckerLog struct {
+	Name       string   `json:"name"`
+	Skipped    bool     `json:"skipped"`
+	Allowed    bool     `json:"allowed"`
+	Undecided  bool     `json:"undecided,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Score      float64  `json:"score"`
+	ModelName  string   `json:"model_name,omitempty"`
+	RuleID     string   `json:"rule_id,omitempty"`
+	RawOutput  string   `json:"raw_output,omitempty"`
+	LatencyMs  int64    `json:"latency_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// OutputModerationLog 输出侧准入控制日志结构
+type OutputModerationLog struct {
+	RequestID    string   `json:"request_id,omitempty"`
+	Timestamp    string   `json:"@timestamp,omitempty"`
+	Stage        string   `json:"stage"`
+	Path         string   `json:"path"`
+	Model        string   `json:"model,omitempty"`
+	Stream       bool     `json:"stream"`
+	Allowed      bool     `json:"allowed"`
+	Reason       string   `json:"reason,omitempty"`
+	Categories   []string `json:"categories,omitempty"`
+	Score        float64  `json:"score"`
+	Action       string   `json:"action"` // none、replace、truncate、cutoff、log、shadow
+	CheckedChars int      `json:"checked_chars"`
+	Checks       int      `json:"checks"`
+	Error        string   `json:"error,omitempty"`
+	Policy       string   `json:"policy,omitempty"`
+}
+
+// NewMultiLogger 创建日志记录器，schema 为日志格式（ecs 或 legacy）。ELK连接失败时只输出警告，
+// 不影响其它写入目标；Elasticsearch拒绝安装数据流或其它写入目标配置有误时返回错误
+func NewMultiLogger(elkCfg config.ELKConfig, sinkCfgs []config.SinkConfig, dlpCfg config.DLPConfig, schema string) (*MultiLogger, error) {
+	m := &MultiLogger{ecs: schema == "ecs"}
+	log.Printf("日志格式: %s", schema)
+	if dlpCfg.Enabled {
+		var err error
+		if m.dlp, err = dlp.NewScanner(dlpCfg.Types); err != nil {
+			return nil, fmt.Errorf("初始化敏感数据识别失败: %w", err)
+		}
+		m.redact = dlpCfg.Redact
+		log.Printf("敏感数据识别已启用: 脱敏=%v", m.redact)
 	}
 
-	// 配置Elasticsearch客户端
-	esCfg := elasticsearch.Config{
-		Addresses: []string{cfg.URL},
+	if elkCfg.Enabled {
+		elk, err := NewELKSink(elkCfg)
+		var rejected setupRejected
+		if errors.As(err, &rejected) {
+			return nil, fmt.Errorf("初始化Elasticsearch数据流失败: %w", err)
+		}
+		if err != nil {
+			log.Printf("警告: 无法初始化ELK日志: %v", err)
+			log.Println("继续运行，但不会记录到ELK")
+		} else {
+			m.sinks = append(m.sinks, &sinkEntry{name: "elk", sink: elk})
+		}
+	} else {
+		log.Println("ELK日志已禁用")
 	}
 
-	// 设置认证方式：优先使用API Key（如果提供），否则使用用户名/密码
-	if cfg.APIKey != "" {
-		esCfg.APIKey = cfg.APIKey
-		log.Println("使用API Key认证Elasticsearch")
-	} else if cfg.Username != "" {
-		esCfg.Username = cfg.Username
-		esCfg.Password = cfg.Password
-		log.Println("使用用户名/密码认证Elasticsearch")
+	for _, sc := range sinkCfgs {
+		e, err := newSink(sc)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("初始化日志写入目标 %s 失败: %w", sc.Name, err)
+		}
+		m.sinks = append(m.sinks, e)
+		log.Printf("[日志] 已启用写入目标 %s: 类型=%s, 事件=%v, 只记录拒绝=%v", e.name, sc.Type, sc.Events, sc.DeniedOnly)
 	}
+	return m, nil
+}
 
-	client, err := elasticsearch.NewClient(esCfg)
+// emit 把日志文档分发到接收该事件的写入目标。doc 为原有格式的日志，fields 为该事件的 ECS 字段，
+// 按 legacy 格式输出时为空。final 表示交互已经结束（已记录响应或请求被拒绝）
+func (m *MultiLogger) emit(typ, reqID string, denied, final bool, doc interface{}, fields *ecsDoc) {
+	ev := Event{Type: typ, RequestID: reqID, Time: time.Now(), Denied: denied, Final: final, ECS: m.ecs}
+	var err error
+	if m.ecs {
+		if ev.Doc, err = json.Marshal(fields.standalone(typ, reqID, ev.Time, denied)); err == nil && typ != EventRequest {
+			ev.Patch, err = json.Marshal(fields)
+		}
+	} else if ev.Doc, err = json.Marshal(doc); err == nil && typ != EventRequest {
+		ev.Patch = legacyPatch(typ, ev.Doc)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("创建elasticsearch客户端失败: %w", err)
+		log.Printf("无法序列化%s日志: %v", typ, err)
+		return
 	}
 
-	// 检查连接
-	res, err := client.Info()
-	if err != nil {
-		return nil, fmt.Errorf("连接elasticsearch失败: %w", err)
+	for _, e := range m.sinks {
+		if e.accepts(ev) {
+			e.sink.Write(ev)
+		}
 	}
-	defer res.Body.Close()
+}
+
+// legacyFields 为原有格式下各事件在交互文档中的字段名，分析结果的字段直接位于文档顶层
+var legacyFields = map[string]string{
+	EventResponse:  "response",
+	EventAdmission: "admission",
+	EventOutput:    "output_moderation",
+}
 
-	if res.IsError() {
-		return nil, fmt.Errorf("elasticsearch返回错误: %s", res.String())
+// legacyPatch 生成原有格式下合并到交互文档的部分内容
+func legacyPatch(typ string, doc []byte) []byte {
+	field, ok := legacyFields[typ]
+	if !ok {
+		return doc
 	}
+	patch := make([]byte, 0, len(doc)+len(field)+5)
+	patch = append(patch, `{"`+field+`":`...)
+	patch = append(patch, doc...)
+	return append(patch, '}')
+}
 
-	log.Printf("成功连接到Elasticsearch: %s", cfg.URL)
+// scrub 识别文本中的敏感数据，开启脱敏时返回替换后的文本
+func (l *MultiLogger) scrub(text string) (string, []dlp.Finding) {
+	if l.dlp == nil {
+		return text, nil
+	}
+	if l.redact {
+		return l.dlp.Redact(text)
+	}
+	return text, l.dlp.Scan(text)
+}
 
-	return &ELKLogger{
-		esClient: client,
-		index:    cfg.Index,
-		enabled:  true,
-	}, nil
+// redactText 开启脱敏时替换文本中的敏感数据
+func (l *MultiLogger) redactText(text string) string {
+	if !l.redact {
+		return text
+	}
+	out, _ := l.scrub(text)
+	return out
 }
 
 // LogRequest 记录请求并返回请求ID
-func (l *ELKLogger) LogRequest(req *http.Request) string {
-	if !l.enabled {
+func (l *MultiLogger) LogRequest(req *http.Request) string {
+	if len(l.sinks) == 0 {
 		return ""
 	}
 
-	reqID := fmt.Sprintf("%d", time.Now().UnixNano())
+	// 请求ID以纳秒时间戳开头，记录响应时据此计算耗时（见 requestTime）
+	started := time.Now()
+	reqID := newRequestID(started)
 
 	// 读取请求体（如果有）
 	var bodyStr string
@@ -167,42 +357,50 @@ func (l *ELKLogger) LogRequest(req *http.Request) string {
 		headers[name] = strings.Join(values, ", ")
 	}
 
+	// 识别请求体和请求头（如 Authorization）中的敏感数据
+	var findings []dlp.Finding
+	bodyStr, findings = l.scrub(bodyStr)
+	for name, value := range headers {
+		var hf []dlp.Finding
+		headers[name], hf = l.scrub(value)
+		findings = append(findings, hf...)
+	}
+	if l.redact && llmRequestInfo != nil {
+		llmRequestInfo.Prompt = l.redactText(llmRequestInfo.Prompt)
+		llmRequestInfo.System = l.redactText(llmRequestInfo.System)
+		for i := range llmRequestInfo.Messages {
+			llmRequestInfo.Messages[i].Content = l.redactText(llmRequestInfo.Messages[i].Content)
+		}
+	}
+
 	// 记录请求
 	reqLog := RequestLog{
-		ID:         reqID,
-		Timestamp:  time.Now().UTC().Format(time.RFC3339),
-		Method:     req.Method,
-		Path:       req.URL.Path,
-		RemoteIP:   req.RemoteAddr,
-		Headers:    headers,
-		Body:       bodyStr,
-		LLMRequest: llmRequestInfo,
-	}
-
-	// 发送到Elasticsearch
-	jsonData, err := json.Marshal(reqLog)
-	if err != nil {
-		log.Printf("无法序列化请求日志: %v", err)
-		return reqID
+		ID:          reqID,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		RemoteIP:    req.RemoteAddr,
+		Headers:     headers,
+		Body:        bodyStr,
+		LLMRequest:  llmRequestInfo,
+		DLPFindings: findings,
+		DLPTypes:    dlp.FindingTypes(findings),
 	}
-
-	_, err = l.esClient.Index(
-		l.index,
-		strings.NewReader(string(jsonData)),
-		l.esClient.Index.WithContext(context.Background()),
-		l.esClient.Index.WithDocumentID(reqID),
-	)
-
-	if err != nil {
-		log.Printf("无法发送请求日志到Elasticsearch: %v", err)
+	if len(findings) > 0 {
+		log.Printf("[DLP] 请求ID: %s - 请求中包含敏感数据: %v", reqID, reqLog.DLPTypes)
 	}
 
+	var fields *ecsDoc
+	if l.ecs {
+		fields = l.ecsRequest(req, reqLog, started)
+	}
+	l.emit(EventRequest, reqID, false, false, reqLog, fields)
 	return reqID
 }
 
-// parseOllamaRequest 解析Ollama API请求
+// parseOllamaRequest 解析Ollama API请求，同时支持OpenAI兼容接口
 func parseOllamaRequest(path string, bodyBytes []byte) *LLMRequestInfo {
-	if !strings.Contains(path, "/api/") {
+	if !strings.Contains(path, "/api/") && !strings.Contains(path, "/v1/") {
 		return nil
 	}
 
@@ -221,6 +419,10 @@ func parseOllamaRequest(path string, bodyBytes []byte) *LLMRequestInfo {
 
 	if temp, ok := requestData["temperature"].(float64); ok {
 		info.Temperature = temp
+	} else if options, ok := requestData["options"].(map[string]interface{}); ok {
+		if temp, ok := options["temperature"].(float64); ok {
+			info.Temperature = temp
+		}
 	}
 
 	if stream, ok := requestData["stream"].(bool); ok {
@@ -238,7 +440,7 @@ func parseOllamaRequest(path string, bodyBytes []byte) *LLMRequestInfo {
 			info.System = system
 		}
 
-	case strings.Contains(path, "/api/chat"):
+	case strings.Contains(path, "/api/chat"), strings.Contains(path, "/v1/chat/completions"):
 		// 处理chat请求
 		if system, ok := requestData["system"].(string); ok {
 			info.System = system
@@ -249,22 +451,55 @@ func parseOllamaRequest(path string, bodyBytes []byte) *LLMRequestInfo {
 			for _, msgRaw := range messagesRaw {
 				if msg, ok := msgRaw.(map[string]interface{}); ok {
 					role, _ := msg["role"].(string)
-					content, _ := msg["content"].(string)
 					info.Messages = append(info.Messages, ChatMessage{
 						Role:    role,
-						Content: content,
+						Content: messageText(msg["content"]),
 					})
 				}
 			}
 		}
+
+	case strings.Contains(path, "/v1/completions"):
+		// 处理OpenAI文本补全请求，prompt可能是字符串或字符串数组
+		switch prompt := requestData["prompt"].(type) {
+		case string:
+			info.Prompt = prompt
+		case []interface{}:
+			var prompts []string
+			for _, p := range prompt {
+				if s, ok := p.(string); ok {
+					prompts = append(prompts, s)
+				}
+			}
+			info.Prompt = strings.Join(prompts, "\n")
+		}
 	}
 
 	return info
 }
 
+// messageText 提取消息内容，兼容OpenAI多模态消息的片段数组
+func messageText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var parts []string
+		for _, part := range c {
+			if p, ok := part.(map[string]interface{}); ok {
+				if text, ok := p["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
 // LogResponse 记录响应
-func (l *ELKLogger) LogResponse(reqID string, resp *http.Response, body []byte) {
-	if !l.enabled || reqID == "" {
+func (l *MultiLogger) LogResponse(reqID string, resp *http.Response, body []byte, stream *StreamStats) {
+	if len(l.sinks) == 0 || reqID == "" {
 		return
 	}
 
@@ -276,36 +511,64 @@ func (l *ELKLogger) LogResponse(reqID string, resp *http.Response, body []byte)
 
 	// 解析Ollama响应
 	var llmResponseInfo *LLMResponseInfo
-	if resp.Header.Get("Content-Type") == "application/json" && len(body) > 0 {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && len(body) > 0 {
 		llmResponseInfo = parseOllamaResponse(resp.Request.URL.Path, body)
 	}
 
+	bodyStr, findings := l.scrub(string(body))
+	if l.redact && llmResponseInfo != nil {
+		llmResponseInfo.GeneratedText = l.redactText(llmResponseInfo.GeneratedText)
+		llmResponseInfo.Response = l.redactText(llmResponseInfo.Response)
+	}
+
 	// 记录响应
 	respLog := ResponseLog{
 		RequestID:   reqID,
 		Timestamp:   time.Now().UTC().Format(time.RFC3339),
 		Status:      resp.StatusCode,
 		Headers:     headers,
-		Body:        string(body),
+		Body:        bodyStr,
 		LLMResponse: llmResponseInfo,
+		DLPFindings: findings,
+		DLPTypes:    dlp.FindingTypes(findings),
+		Stream:      stream,
+	}
+	end := time.Now()
+	var duration time.Duration
+	if started, ok := requestTime(reqID); ok {
+		duration = end.Sub(started)
+		respLog.DurationMs = duration.Milliseconds()
+		if stream != nil && !stream.FirstChunk.IsZero() {
+			stream.FirstChunkMs = stream.FirstChunk.Sub(started).Milliseconds()
+		}
+	}
+	if len(findings) > 0 {
+		log.Printf("[DLP] 请求ID: %s - 响应中包含敏感数据: %v", reqID, respLog.DLPTypes)
 	}
 
-	// 发送到Elasticsearch
-	jsonData, err := json.Marshal(respLog)
-	if err != nil {
-		log.Printf("无法序列化响应日志: %v", err)
-		return
+	var fields *ecsDoc
+	if l.ecs {
+		fields = ecsResponse(respLog, resp.Header.Get("Content-Type"), end, duration)
 	}
+	l.emit(EventResponse, reqID, false, true, respLog, fields)
+}
 
-	_, err = l.esClient.Index(
-		l.index,
-		strings.NewReader(string(jsonData)),
-		l.esClient.Index.WithContext(context.Background()),
-	)
+// newRequestID 生成请求ID：收到请求时的纳秒时间戳加随机后缀。请求ID是Elasticsearch的文档ID和交互合并的键，
+// 只用时间戳时同一纳秒（或时钟精度较低的系统上相近时刻）到达的请求会互相覆盖
+func newRequestID(started time.Time) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", started.UnixNano(), hex.EncodeToString(b))
+}
 
+// requestTime 返回收到请求的时间，请求ID以 LogRequest 时的纳秒时间戳开头
+func requestTime(reqID string) (time.Time, bool) {
+	ts, _, _ := strings.Cut(reqID, "-")
+	ns, err := strconv.ParseInt(ts, 10, 64)
 	if err != nil {
-		log.Printf("无法发送响应日志到Elasticsearch: %v", err)
+		return time.Time{}, false
 	}
+	return time.Unix(0, ns), true
 }
 
 // parseOllamaResponse 解析Ollama API响应
@@ -334,6 +597,15 @@ func parseOllamaResponse(path string, bodyBytes []byte) *LLMResponseInfo {
 	if duration, ok := responseData["total_duration"].(float64); ok {
 		info.TotalDuration = int64(duration)
 	}
+	if count, ok := responseData["prompt_eval_count"].(float64); ok {
+		info.PromptEvalCount = int(count)
+	}
+	if count, ok := responseData["eval_count"].(float64); ok {
+		info.EvalCount = int(count)
+	}
+	if duration, ok := responseData["eval_duration"].(float64); ok {
+		info.EvalDuration = int64(duration)
+	}
 
 	// 根据API路径分别处理
 	switch {
@@ -356,49 +628,112 @@ func parseOllamaResponse(path string, bodyBytes []byte) *LLMResponseInfo {
 }
 
 // LogAdmission 记录准入控制结果
-func (l *ELKLogger) LogAdmission(reqID string, allowed bool, reason string) {
+func (l *MultiLogger) LogAdmission(reqID string, entry AdmissionLog) {
 	// 始终记录到终端
-	if allowed {
+	if entry.Allowed {
 		log.Printf("[准入控制] 请求ID: %s - 允许访问", reqID)
 	} else {
-		log.Printf("[准入控制] 请求ID: %s - 拒绝访问: %s", reqID, reason)
+		log.Printf("[准入控制] 请求ID: %s - 拒绝访问: %s, 类别=%v, 置信度=%.2f",
+			reqID, entry.Reason, entry.Categories, entry.Score)
 	}
 
-	if !l.enabled {
+	if len(l.sinks) == 0 {
 		return
 	}
 
-	// 记录到ELK
-	admLog := AdmissionLog{
-		RequestID: reqID,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Allowed:   allowed,
-		Reason:    reason,
+	admLog := entry
+	admLog.RequestID = reqID
+	admLog.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	admLog.Stage = "input"
+	// 拒绝原因、判断依据和错误信息由审核模型生成或包含其输出，经常引用原文，同样需要处理
+	admLog.Content = l.redactText(admLog.Content)
+	admLog.Reason = l.redactText(admLog.Reason)
+	admLog.Rationale = l.redactText(admLog.Rationale)
+	admLog.RawOutput = l.redactText(admLog.RawOutput)
+	admLog.Error = l.redactText(admLog.Error)
+	// 各阶段和各窗口的结论与调用方共享底层数组，复制后再脱敏，避免改写缓存和复核队列中保存的结论
+	admLog.Checkers = append([]CheckerLog(nil), admLog.Checkers...)
+	admLog.Windows = append([]WindowLog(nil), admLog.Windows...)
+	for i := range admLog.Checkers {
+		admLog.Checkers[i].RawOutput = l.redactText(admLog.Checkers[i].RawOutput)
+		admLog.Checkers[i].Error = l.redactText(admLog.Checkers[i].Error)
+	}
+	for i := range admLog.Windows {
+		admLog.Windows[i].RawOutput = l.redactText(admLog.Windows[i].RawOutput)
+		admLog.Windows[i].Error = l.redactText(admLog.Windows[i].Error)
+	}
+
+	var fields *ecsDoc
+	if l.ecs {
+		fields = ecsAdmission(admLog)
+	}
+	// 拒绝请求时不会再记录响应；影子模式下请求继续处理
+	l.emit(EventAdmission, reqID, !admLog.Allowed, !admLog.Allowed && !admLog.Shadow, admLog, fields)
+}
+
+// LogOutputModeration 记录输出侧准入控制结果
+func (l *MultiLogger) LogOutputModeration(reqID string, entry OutputModerationLog) {
+	// 始终记录到终端
+	if entry.Allowed {
+		log.Printf("[输出审核] 请求ID: %s - 输出合规, 检查字符数=%d", reqID, entry.CheckedChars)
+	} else {
+		log.Printf("[输出审核] 请求ID: %s - 输出违规: %s, 处理方式=%s", reqID, entry.Reason, entry.Action)
 	}
 
-	// 发送到Elasticsearch
-	jsonData, err := json.Marshal(admLog)
-	if err != nil {
-		log.Printf("无法序列化准入控制日志: %v", err)
+	if len(l.sinks) == 0 {
 		return
 	}
 
-	// 使用专门的准入控制索引
-	admissionIndex := l.index + "-admission"
+	entry.RequestID = reqID
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	entry.Stage = "output"
+	entry.Reason = l.redactText(entry.Reason)
+	entry.Error = l.redactText(entry.Error)
 
-	_, err = l.esClient.Index(
-		admissionIndex,
-		strings.NewReader(string(jsonData)),
-		l.esClient.Index.WithContext(context.Background()),
-	)
+	var fields *ecsDoc
+	if l.ecs {
+		fields = ecsOutputModeration(entry)
+	}
+	l.emit(EventOutput, reqID, !entry.Allowed, false, entry, fields)
+}
 
-	if err != nil {
-		log.Printf("无法发送准入控制日志到Elasticsearch: %v", err)
+// AnnotateRequest 把分析结果补充到请求日志，ELK中以部分更新的方式写入请求日志文档
+func (l *MultiLogger) AnnotateRequest(reqID string, ann RequestAnnotation) {
+	if len(ann.Techniques) > 0 {
+		log.Printf("[检测] 请求ID: %s - 攻击手法: %v", reqID, ann.TechniqueIDs)
+	}
+
+	if len(l.sinks) == 0 || reqID == "" {
+		return
 	}
+
+	ann.Techniques = append([]TechniqueLog(nil), ann.Techniques...)
+	for i := range ann.Techniques {
+		ann.Techniques[i].Evidence = l.redactText(ann.Techniques[i].Evidence)
+	}
+	var fields *ecsDoc
+	if l.ecs {
+		fields = ecsAnnotation(ann)
+	}
+	l.emit(EventAnnotation, reqID, false, false, ann, fields)
 }
 
-// Close 关闭日志记录器
-func (l *ELKLogger) Close() error {
-	// Elasticsearch客户端没有明确的关闭方法
+// Close 同时关闭所有写入目标，写入目标之间互不影响，返回遇到的第一个错误
+func (l *MultiLogger) Close() error {
+	errs := make([]error, len(l.sinks))
+	var wg sync.WaitGroup
+	for i, e := range l.sinks {
+		wg.Add(1)
+		go func(i int, e *sinkEntry) {
+			defer wg.Done()
+			errs[i] = e.sink.Close()
+		}(i, e)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
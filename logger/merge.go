@@ -0,0 +1,146 @@
+package logger
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxPendingInteractions 为内存中等待合并的交互数上限，达到后新的交互不再合并，各事件单独写入
+const maxPendingInteractions = 10000
+
+// interactionMerger 在内存中合并同一交互的事件，交互结束后作为一个文档写入。数据流只能追加文档，
+// 不能像普通索引那样以部分更新的方式补充响应和准入结论。
+// 记录响应或拒绝请求后再等待 wait，收集之后到达的输出审核和分析结果；超过 maxAge 的交互即使没有结束也写入。
+// 写入之后才到达的事件作为单独的文档写入，通过 http.request.id 关联
+type interactionMerger struct {
+	wait   time.Duration
+	maxAge time.Duration
+	// write 写入合并后的交互文档，single 写入单独的事件文档
+	write  func(reqID string, doc []byte)
+	single func(ev Event)
+
+	mu      sync.Mutex
+	pending map[string]*interaction
+	full    bool // 已达到上限，用于只在状态变化时输出日志
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// interaction 为一个正在合并的交互
+type interaction struct {
+	doc     map[string]interface{}
+	started time.Time
+	last    time.Time
+	final   bool
+}
+
+func newInteractionMerger(wait, maxAge time.Duration, write func(string, []byte), single func(Event)) *interactionMerger {
+	m := &interactionMerger{
+		wait:    wait,
+		maxAge:  maxAge,
+		write:   write,
+		single:  single,
+		pending: make(map[string]*interaction),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// add 把事件合并到所属的交互，请求事件开始一个新的交互
+func (m *interactionMerger) add(ev Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ev.Type == EventRequest {
+		if len(m.pending) >= maxPendingInteractions {
+			if !m.full {
+				log.Printf("[日志] 等待合并的交互达到上限 %d，新的交互各事件单独写入", maxPendingInteractions)
+				m.full = true
+			}
+			m.single(ev)
+			return
+		}
+		m.full = false
+		var doc map[string]interface{}
+		if err := json.Unmarshal(ev.Doc, &doc); err != nil {
+			log.Printf("[日志] 解析请求日志失败，单独写入: %v", err)
+			m.single(ev)
+			return
+		}
+		m.pending[ev.RequestID] = &interaction{doc: doc, started: ev.Time, last: ev.Time}
+		return
+	}
+
+	it, ok := m.pending[ev.RequestID]
+	if !ok {
+		// 交互已经写入，或请求没有合并
+		m.single(ev)
+		return
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal(ev.Patch, &patch); err != nil {
+		log.Printf("[日志] 解析%s日志失败，单独写入: %v", ev.Type, err)
+		m.single(ev)
+		return
+	}
+	mergeFields(it.doc, patch)
+	it.last = ev.Time
+	it.final = it.final || ev.Final
+}
+
+// mergeFields 把 src 合并到 dst，对象逐层合并，其它值（包括数组）直接覆盖，与Elasticsearch的部分更新一致
+func mergeFields(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sv, ok := v.(map[string]interface{}); ok {
+			if dv, ok := dst[k].(map[string]interface{}); ok {
+				mergeFields(dv, sv)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// run 每秒检查一次，写入已经结束或超时的交互
+func (m *interactionMerger) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.flush(now, false)
+		case <-m.stop:
+			m.flush(time.Now(), true)
+			return
+		}
+	}
+}
+
+// flush 写入到期的交互，all 为真时写入全部交互
+func (m *interactionMerger) flush(now time.Time, all bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for reqID, it := range m.pending {
+		if !all && !(it.final && now.Sub(it.last) >= m.wait) && now.Sub(it.started) < m.maxAge {
+			continue
+		}
+		delete(m.pending, reqID)
+		doc, err := json.Marshal(it.doc)
+		if err != nil {
+			log.Printf("[日志] 序列化交互文档失败: 请求ID=%s, 错误=%v", reqID, err)
+			continue
+		}
+		m.write(reqID, doc)
+	}
+}
+
+// close 写入全部等待中的交互，之后到达的事件单独写入
+func (m *interactionMerger) close() {
+	close(m.stop)
+	<-m.done
+}
@@ -0,0 +1,109 @@
+package logger
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mergeRecorder 记录合并器写入的交互文档和单独写入的事件
+type mergeRecorder struct {
+	docs   map[string]map[string]interface{}
+	single []Event
+}
+
+func newTestMerger(t *testing.T) (*interactionMerger, *mergeRecorder) {
+	t.Helper()
+	rec := &mergeRecorder{docs: make(map[string]map[string]interface{})}
+	// 不启动后台协程，由测试调用 flush
+	m := &interactionMerger{
+		wait:   5 * time.Second,
+		maxAge: time.Minute,
+		write: func(reqID string, doc []byte) {
+			var fields map[string]interface{}
+			if err := json.Unmarshal(doc, &fields); err != nil {
+				t.Fatalf("decode %s: %v", doc, err)
+			}
+			rec.docs[reqID] = fields
+		},
+		single:  func(ev Event) { rec.single = append(rec.single, ev) },
+		pending: make(map[string]*interaction),
+	}
+	return m, rec
+}
+
+func TestInteractionMergerMerge(t *testing.T) {
+	m, rec := newTestMerger(t)
+	t0 := time.Now()
+
+	m.add(Event{Type: EventRequest, RequestID: "r1", Time: t0, Doc: []byte(`{"request":{"path":"/api/chat","headers":{"a":"1"}}}`)})
+	m.add(Event{Type: EventAdmission, RequestID: "r1", Time: t0, Patch: []byte(`{"admission":{"allowed":true,"categories":["x"]}}`)})
+	m.add(Event{Type: EventResponse, RequestID: "r1", Time: t0.Add(time.Second), Final: true,
+		Patch: []byte(`{"request":{"headers":{"b":"2"}},"response":{"status":200}}`)})
+	// 数组整体覆盖，与Elasticsearch的部分更新一致
+	m.add(Event{Type: EventAnnotation, RequestID: "r1", Time: t0.Add(2 * time.Second), Patch: []byte(`{"admission":{"categories":["y"]}}`)})
+
+	// 交互结束后还要等待 wait 收集之后到达的事件
+	m.flush(t0.Add(6*time.Second), false)
+	if len(rec.docs) != 0 {
+		t.Fatalf("flushed before wait: %v", rec.docs)
+	}
+	m.flush(t0.Add(7*time.Second), false)
+
+	want := map[string]interface{}{
+		"request":   map[string]interface{}{"path": "/api/chat", "headers": map[string]interface{}{"a": "1", "b": "2"}},
+		"admission": map[string]interface{}{"allowed": true, "categories": []interface{}{"y"}},
+		"response":  map[string]interface{}{"status": float64(200)},
+	}
+	if got := rec.docs["r1"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged doc = %v, want %v", got, want)
+	}
+
+	// 交互写入之后到达的事件单独写入
+	late := Event{Type: EventAnnotation, RequestID: "r1", Time: t0.Add(8 * time.Second), Patch: []byte(`{}`)}
+	m.add(late)
+	if len(rec.single) != 1 || rec.single[0].Type != EventAnnotation {
+		t.Errorf("single = %+v", rec.single)
+	}
+}
+
+func TestInteractionMergerFlush(t *testing.T) {
+	m, rec := newTestMerger(t)
+	t0 := time.Now()
+
+	// 没有结束的交互在 maxAge 后写入
+	m.add(Event{Type: EventRequest, RequestID: "stalled", Time: t0, Doc: []byte(`{"request":{}}`)})
+	m.add(Event{Type: EventRequest, RequestID: "later", Time: t0.Add(30 * time.Second), Doc: []byte(`{"request":{}}`)})
+	m.flush(t0.Add(59*time.Second), false)
+	if len(rec.docs) != 0 {
+		t.Fatalf("flushed before maxAge: %v", rec.docs)
+	}
+	m.flush(t0.Add(time.Minute), false)
+	if _, ok := rec.docs["stalled"]; !ok || len(rec.docs) != 1 {
+		t.Fatalf("docs after maxAge = %v", rec.docs)
+	}
+
+	// all 为真时写入全部交互
+	m.flush(t0.Add(time.Minute), true)
+	if _, ok := rec.docs["later"]; !ok || len(m.pending) != 0 {
+		t.Errorf("docs = %v, pending = %d", rec.docs, len(m.pending))
+	}
+
+	// 无法解析的请求日志和没有请求日志的事件单独写入
+	m.add(Event{Type: EventRequest, RequestID: "bad", Time: t0, Doc: []byte(`{`)})
+	m.add(Event{Type: EventResponse, RequestID: "unknown", Time: t0, Patch: []byte(`{}`)})
+	if len(rec.single) != 2 || len(m.pending) != 0 {
+		t.Errorf("single = %d, pending = %d", len(rec.single), len(m.pending))
+	}
+}
+
+func TestInteractionMergerClose(t *testing.T) {
+	var written []string
+	m := newInteractionMerger(time.Hour, time.Hour, func(reqID string, doc []byte) { written = append(written, reqID) }, func(Event) {})
+	m.add(Event{Type: EventRequest, RequestID: "r1", Time: time.Now(), Doc: []byte(`{}`)})
+	m.close()
+	if !reflect.DeepEqual(written, []string{"r1"}) {
+		t.Errorf("written on close = %v", written)
+	}
+}
@@ -0,0 +1,218 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// 事件类型
+const (
+	EventRequest    = "request"
+	EventResponse   = "response"
+	EventAdmission  = "admission"
+	EventOutput     = "output" // 输出侧准入控制
+	EventAnnotation = "annotation"
+)
+
+// Event 为一条日志事件，由 MultiLogger 生成后分发到各个写入目标
+type Event struct {
+	Type      string
+	RequestID string
+	Time      time.Time
+	// Denied 表示准入控制或输出审核拒绝，用于 denied_only 过滤
+	Denied bool
+	// Final 表示交互已经结束（已记录响应或请求被拒绝），之后只可能有输出审核和分析结果
+	Final bool
+	// Doc 为JSON格式的日志文档，开启脱敏时已替换敏感数据
+	Doc []byte
+	// Patch 为合并到交互文档（以请求ID为ID的请求日志）的部分内容，请求事件为空
+	Patch []byte
+	// ECS 表示 Doc 为 ECS 格式，已包含 event.action、http.request.id 和 @timestamp
+	ECS bool
+}
+
+// Line 返回单行JSON，用于文件、syslog、webhook 等没有索引区分事件类型的写入目标。
+// 原有格式的日志文档中补充 event、request_id 和 @timestamp 字段，ECS 格式的文档原样返回
+func (ev Event) Line() ([]byte, error) {
+	if ev.ECS {
+		// 返回副本，写入目标会在末尾追加换行，Doc 由多个写入目标共用
+		return append([]byte(nil), ev.Doc...), nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(ev.Doc, &fields); err != nil {
+		return nil, fmt.Errorf("解析日志文档失败: %w", err)
+	}
+	fields["event"], _ = json.Marshal(ev.Type)
+	if _, ok := fields["request_id"]; !ok {
+		fields["request_id"], _ = json.Marshal(ev.RequestID)
+	}
+	if _, ok := fields["@timestamp"]; !ok {
+		fields["@timestamp"], _ = json.Marshal(ev.Time.UTC().Format(time.RFC3339))
+	}
+	return json.Marshal(fields)
+}
+
+// Sink 为日志的写入目标。Write 不能阻塞调用方，写入缓慢或失败时由写入目标自行缓冲或丢弃，
+// 不影响请求的处理和其它写入目标
+type Sink interface {
+	Write(ev Event)
+	Close() error
+}
+
+// sinkEntry 为带过滤条件的写入目标
+type sinkEntry struct {
+	name       string
+	sink       Sink
+	events     map[string]bool // 为空表示全部事件
+	deniedOnly bool
+}
+
+// accepts 判断事件是否需要写入该目标
+func (e *sinkEntry) accepts(ev Event) bool {
+	if e.events != nil && !e.events[ev.Type] {
+		return false
+	}
+	return !e.deniedOnly || ev.Denied
+}
+
+// newSink 按配置创建写入目标
+func newSink(cfg config.SinkConfig) (*sinkEntry, error) {
+	var w eventWriter
+	var err error
+	switch cfg.Type {
+	case "file":
+		w, err = newFileWriter(cfg.File)
+	case "stdout":
+		w = newStdoutWriter()
+	case "syslog":
+		w, err = newSyslogWriter(cfg.Syslog)
+	case "webhook":
+		w = newWebhookWriter(cfg.Webhook)
+	default:
+		err = fmt.Errorf("未知的日志写入目标类型: %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Type
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1000
+	}
+	e := &sinkEntry{name: name, sink: newAsyncSink(name, w, queueSize), deniedOnly: cfg.DeniedOnly}
+	if len(cfg.Events) > 0 {
+		e.events = make(map[string]bool)
+		for _, t := range cfg.Events {
+			e.events[t] = true
+		}
+	}
+	return e, nil
+}
+
+// eventWriter 同步写入一个事件，由 asyncSink 在后台协程中调用
+type eventWriter interface {
+	WriteEvent(ev Event) error
+	Close() error
+}
+
+// asyncSinkCloseTimeout 为关闭时写入队列中剩余事件的最长时间
+const asyncSinkCloseTimeout = 5 * time.Second
+
+// asyncSink 把事件放入有界队列，由后台协程逐个写入。队列已满时丢弃新事件，
+// 写入缓慢或失败的目标不会阻塞请求的处理和其它写入目标
+type asyncSink struct {
+	name  string
+	w     eventWriter
+	queue chan Event
+	done  chan struct{}
+
+	mu     sync.RWMutex // 保护 closed，关闭后不再接收事件
+	closed bool
+
+	dropped atomic.Int64
+	failed  atomic.Int64
+	written atomic.Int64
+}
+
+func newAsyncSink(name string, w eventWriter, queueSize int) *asyncSink {
+	s := &asyncSink{
+		name:  name,
+		w:     w,
+		queue: make(chan Event, queueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write 把事件放入队列，队列已满时丢弃
+func (s *asyncSink) Write(ev Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		s.dropped.Add(1)
+		return
+	}
+	select {
+	case s.queue <- ev:
+	default:
+		if s.dropped.Add(1) == 1 {
+			log.Printf("[日志] 写入目标 %s 的队列已满，开始丢弃事件", s.name)
+		}
+	}
+}
+
+// run 逐个写入事件，只在写入失败和恢复时输出日志，避免目标不可用时刷屏
+func (s *asyncSink) run() {
+	defer close(s.done)
+	broken := false
+	for ev := range s.queue {
+		if err := s.w.WriteEvent(ev); err != nil {
+			s.failed.Add(1)
+			if !broken {
+				log.Printf("[日志] 写入目标 %s 写入失败: %v", s.name, err)
+				broken = true
+			}
+			continue
+		}
+		s.written.Add(1)
+		if broken {
+			log.Printf("[日志] 写入目标 %s 已恢复", s.name)
+			broken = false
+		}
+	}
+}
+
+// Close 写入队列中剩余的事件后关闭，最多等待 asyncSinkCloseTimeout
+func (s *asyncSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(asyncSinkCloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.done:
+	case <-timer.C:
+		return fmt.Errorf("关闭日志写入目标 %s 超时（%s），部分事件未写入", s.name, asyncSinkCloseTimeout)
+	}
+
+	log.Printf("[日志] 写入目标 %s 统计: 已写入=%d, 失败=%d, 丢弃=%d",
+		s.name, s.written.Load(), s.failed.Load(), s.dropped.Load())
+	return s.w.Close()
+}
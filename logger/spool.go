@@ -0,0 +1,236 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// errSpoolFull 表示本地缓冲已达到容量上限
+var errSpoolFull = errors.New("本地缓冲已满")
+
+// spoolRecord 为缓冲文件中的一行，与 bulkOp 一一对应
+type spoolRecord struct {
+	Action string          `json:"action"`
+	Index  string          `json:"index"`
+	ID     string          `json:"id"`
+	Doc    json.RawMessage `json:"doc"`
+}
+
+// spoolSegment 为一个缓冲文件，文件名为递增的序号
+type spoolSegment struct {
+	seq  uint64
+	path string
+	size int64
+}
+
+// spool 在Elasticsearch不可用时把日志按顺序写入本地的分段JSONL文件，恢复后从最早的文件开始重放。
+// 除统计外只由批量写入协程访问
+type spool struct {
+	dir          string
+	segmentBytes int64
+	maxBytes     int64
+
+	mu         sync.Mutex // 保护以下字段
+	segments   []spoolSegment
+	total      int64
+	active     *os.File // 正在追加的文件，总是 segments 中的最后一个
+	activeSize int64
+	next       uint64
+}
+
+// openSpool 打开缓冲目录，上次运行留下的文件会在Elasticsearch可用后重放
+func openSpool(cfg config.SpoolConfig) (*spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("创建本地缓冲目录失败: %w", err)
+	}
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取本地缓冲目录失败: %w", err)
+	}
+
+	s := &spool{
+		dir:          cfg.Dir,
+		segmentBytes: int64(cfg.SegmentBytes),
+		maxBytes:     cfg.MaxBytes,
+		next:         1,
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".jsonl"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("读取本地缓冲文件失败: %w", err)
+		}
+		s.segments = append(s.segments, spoolSegment{seq: seq, path: filepath.Join(cfg.Dir, name), size: info.Size()})
+		s.total += info.Size()
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	if len(s.segments) > 0 {
+		log.Printf("本地缓冲目录 %s 中有 %d 个文件（%d 字节）等待重放", cfg.Dir, len(s.segments), s.total)
+	}
+	return s, nil
+}
+
+// pending 判断是否有等待重放的日志
+func (s *spool) pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) > 0
+}
+
+// stats 返回缓冲文件数和总字节数
+func (s *spool) stats() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments), s.total
+}
+
+// append 把操作追加到当前文件并落盘，当前文件超过分段大小时换新文件
+func (s *spool) append(ops []bulkOp) error {
+	var buf bytes.Buffer
+	for _, op := range ops {
+		line, err := json.Marshal(spoolRecord{Action: op.action, Index: op.index, ID: op.id, Doc: op.body})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.total+int64(buf.Len()) > s.maxBytes {
+		return errSpoolFull
+	}
+	if s.active == nil || s.activeSize >= s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.active.Write(buf.Bytes())
+	s.activeSize += int64(n)
+	s.total += int64(n)
+	s.segments[len(s.segments)-1].size = s.activeSize
+	if err != nil {
+		return fmt.Errorf("写入本地缓冲文件失败: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("同步本地缓冲文件失败: %w", err)
+	}
+	return nil
+}
+
+// rotate 关闭当前文件并创建下一个文件，调用时需持有 mu
+func (s *spool) rotate() error {
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d.jsonl", s.next))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("创建本地缓冲文件失败: %w", err)
+	}
+	// 同步目录，保证新文件在断电后仍然存在
+	if d, err := os.Open(s.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	s.segments = append(s.segments, spoolSegment{seq: s.next, path: path})
+	s.active = f
+	s.activeSize = 0
+	s.next++
+	return nil
+}
+
+// oldest 读取最早的文件。该文件正在写入时先关闭，之后的日志写入新文件
+func (s *spool) oldest() (spoolSegment, []bulkOp, error) {
+	s.mu.Lock()
+	if len(s.segments) == 0 {
+		s.mu.Unlock()
+		return spoolSegment{}, nil, nil
+	}
+	seg := s.segments[0]
+	if len(s.segments) == 1 && s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+	s.mu.Unlock()
+
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return seg, nil, fmt.Errorf("打开本地缓冲文件失败: %w", err)
+	}
+	defer f.Close()
+
+	var ops []bulkOp
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec spoolRecord
+			if jerr := json.Unmarshal(line, &rec); jerr != nil {
+				// 进程异常退出时最后一行可能不完整
+				log.Printf("跳过本地缓冲文件 %s 第%d行损坏的记录: %v", seg.path, lineNo, jerr)
+			} else {
+				ops = append(ops, bulkOp{action: rec.Action, index: rec.Index, id: rec.ID, body: rec.Doc})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return seg, nil, fmt.Errorf("读取本地缓冲文件失败: %w", err)
+		}
+	}
+	return seg, ops, nil
+}
+
+// remove 删除已重放的文件
+func (s *spool) remove(seg spoolSegment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sg := range s.segments {
+		if sg.seq == seg.seq {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			s.total -= sg.size
+			break
+		}
+	}
+	return os.Remove(seg.path)
+}
+
+// close 关闭当前文件，未重放的文件留在目录中，下次启动后重放
+func (s *spool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		s.active.Sync()
+		s.active.Close()
+		s.active = nil
+	}
+}
@@ -0,0 +1,329 @@
+package logger
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+func testOps(prefix string, n int) []bulkOp {
+	ops := make([]bulkOp, n)
+	for i := range ops {
+		id := fmt.Sprintf("%s-%d", prefix, i)
+		ops[i] = bulkOp{action: "index", index: "hp", id: id, body: []byte(`{"id":"` + id + `"}`)}
+	}
+	return ops
+}
+
+func opIDs(ops []bulkOp) []string {
+	ids := make([]string, len(ops))
+	for i, op := range ops {
+		ids[i] = op.id
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestSpool(t *testing.T, dir string, segmentBytes int, maxBytes int64) *spool {
+	t.Helper()
+	sp, err := openSpool(config.SpoolConfig{Enabled: true, Dir: dir, SegmentBytes: segmentBytes, MaxBytes: maxBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sp
+}
+
+// drain 按顺序读取并删除全部缓冲文件
+func drain(t *testing.T, sp *spool) []string {
+	t.Helper()
+	var ids []string
+	for sp.pending() {
+		seg, ops, err := sp.oldest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, opIDs(ops)...)
+		if err := sp.remove(seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ids
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := t.TempDir()
+	sp := newTestSpool(t, dir, 1<<20, 1<<30)
+	first := testOps("a", 3)
+	if err := sp.append(first); err != nil {
+		t.Fatal(err)
+	}
+	sp.close()
+
+	sp = newTestSpool(t, dir, 1<<20, 1<<30)
+	defer sp.close()
+	segments, size := sp.stats()
+	if !sp.pending() || segments != 1 || size == 0 {
+		t.Fatalf("after reopen: pending=%v, segments=%d, bytes=%d", sp.pending(), segments, size)
+	}
+
+	// 重新打开后的日志写入新文件，排在上次留下的日志之后
+	second := testOps("b", 2)
+	if err := sp.append(second); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := sp.stats(); segments != 2 {
+		t.Errorf("segments = %d, want 2", segments)
+	}
+	got := drain(t, sp)
+	want := append(opIDs(first), opIDs(second)...)
+	if !equalIDs(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if segments, size := sp.stats(); segments != 0 || size != 0 {
+		t.Errorf("after drain: segments=%d, bytes=%d", segments, size)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left in the spool directory", len(entries))
+	}
+}
+
+func TestSpoolTornLastLine(t *testing.T) {
+	tests := []struct {
+		name string
+		// cut 为从文件末尾截掉的字节数
+		cut  int
+		want []string
+	}{
+		{name: "intact", cut: 0, want: []string{"a-0", "a-1", "a-2"}},
+		{name: "missing newline", cut: 1, want: []string{"a-0", "a-1", "a-2"}},
+		{name: "torn record", cut: 10, want: []string{"a-0", "a-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sp := newTestSpool(t, dir, 1<<20, 1<<30)
+			if err := sp.append(testOps("a", 3)); err != nil {
+				t.Fatal(err)
+			}
+			sp.close()
+
+			// 模拟写入最后一行时进程退出
+			path := sp.segments[0].path
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, info.Size()-int64(tt.cut)); err != nil {
+				t.Fatal(err)
+			}
+
+			sp = newTestSpool(t, dir, 1<<20, 1<<30)
+			defer sp.close()
+			if got := drain(t, sp); !equalIDs(got, tt.want) {
+				t.Errorf("replayed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	one, _ := json.Marshal(spoolRecord{Action: "index", Index: "hp", ID: "a-0", Doc: []byte(`{"id":"a-0"}`)})
+	lineBytes := int64(len(one) + 1)
+
+	sp := newTestSpool(t, t.TempDir(), 1<<20, 3*lineBytes)
+	defer sp.close()
+	if err := sp.append(testOps("a", 2)); err != nil {
+		t.Fatal(err)
+	}
+	// 超过上限的批次整批丢弃，不写入一部分
+	if err := sp.append(testOps("b", 2)); !errors.Is(err, errSpoolFull) {
+		t.Fatalf("err = %v, want errSpoolFull", err)
+	}
+	if _, size := sp.stats(); size != 2*lineBytes {
+		t.Errorf("bytes = %d, want %d", size, 2*lineBytes)
+	}
+	if err := sp.append(testOps("c", 1)); err != nil {
+		t.Fatalf("append within the limit: %v", err)
+	}
+
+	// 写入协程把缓冲已满的批次计为丢弃
+	w := &bulkWriter{spool: sp}
+	w.spoolOps(testOps("d", 1))
+	if stats := w.Stats(); stats.Dropped != 1 || stats.Spooled != 0 {
+		t.Errorf("stats = %+v, want 1 dropped", stats)
+	}
+
+	// 重放后释放空间
+	drain(t, sp)
+	if err := sp.append(testOps("e", 3)); err != nil {
+		t.Errorf("append after drain: %v", err)
+	}
+}
+
+func TestSpoolSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	// 每次追加后文件都超过分段大小，下一次追加写入新文件
+	sp := newTestSpool(t, dir, 1, 1<<30)
+	defer sp.close()
+
+	var want []string
+	for i := 0; i < 5; i++ {
+		ops := testOps(fmt.Sprintf("s%d", i), 3)
+		if err := sp.append(ops); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, opIDs(ops)...)
+	}
+	if segments, _ := sp.stats(); segments != 5 {
+		t.Fatalf("segments = %d, want 5", segments)
+	}
+
+	// 读取最早的文件时其余文件保持不变
+	seg, ops, err := sp.oldest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalIDs(opIDs(ops), want[:3]) {
+		t.Errorf("oldest = %v, want %v", opIDs(ops), want[:3])
+	}
+	if err := sp.remove(seg); err != nil {
+		t.Fatal(err)
+	}
+	if got := drain(t, sp); !equalIDs(got, want[3:]) {
+		t.Errorf("replayed %v, want %v", got, want[3:])
+	}
+}
+
+// fakeBulkServer 记录 _bulk 请求中的文档ID和操作，前 failures 次请求返回 500（客户端会自行重试 502~504），
+// 之后的前 throttled 次请求的第一个文档返回 429
+type fakeBulkServer struct {
+	mu        sync.Mutex
+	ids       []string
+	actions   []string
+	failures  int
+	throttled int
+}
+
+func (f *fakeBulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path != "/_bulk" {
+		w.Write([]byte(`{"version":{"number":"8.12.0"}}`))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"unavailable"}`))
+		return
+	}
+	var items []string
+	sc := bufio.NewScanner(r.Body)
+	for line := 0; sc.Scan(); line++ {
+		if line%2 == 1 {
+			continue
+		}
+		var meta map[string]struct {
+			ID string `json:"_id"`
+		}
+		json.Unmarshal(sc.Bytes(), &meta)
+		for action, m := range meta {
+			f.ids = append(f.ids, m.ID)
+			f.actions = append(f.actions, action)
+		}
+		if line == 0 && f.throttled > 0 {
+			f.throttled--
+			items = append(items, `{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}`)
+			continue
+		}
+		items = append(items, `{"index":{"status":201}}`)
+	}
+	fmt.Fprintf(w, `{"errors":false,"items":[%s]}`, strings.Join(items, ","))
+}
+
+func (f *fakeBulkServer) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.ids...)
+}
+
+func TestBulkReplayOrder(t *testing.T) {
+	fake := &fakeBulkServer{failures: 1}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sp := newTestSpool(t, t.TempDir(), 1, 1<<30)
+	defer sp.close()
+	var want []string
+	for i := 0; i < 3; i++ {
+		ops := testOps(fmt.Sprintf("s%d", i), 3)
+		if err := sp.append(ops); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, opIDs(ops)...)
+	}
+
+	w := &bulkWriter{
+		client:        client,
+		spool:         sp,
+		batchSize:     2,
+		maxRetries:    0,
+		retryInterval: time.Second,
+		kick:          make(chan struct{}, 1),
+		ctx:           context.Background(),
+	}
+
+	// 缓冲中还有日志时，新的批次追加到缓冲末尾
+	late := testOps("late", 2)
+	w.flush(late)
+	want = append(want, opIDs(late)...)
+
+	// 第一次重放失败，文件保留，之后按顺序重放
+	w.replay()
+	if segments, _ := sp.stats(); segments != 4 {
+		t.Fatalf("segments after failed replay = %d, want 4", segments)
+	}
+	for i := 0; sp.pending() && i < 10; i++ {
+		w.replay()
+	}
+	if sp.pending() {
+		t.Fatal("spool not drained")
+	}
+	if got := fake.received(); !equalIDs(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if stats := w.Stats(); stats.Replayed != int64(len(want)) || stats.Spooled != int64(len(late)) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
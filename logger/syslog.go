@@ -0,0 +1,174 @@
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// syslog 严重级别
+const (
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// syslogWriter 按 RFC 5424 格式发送事件，消息正文为单行JSON。UDP 每个事件一个数据报，超过 maxBytes 时截断；
+// TCP 和 TLS 按 RFC 6587 的长度前缀分帧，连接断开时自动重连
+type syslogWriter struct {
+	network   string // udp、tcp、tls
+	addr      string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	facility  int
+	appName   string
+	hostname  string
+	maxBytes  int // UDP 数据报的最大字节数
+	conn      net.Conn
+	truncated int64 // 截断的消息数，用于只在第一次截断时输出日志
+}
+
+func newSyslogWriter(cfg config.SyslogSinkConfig) (*syslogWriter, error) {
+	w := &syslogWriter{
+		network:  cfg.Network,
+		addr:     cfg.Addr,
+		timeout:  time.Duration(cfg.TimeoutSeconds) * time.Second,
+		facility: 16, // local0
+		appName:  cfg.AppName,
+		maxBytes: cfg.MaxMessageBytes,
+	}
+	if cfg.Facility != nil {
+		w.facility = *cfg.Facility
+	}
+	if w.timeout <= 0 {
+		w.timeout = 5 * time.Second
+	}
+	if w.maxBytes <= 0 {
+		w.maxBytes = 2048
+	}
+	if w.appName == "" {
+		w.appName = "ollama-proxy"
+	}
+	if w.hostname, _ = os.Hostname(); w.hostname == "" {
+		w.hostname = "-"
+	}
+
+	if w.network == "tls" {
+		host, _, err := net.SplitHostPort(w.addr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的syslog地址: %w", err)
+		}
+		w.tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: cfg.InsecureSkipVerify}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("读取syslog CA证书失败: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("syslog CA证书 %s 中没有有效的证书", cfg.CAFile)
+			}
+			w.tlsConfig.RootCAs = pool
+		}
+	}
+	// 启动时不连接，syslog服务不可用不影响启动
+	return w, nil
+}
+
+func (w *syslogWriter) WriteEvent(ev Event) error {
+	line, err := ev.Line()
+	if err != nil {
+		return err
+	}
+	msg := w.format(ev, line)
+
+	if err := w.send(msg); err != nil {
+		// 连接可能已经被服务端关闭，重新连接后再试一次
+		w.closeConn()
+		if err := w.send(msg); err != nil {
+			w.closeConn()
+			return err
+		}
+	}
+	return nil
+}
+
+// format 生成 RFC 5424 消息：<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG，
+// MSGID 为事件类型，拒绝的准入和输出审核事件使用 warning 级别
+func (w *syslogWriter) format(ev Event, line []byte) []byte {
+	severity := severityInfo
+	if ev.Denied {
+		severity = severityWarning
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		w.facility*8+severity,
+		ev.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, os.Getpid(), ev.Type)
+	return append([]byte(header), line...)
+}
+
+func (w *syslogWriter) send(msg []byte) error {
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return err
+		}
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	if w.network == "udp" {
+		_, err := w.conn.Write(w.truncate(msg))
+		return err
+	}
+	_, err := w.conn.Write(append([]byte(fmt.Sprintf("%d ", len(msg))), msg...))
+	return err
+}
+
+// truncate 把超过 maxBytes 的 UDP 消息截断到完整的 UTF-8 字符处，过大的数据报会被分片或被接收方丢弃。
+// 截断后的消息正文不再是完整的JSON，需要完整事件时应使用 TCP 或 TLS
+func (w *syslogWriter) truncate(msg []byte) []byte {
+	if len(msg) <= w.maxBytes {
+		return msg
+	}
+	if w.truncated == 0 {
+		log.Printf("[日志] syslog 消息超过 %d 字节，UDP 传输时截断（%d 字节），需要完整事件时请使用 tcp 或 tls", w.maxBytes, len(msg))
+	}
+	w.truncated++
+	end := w.maxBytes
+	for end > 0 && !utf8.RuneStart(msg[end]) {
+		end--
+	}
+	return msg[:end]
+}
+
+func (w *syslogWriter) connect() error {
+	dialer := &net.Dialer{Timeout: w.timeout}
+	var conn net.Conn
+	var err error
+	if w.network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.addr, w.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(w.network, w.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("连接syslog服务 %s 失败: %w", w.addr, err)
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *syslogWriter) closeConn() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+func (w *syslogWriter) Close() error {
+	w.closeConn()
+	return nil
+}
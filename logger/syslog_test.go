@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+func TestSyslogFacility(t *testing.T) {
+	kern, local7 := 0, 23
+	tests := []struct {
+		name     string
+		facility *int
+		want     string // PRI，info 级别
+	}{
+		{name: "unset", facility: nil, want: "<134>"},
+		{name: "kern", facility: &kern, want: "<6>"},
+		{name: "local7", facility: &local7, want: "<190>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := newSyslogWriter(config.SyslogSinkConfig{Network: "udp", Addr: "127.0.0.1:514", Facility: tt.facility})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := w.format(Event{Type: EventRequest, Time: time.Now()}, []byte(`{}`))
+			if !bytes.HasPrefix(msg, []byte(tt.want)) {
+				t.Errorf("message %q does not start with %s", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyslogUDPTruncation(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name     string
+		maxBytes int
+		body     string
+		want     int // 收到的数据报字节数上限
+	}{
+		{name: "default limit", body: strings.Repeat("a", 5000), want: 2048},
+		{name: "configured limit", maxBytes: 8192, body: strings.Repeat("a", 10000), want: 8192},
+		{name: "small message", body: "hello", want: 2048},
+		{name: "utf-8 boundary", maxBytes: 1000, body: strings.Repeat("蜜罐", 1000), want: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := newSyslogWriter(config.SyslogSinkConfig{Network: "udp", Addr: conn.LocalAddr().String(), MaxMessageBytes: tt.maxBytes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			line := []byte(fmt.Sprintf(`{"body":%q}`, tt.body))
+			if err := w.send(w.format(Event{Type: EventRequest, Time: time.Now()}, line)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 65536)
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n > tt.want {
+				t.Errorf("datagram is %d bytes, want at most %d", n, tt.want)
+			}
+			if !utf8.Valid(buf[:n]) {
+				t.Error("truncated datagram is not valid UTF-8")
+			}
+			if len(line) < tt.want-200 && !bytes.HasSuffix(buf[:n], line) {
+				t.Error("small message was modified")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// webhookWriter 把每个事件以JSON请求体POST到指定地址，连接失败、429 和 5xx 时重试
+type webhookWriter struct {
+	url        string
+	headers    map[string]string
+	client     *http.Client
+	maxRetries int
+}
+
+func newWebhookWriter(cfg config.WebhookSinkConfig) *webhookWriter {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 2
+	}
+	return &webhookWriter{
+		url:        cfg.URL,
+		headers:    cfg.Headers,
+		client:     &http.Client{Timeout: timeout},
+		maxRetries: maxRetries,
+	}
+}
+
+func (w *webhookWriter) WriteEvent(ev Event) error {
+	line, err := ev.Line()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<(attempt-1)) * 500 * time.Millisecond)
+		}
+		retry, err := w.post(line)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.maxRetries {
+			return err
+		}
+	}
+}
+
+// post 发送一次请求，返回是否需要重试
+func (w *webhookWriter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("发送webhook请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook返回错误状态码: %d", resp.StatusCode)
+}
+
+func (w *webhookWriter) Close() error {
+	return nil
+}
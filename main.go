@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -14,6 +13,18 @@ import (
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "knn-build":
+			runKNNBuild(os.Args[2:])
+			return
+		case "eval":
+			runEval(os.Args[2:])
+			return
+		}
+	}
+
 	// 命令行参数
 	listenAddr := flag.String("listen", "", "代理服务器监听地址")
 	targetAddr := flag.String("target", "", "Ollama服务地址")
@@ -44,10 +55,9 @@ func main() {
 	}
 
 	// 初始化日志模块
-	loggerInstance, err := logger.NewELKLogger(cfg.ELK)
+	loggerInstance, err := logger.NewMultiLogger(cfg.ELK, cfg.Sinks, cfg.DLP, cfg.LogSchema)
 	if err != nil {
-		log.Printf("警告: 无法初始化ELK日志: %v", err)
-		log.Println("继续运行，但不会记录到ELK")
+		log.Fatalf("无法初始化日志: %v", err)
 	}
 	defer loggerInstance.Close()
 
@@ -57,6 +67,10 @@ func main() {
 		cfg.TargetAddr,
 		loggerInstance,
 		cfg.Admission,
+		cfg.Standalone,
+		cfg.Detection,
+		cfg.DLP,
+		cfg.Review,
 	)
 	if err != nil {
 		log.Fatalf("无法创建代理服务器: %v", err)
@@ -68,12 +82,18 @@ func main() {
 	go func() {
 		<-c
 		log.Println("正在关闭服务器...")
-		loggerInstance.Close()
+		if err := loggerInstance.Close(); err != nil {
+			log.Printf("警告: %v", err)
+		}
 		os.Exit(0)
 	}()
 
 	// 启动服务器
-	fmt.Printf("启动Ollama代理服务器，监听于%s，转发至%s\n", cfg.ListenAddr, cfg.TargetAddr)
+	if cfg.Standalone.Enabled {
+		log.Printf("启动Ollama蜜罐服务器（无后端模拟模式），监听于%s", cfg.ListenAddr)
+	} else {
+		log.Printf("启动Ollama代理服务器，监听于%s，转发至%s", cfg.ListenAddr, cfg.TargetAddr)
+	}
 	if err := proxyServer.Start(); err != nil {
 		log.Fatalf("服务器启动失败: %v", err)
 	}
@@ -0,0 +1,571 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// ollamaEmulator 在无后端模式下模拟Ollama API
+type ollamaEmulator struct {
+	cfg       config.StandaloneConfig
+	models    map[string]config.FakeModel
+	responses []fakeResponse
+	timing    *admission.TimingProfile // 模拟出的耗时同样用于生成拒绝响应
+
+	mu       sync.Mutex
+	lastUsed map[string]time.Time // 模型最近一次被调用的时间，用于模拟 /api/ps
+}
+
+// fakeResponse 是编译后的预设回复
+type fakeResponse struct {
+	match    *regexp.Regexp
+	model    string
+	template *template.Template
+}
+
+// templateData 是预设回复模板可用的数据
+type templateData struct {
+	Prompt string
+	System string
+	Model  string
+	Time   string
+}
+
+// newOllamaEmulator 创建模拟器，预设回复中的正则或模板有误时返回错误
+func newOllamaEmulator(cfg config.StandaloneConfig, timing *admission.TimingProfile) (*ollamaEmulator, error) {
+	if len(cfg.Models) == 0 {
+		return nil, fmt.Errorf("模拟模式至少需要配置一个模型")
+	}
+	if cfg.TokensPerSecond <= 0 {
+		cfg.TokensPerSecond = 38
+	}
+	if cfg.PromptTokensPerSecond <= 0 {
+		cfg.PromptTokensPerSecond = 600
+	}
+	if cfg.KeepAliveSeconds <= 0 {
+		cfg.KeepAliveSeconds = 300
+	}
+
+	em := &ollamaEmulator{
+		cfg:      cfg,
+		timing:   timing,
+		models:   make(map[string]config.FakeModel),
+		lastUsed: make(map[string]time.Time),
+	}
+
+	for _, m := range cfg.Models {
+		em.models[m.Name] = m
+		// Ollama 允许省略 :latest 标签
+		if !strings.Contains(m.Name, ":") {
+			em.models[m.Name+":latest"] = m
+		}
+	}
+
+	for i, r := range cfg.Responses {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("预设回复 #%d 的正则表达式无效: %w", i, err)
+		}
+		tmpl, err := template.New(fmt.Sprintf("response-%d", i)).Parse(r.Response)
+		if err != nil {
+			return nil, fmt.Errorf("预设回复 #%d 的模板无效: %w", i, err)
+		}
+		em.responses = append(em.responses, fakeResponse{match: re, model: r.Model, template: tmpl})
+	}
+
+	defaultTmpl, err := template.New("default").Parse(cfg.DefaultResponse)
+	if err != nil {
+		return nil, fmt.Errorf("默认回复模板无效: %w", err)
+	}
+	em.responses = append(em.responses, fakeResponse{template: defaultTmpl})
+
+	log.Printf("[模拟] 初始化Ollama模拟器: 版本=%s, 模型数=%d, 预设回复数=%d",
+		cfg.Version, len(cfg.Models), len(cfg.Responses))
+
+	return em, nil
+}
+
+// RoundTrip 让模拟器作为反向代理的Transport，在进程内处理转发的请求
+func (em *ollamaEmulator) RoundTrip(req *http.Request) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	w := &pipeResponseWriter{
+		header: make(http.Header),
+		status: http.StatusOK,
+		pw:     pw,
+		ready:  make(chan struct{}),
+	}
+
+	go func() {
+		em.ServeHTTP(w, req)
+		w.WriteHeader(http.StatusOK)
+		pw.Close()
+	}()
+
+	// 等待响应头写出后再返回，流式响应的正文通过管道边生成边读取
+	select {
+	case <-w.ready:
+	case <-req.Context().Done():
+		pr.Close()
+		return nil, req.Context().Err()
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header.Clone(),
+		Body:          pr,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// pipeResponseWriter 将模拟器写出的响应通过管道交给 RoundTrip 的调用方
+type pipeResponseWriter struct {
+	header http.Header
+	status int
+	pw     *io.PipeWriter
+	once   sync.Once
+	ready  chan struct{}
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		close(w.ready)
+	})
+}
+
+func (w *pipeResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(p)
+}
+
+// Flush 管道没有缓冲，写出即送达
+func (w *pipeResponseWriter) Flush() {}
+
+// ServeHTTP 按照Ollama的路由分发请求
+func (em *ollamaEmulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Ollama is running"))
+	case r.URL.Path == "/api/version" && r.Method == http.MethodGet:
+		em.writeJSON(w, http.StatusOK, map[string]string{"version": em.cfg.Version})
+	case r.URL.Path == "/api/tags" && r.Method == http.MethodGet:
+		em.handleTags(w)
+	case r.URL.Path == "/api/ps" && r.Method == http.MethodGet:
+		em.handlePs(w)
+	case r.URL.Path == "/api/show" && r.Method == http.MethodPost:
+		em.handleShow(w, r)
+	case r.URL.Path == "/api/generate" && r.Method == http.MethodPost:
+		em.handleGenerate(w, r)
+	case r.URL.Path == "/api/chat" && r.Method == http.MethodPost:
+		em.handleChat(w, r)
+	default:
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 page not found"))
+	}
+}
+
+// 以下结构体保持与Ollama一致的JSON字段顺序
+
+type modelDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+type listModel struct {
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt string       `json:"modified_at,omitempty"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Details    modelDetails `json:"details"`
+	ExpiresAt  string       `json:"expires_at,omitempty"`
+	SizeVRAM   int64        `json:"size_vram,omitempty"`
+}
+
+type generateChunk struct {
+	Model      string `json:"model"`
+	CreatedAt  string `json:"created_at"`
+	Response   string `json:"response"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+	Context    []int  `json:"context,omitempty"`
+	admission.Metrics
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatChunk struct {
+	Model      string      `json:"model"`
+	CreatedAt  string      `json:"created_at"`
+	Message    chatMessage `json:"message"`
+	DoneReason string      `json:"done_reason,omitempty"`
+	Done       bool        `json:"done"`
+	admission.Metrics
+}
+
+func newModelDetails(m config.FakeModel) modelDetails {
+	return modelDetails{
+		Format:            "gguf",
+		Family:            m.Family,
+		Families:          []string{m.Family},
+		ParameterSize:     m.ParameterSize,
+		QuantizationLevel: m.QuantizationLevel,
+	}
+}
+
+func (em *ollamaEmulator) handleTags(w http.ResponseWriter) {
+	models := make([]listModel, 0, len(em.cfg.Models))
+	for _, m := range em.cfg.Models {
+		models = append(models, listModel{
+			Name:       m.Name,
+			Model:      m.Name,
+			ModifiedAt: m.ModifiedAt,
+			Size:       m.Size,
+			Digest:     m.Digest,
+			Details:    newModelDetails(m),
+		})
+	}
+	em.writeJSON(w, http.StatusOK, map[string]interface{}{"models": models})
+}
+
+func (em *ollamaEmulator) handlePs(w http.ResponseWriter) {
+	keepAlive := time.Duration(em.cfg.KeepAliveSeconds) * time.Second
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	models := make([]listModel, 0)
+	for _, m := range em.cfg.Models {
+		used, ok := em.lastUsed[m.Name]
+		if !ok || time.Since(used) > keepAlive {
+			continue
+		}
+		// 加载到显存后的占用比磁盘文件略大
+		models = append(models, listModel{
+			Name:      m.Name,
+			Model:     m.Name,
+			Size:      m.Size + m.Size/8,
+			Digest:    m.Digest,
+			Details:   newModelDetails(m),
+			ExpiresAt: used.Add(keepAlive).Format(time.RFC3339Nano),
+			SizeVRAM:  m.Size + m.Size/8,
+		})
+	}
+	em.writeJSON(w, http.StatusOK, map[string]interface{}{"models": models})
+}
+
+func (em *ollamaEmulator) handleShow(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		em.writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if req.Model == "" {
+		req.Model = req.Name
+	}
+
+	m, ok := em.lookupModel(req.Model)
+	if !ok {
+		em.writeError(w, http.StatusNotFound, fmt.Sprintf("model '%s' not found", req.Model))
+		return
+	}
+
+	em.writeJSON(w, http.StatusOK, struct {
+		License      string                 `json:"license"`
+		Modelfile    string                 `json:"modelfile"`
+		Parameters   string                 `json:"parameters"`
+		Template     string                 `json:"template"`
+		Details      modelDetails           `json:"details"`
+		ModelInfo    map[string]interface{} `json:"model_info"`
+		Capabilities []string               `json:"capabilities"`
+		ModifiedAt   string                 `json:"modified_at"`
+	}{
+		Modelfile:  fmt.Sprintf("# Modelfile generated by \"ollama show\"\n# To build a new Modelfile based on this, replace FROM with:\n# FROM %s\n\nFROM /usr/share/ollama/.ollama/models/blobs/sha256-%s\n", m.Name, m.Digest),
+		Parameters: "stop                           \"<|start_header_id|>\"\nstop                           \"<|end_header_id|>\"\nstop                           \"<|eot_id|>\"",
+		Template:   "{{ if .System }}<|start_header_id|>system<|end_header_id|>\n\n{{ .System }}<|eot_id|>{{ end }}{{ if .Prompt }}<|start_header_id|>user<|end_header_id|>\n\n{{ .Prompt }}<|eot_id|>{{ end }}<|start_header_id|>assistant<|end_header_id|>\n\n{{ .Response }}<|eot_id|>",
+		Details:    newModelDetails(m),
+		ModelInfo: map[string]interface{}{
+			"general.architecture":             m.Family,
+			"general.parameter_count":          parameterCount(m.ParameterSize),
+			"general.quantization_version":     2,
+			m.Family + ".context_length":       m.ContextLength,
+			m.Family + ".attention.head_count": 32,
+		},
+		Capabilities: []string{"completion"},
+		ModifiedAt:   m.ModifiedAt,
+	})
+}
+
+// parameterCount 将 "8.0B" 形式的参数规模转换为参数数量
+func parameterCount(size string) int64 {
+	var n float64
+	if _, err := fmt.Sscanf(strings.TrimSuffix(size, "B"), "%g", &n); err != nil {
+		return 0
+	}
+	return int64(n * 1e9)
+}
+
+// generation 描述一次模拟生成所需的信息
+type generation struct {
+	model  string
+	prompt string
+	system string
+	stream bool
+}
+
+func (em *ollamaEmulator) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+		System string `json:"system"`
+		Stream *bool  `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		em.writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	gen := generation{
+		model:  req.Model,
+		prompt: req.Prompt,
+		system: req.System,
+		stream: req.Stream == nil || *req.Stream, // Ollama默认使用流式输出
+	}
+
+	em.generate(r.Context(), w, gen, func(text string, done bool, metrics *admission.Metrics) interface{} {
+		chunk := generateChunk{
+			Model:     gen.model,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+			Response:  text,
+			Done:      done,
+		}
+		if metrics != nil {
+			chunk.DoneReason = "stop"
+			chunk.Context = fakeContext(metrics.PromptEvalCount + metrics.EvalCount)
+			chunk.Metrics = *metrics
+		}
+		return chunk
+	})
+}
+
+func (em *ollamaEmulator) handleChat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+		Stream *bool `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		em.writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+
+	gen := generation{
+		model:  req.Model,
+		stream: req.Stream == nil || *req.Stream,
+	}
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system":
+			gen.system = msg.Content
+		case "user":
+			gen.prompt = msg.Content
+		}
+	}
+
+	em.generate(r.Context(), w, gen, func(text string, done bool, metrics *admission.Metrics) interface{} {
+		chunk := chatChunk{
+			Model:     gen.model,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+			Message:   chatMessage{Role: "assistant", Content: text},
+			Done:      done,
+		}
+		if metrics != nil {
+			chunk.DoneReason = "stop"
+			chunk.Metrics = *metrics
+		}
+		return chunk
+	})
+}
+
+// generate 生成回复并按Ollama格式输出，newChunk 负责构造对应接口的响应片段，
+// 最后一个片段会带上统计信息
+func (em *ollamaEmulator) generate(ctx context.Context, w http.ResponseWriter, gen generation,
+	newChunk func(text string, done bool, metrics *admission.Metrics) interface{}) {
+	if gen.model == "" {
+		em.writeError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+	m, ok := em.lookupModel(gen.model)
+	if !ok {
+		em.writeError(w, http.StatusNotFound, fmt.Sprintf("model \"%s\" not found, try pulling it first", gen.model))
+		return
+	}
+
+	em.mu.Lock()
+	_, warm := em.lastUsed[m.Name]
+	em.lastUsed[m.Name] = time.Now()
+	em.mu.Unlock()
+
+	text := em.render(gen)
+	tokens := admission.SplitTokens(text)
+	promptTokens := admission.CountTokens(gen.system+gen.prompt) + 10
+
+	// 模拟加载、提示词处理和逐token生成的耗时
+	loadDuration := time.Duration(em.cfg.LoadDurationMs)*time.Millisecond + jitter(5*time.Millisecond)
+	if !warm {
+		loadDuration += 1500*time.Millisecond + jitter(800*time.Millisecond)
+	}
+	promptEvalDuration := time.Duration(float64(promptTokens)/em.cfg.PromptTokensPerSecond*float64(time.Second)) + jitter(3*time.Millisecond)
+	tokenInterval := time.Duration(float64(time.Second) / em.cfg.TokensPerSecond)
+
+	start := time.Now()
+	if !admission.SleepContext(ctx, loadDuration+promptEvalDuration) {
+		return
+	}
+
+	final := func(content string) interface{} {
+		totalDuration := time.Since(start)
+		metrics := &admission.Metrics{
+			TotalDuration:      totalDuration.Nanoseconds(),
+			LoadDuration:       loadDuration.Nanoseconds(),
+			PromptEvalCount:    promptTokens,
+			PromptEvalDuration: promptEvalDuration.Nanoseconds(),
+			EvalCount:          len(tokens),
+			EvalDuration:       (totalDuration - loadDuration - promptEvalDuration).Nanoseconds(),
+		}
+		chunk := newChunk(content, true, metrics)
+		if data, err := json.Marshal(chunk); err == nil {
+			em.timing.ObserveResponse(data)
+		}
+		return chunk
+	}
+
+	if !gen.stream {
+		if !admission.SleepContext(ctx, time.Duration(len(tokens))*tokenInterval) {
+			return
+		}
+		em.writeJSON(w, http.StatusOK, final(text))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	for _, tok := range tokens {
+		if !admission.SleepJitter(ctx, tokenInterval) {
+			return
+		}
+		if err := enc.Encode(newChunk(tok, false, nil)); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+
+	enc.Encode(final(""))
+	rc.Flush()
+}
+
+// render 选择第一个匹配的预设回复并渲染模板
+func (em *ollamaEmulator) render(gen generation) string {
+	data := templateData{
+		Prompt: gen.prompt,
+		System: gen.system,
+		Model:  gen.model,
+		Time:   time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	for _, r := range em.responses {
+		if r.model != "" && r.model != gen.model {
+			continue
+		}
+		if r.match != nil && !r.match.MatchString(gen.prompt) {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := r.template.Execute(&buf, data); err != nil {
+			log.Printf("[模拟] 渲染预设回复失败: %v", err)
+			continue
+		}
+		return buf.String()
+	}
+
+	return ""
+}
+
+// lookupModel 按名称查找模拟模型
+func (em *ollamaEmulator) lookupModel(name string) (config.FakeModel, bool) {
+	m, ok := em.models[name]
+	if !ok && !strings.Contains(name, ":") {
+		m, ok = em.models[name+":latest"]
+	}
+	return m, ok
+}
+
+func (em *ollamaEmulator) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (em *ollamaEmulator) writeError(w http.ResponseWriter, status int, msg string) {
+	em.writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// fakeContext 生成看起来合理的上下文token序列
+func fakeContext(n int) []int {
+	ctx := make([]int, n)
+	for i := range ctx {
+		ctx[i] = 100 + rand.Intn(128000)
+	}
+	return ctx
+}
+
+// jitter 返回 [0, max) 范围内的随机时长
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
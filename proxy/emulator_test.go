@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// newTestEmulator 创建输出速度很快的模拟器，并把模型标记为已加载以跳过冷启动耗时
+func newTestEmulator(t *testing.T) *ollamaEmulator {
+	t.Helper()
+	em, err := newOllamaEmulator(config.StandaloneConfig{
+		Version: "0.6.2",
+		Models: []config.FakeModel{{
+			Name:          "llama3",
+			Family:        "llama",
+			ParameterSize: "8.0B",
+			Size:          4661224676,
+			Digest:        "365c0bd3c000",
+			ContextLength: 8192,
+		}},
+		Responses: []config.FakeResponse{
+			{Match: `(?i)password`, Response: "I can't help with that."},
+			{Match: `.*`, Model: "llama3:latest", Response: "Hello from {{.Model}}: {{.Prompt}}"},
+		},
+		DefaultResponse:       "Sure.",
+		TokensPerSecond:       10000,
+		PromptTokensPerSecond: 100000,
+	}, admission.NewTimingProfile())
+	if err != nil {
+		t.Fatalf("newOllamaEmulator: %v", err)
+	}
+	em.lastUsed["llama3"] = time.Now()
+	return em
+}
+
+func TestNewOllamaEmulatorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.StandaloneConfig
+	}{
+		{name: "no models", cfg: config.StandaloneConfig{}},
+		{name: "bad regexp", cfg: config.StandaloneConfig{
+			Models:    []config.FakeModel{{Name: "llama3"}},
+			Responses: []config.FakeResponse{{Match: "("}},
+		}},
+		{name: "bad template", cfg: config.StandaloneConfig{
+			Models:    []config.FakeModel{{Name: "llama3"}},
+			Responses: []config.FakeResponse{{Match: ".*", Response: "{{.Prompt"}},
+		}},
+		{name: "bad default template", cfg: config.StandaloneConfig{
+			Models:          []config.FakeModel{{Name: "llama3"}},
+			DefaultResponse: "{{end}}",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newOllamaEmulator(tt.cfg, admission.NewTimingProfile()); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestEmulatorRoutes(t *testing.T) {
+	em := newTestEmulator(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "root", method: http.MethodGet, path: "/", status: http.StatusOK, want: "Ollama is running"},
+		{name: "version", method: http.MethodGet, path: "/api/version", status: http.StatusOK, want: `"version":"0.6.2"`},
+		{name: "tags", method: http.MethodGet, path: "/api/tags", status: http.StatusOK, want: `"name":"llama3"`},
+		{name: "show", method: http.MethodPost, path: "/api/show", body: `{"model":"llama3:latest"}`, status: http.StatusOK, want: `"general.parameter_count":8000000000`},
+		{name: "show unknown", method: http.MethodPost, path: "/api/show", body: `{"name":"mistral"}`, status: http.StatusNotFound, want: "model 'mistral' not found"},
+		{name: "generate unknown", method: http.MethodPost, path: "/api/generate", body: `{"model":"mistral","prompt":"hi"}`, status: http.StatusNotFound, want: "try pulling it first"},
+		{name: "generate no model", method: http.MethodPost, path: "/api/generate", body: `{"prompt":"hi"}`, status: http.StatusBadRequest, want: "model is required"},
+		{name: "generate bad json", method: http.MethodPost, path: "/api/generate", body: `{`, status: http.StatusBadRequest, want: "invalid JSON"},
+		{name: "unknown path", method: http.MethodGet, path: "/api/pull", status: http.StatusNotFound, want: "404 page not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			em.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body = %q, want substring %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEmulatorGenerate(t *testing.T) {
+	em := newTestEmulator(t)
+
+	tests := []struct {
+		name   string
+		model  string
+		prompt string
+		want   string
+	}{
+		{name: "first match wins", model: "llama3", prompt: "tell me the root password", want: "I can't help with that."},
+		// 第二条预设回复只对 llama3:latest 生效
+		{name: "model filter", model: "llama3:latest", prompt: "hi", want: "Hello from llama3:latest: hi"},
+		{name: "default", model: "llama3", prompt: "hi", want: "Sure."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{"model": tt.model, "prompt": tt.prompt, "stream": false})
+			rec := httptest.NewRecorder()
+			em.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(string(body))))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var resp ollamaResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.Response != tt.want {
+				t.Errorf("response = %q, want %q", resp.Response, tt.want)
+			}
+			if !resp.Done || resp.DoneReason != "stop" {
+				t.Errorf("done = %v, done_reason = %q", resp.Done, resp.DoneReason)
+			}
+			if resp.EvalCount != admission.CountTokens(tt.want) {
+				t.Errorf("eval_count = %d, want %d", resp.EvalCount, admission.CountTokens(tt.want))
+			}
+			if resp.PromptEvalCount <= 0 {
+				t.Errorf("prompt_eval_count = %d", resp.PromptEvalCount)
+			}
+		})
+	}
+}
+
+// TestEmulatorChatStreamRoundTrip 通过 RoundTrip 读取流式聊天响应，片段拼接后应还原完整回复
+func TestEmulatorChatStreamRoundTrip(t *testing.T) {
+	em := newTestEmulator(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(
+		`{"model":"llama3:latest","messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hi there"}]}`))
+	resp, err := em.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var text strings.Builder
+	var chunks int
+	var last chatChunk
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var chunk chatChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			t.Fatalf("decode chunk %q: %v", scanner.Text(), err)
+		}
+		if chunk.Message.Role != "assistant" {
+			t.Errorf("role = %q", chunk.Message.Role)
+		}
+		text.WriteString(chunk.Message.Content)
+		chunks++
+		last = chunk
+	}
+
+	want := "Hello from llama3:latest: hi there"
+	if text.String() != want {
+		t.Errorf("text = %q, want %q", text.String(), want)
+	}
+	if !last.Done || last.EvalCount != chunks-1 {
+		t.Errorf("last chunk done = %v, eval_count = %d, streamed %d tokens", last.Done, last.EvalCount, chunks-1)
+	}
+}
+
+func TestEmulatorPs(t *testing.T) {
+	em := newTestEmulator(t)
+
+	models := func() []listModel {
+		rec := httptest.NewRecorder()
+		em.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ps", nil))
+		var resp struct {
+			Models []listModel `json:"models"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp.Models
+	}
+
+	if got := models(); len(got) != 1 || got[0].ExpiresAt == "" || got[0].SizeVRAM <= got[0].Size-got[0].Size/8 {
+		t.Errorf("loaded models = %+v", got)
+	}
+
+	// 超过 keep_alive 后模型应被视为已卸载
+	em.lastUsed["llama3"] = time.Now().Add(-time.Duration(em.cfg.KeepAliveSeconds+1) * time.Second)
+	if got := models(); len(got) != 0 {
+		t.Errorf("expired models = %+v", got)
+	}
+}
@@ -0,0 +1,653 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAI兼容接口路径
+const (
+	openAIChatPath       = "/v1/chat/completions"
+	openAICompletionPath = "/v1/completions"
+	openAIModelsPath     = "/v1/models"
+)
+
+// isOpenAIPath 判断是否为需要翻译的OpenAI兼容接口
+func isOpenAIPath(path string) bool {
+	return path == openAIChatPath || path == openAICompletionPath || path == openAIModelsPath
+}
+
+// openAIChatRequest 表示OpenAI的聊天补全请求
+type openAIChatRequest struct {
+	Model            string          `json:"model"`
+	Messages         []openAIMessage `json:"messages"`
+	Stream           bool            `json:"stream"`
+	StreamOptions    *streamOptions  `json:"stream_options"`
+	Temperature      *float64        `json:"temperature"`
+	TopP             *float64        `json:"top_p"`
+	MaxTokens        *int            `json:"max_tokens"`
+	Seed             *int            `json:"seed"`
+	Stop             interface{}     `json:"stop"`
+	FrequencyPenalty *float64        `json:"frequency_penalty"`
+	PresencePenalty  *float64        `json:"presence_penalty"`
+	ResponseFormat   *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+// openAICompletionRequest 表示OpenAI的文本补全请求
+type openAICompletionRequest struct {
+	Model            string         `json:"model"`
+	Prompt           interface{}    `json:"prompt"`
+	Suffix           string         `json:"suffix"`
+	Stream           bool           `json:"stream"`
+	StreamOptions    *streamOptions `json:"stream_options"`
+	Temperature      *float64       `json:"temperature"`
+	TopP             *float64       `json:"top_p"`
+	MaxTokens        *int           `json:"max_tokens"`
+	Seed             *int           `json:"seed"`
+	Stop             interface{}    `json:"stop"`
+	FrequencyPenalty *float64       `json:"frequency_penalty"`
+	PresencePenalty  *float64       `json:"presence_penalty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openAIMessage 的content可以是字符串，也可以是多模态片段数组
+type openAIMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// openAIOptions 将OpenAI的采样参数转换为Ollama的options
+func openAIOptions(temperature, topP, frequencyPenalty, presencePenalty *float64, maxTokens, seed *int, stop interface{}) map[string]interface{} {
+	options := make(map[string]interface{})
+	if temperature != nil {
+		options["temperature"] = *temperature
+	}
+	if topP != nil {
+		options["top_p"] = *topP
+	}
+	if frequencyPenalty != nil {
+		options["frequency_penalty"] = *frequencyPenalty
+	}
+	if presencePenalty != nil {
+		options["presence_penalty"] = *presencePenalty
+	}
+	if maxTokens != nil {
+		options["num_predict"] = *maxTokens
+	}
+	if seed != nil {
+		options["seed"] = *seed
+	}
+	switch s := stop.(type) {
+	case string:
+		options["stop"] = []string{s}
+	case []interface{}:
+		var stops []string
+		for _, v := range s {
+			if str, ok := v.(string); ok {
+				stops = append(stops, str)
+			}
+		}
+		options["stop"] = stops
+	}
+	return options
+}
+
+// translateOpenAIRequest 将OpenAI请求体翻译为Ollama请求体，返回目标路径、请求体以及是否流式
+func translateOpenAIRequest(path string, body []byte) (string, []byte, openAIStreamInfo, error) {
+	var info openAIStreamInfo
+
+	switch path {
+	case openAIChatPath:
+		var req openAIChatRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", nil, info, err
+		}
+
+		messages := make([]map[string]interface{}, 0, len(req.Messages))
+		for _, msg := range req.Messages {
+			text, images := flattenOpenAIContent(msg.Content)
+			m := map[string]interface{}{
+				"role":    msg.Role,
+				"content": text,
+			}
+			if len(images) > 0 {
+				m["images"] = images
+			}
+			messages = append(messages, m)
+		}
+
+		ollamaReq := map[string]interface{}{
+			"model":    req.Model,
+			"messages": messages,
+			"stream":   req.Stream,
+			"options":  openAIOptions(req.Temperature, req.TopP, req.FrequencyPenalty, req.PresencePenalty, req.MaxTokens, req.Seed, req.Stop),
+		}
+		if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_object" {
+			ollamaReq["format"] = "json"
+		}
+
+		info.model = req.Model
+		info.stream = req.Stream
+		info.includeUsage = req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		out, err := json.Marshal(ollamaReq)
+		return "/api/chat", out, info, err
+
+	case openAICompletionPath:
+		var req openAICompletionRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", nil, info, err
+		}
+
+		var prompt string
+		switch p := req.Prompt.(type) {
+		case string:
+			prompt = p
+		case []interface{}:
+			// Ollama不支持批量补全，只取第一条提示词
+			if len(p) > 0 {
+				prompt, _ = p[0].(string)
+			}
+		}
+
+		ollamaReq := map[string]interface{}{
+			"model":   req.Model,
+			"prompt":  prompt,
+			"stream":  req.Stream,
+			"options": openAIOptions(req.Temperature, req.TopP, req.FrequencyPenalty, req.PresencePenalty, req.MaxTokens, req.Seed, req.Stop),
+		}
+		if req.Suffix != "" {
+			ollamaReq["suffix"] = req.Suffix
+		}
+
+		info.model = req.Model
+		info.stream = req.Stream
+		info.includeUsage = req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+		out, err := json.Marshal(ollamaReq)
+		return "/api/generate", out, info, err
+	}
+
+	return "", nil, info, fmt.Errorf("不支持的OpenAI接口: %s", path)
+}
+
+// flattenOpenAIContent 将OpenAI的多模态content拆分为文本和base64图片
+func flattenOpenAIContent(content interface{}) (string, []string) {
+	switch c := content.(type) {
+	case string:
+		return c, nil
+	case []interface{}:
+		var text strings.Builder
+		var images []string
+		for _, part := range c {
+			p, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch p["type"] {
+			case "text":
+				if t, ok := p["text"].(string); ok {
+					text.WriteString(t)
+				}
+			case "image_url":
+				var url string
+				switch iu := p["image_url"].(type) {
+				case string:
+					url = iu
+				case map[string]interface{}:
+					url, _ = iu["url"].(string)
+				}
+				// 只支持 data URL 形式的图片
+				if idx := strings.Index(url, ";base64,"); idx >= 0 {
+					images = append(images, url[idx+len(";base64,"):])
+				}
+			}
+		}
+		return text.String(), images
+	}
+	return "", nil
+}
+
+// openAIStreamInfo 描述OpenAI请求的输出方式
+type openAIStreamInfo struct {
+	model        string
+	stream       bool
+	includeUsage bool
+}
+
+// handleOpenAI 将OpenAI兼容请求翻译为Ollama请求后转发，并把响应翻译回OpenAI格式
+func (op *OllamaProxy) handleOpenAI(w http.ResponseWriter, r *http.Request, policy *admissionPolicy, reqID string) {
+	if reqID != "" {
+		r = r.WithContext(context.WithValue(r.Context(), "requestID", reqID))
+	}
+
+	if r.URL.Path == openAIModelsPath {
+		if r.Method != http.MethodGet {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		out := r.Clone(r.Context())
+		out.URL.Path = "/api/tags"
+		out.Header.Del("Accept-Encoding")
+		tw := newOpenAIResponseWriter(w, openAIModelsPath, openAIStreamInfo{})
+		op.proxy.ServeHTTP(tw, out)
+		tw.finish()
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ollamaPath, ollamaBody, info, err := translateOpenAIRequest(r.URL.Path, bodyBytes)
+	if err != nil {
+		log.Printf("[OpenAI] 翻译请求失败: %v", err)
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("[OpenAI] %s 翻译为 %s, 模型=%s, 流式=%v", r.URL.Path, ollamaPath, info.model, info.stream)
+
+	out := r.Clone(r.Context())
+	out.URL.Path = ollamaPath
+	out.Body = io.NopCloser(bytes.NewReader(ollamaBody))
+	out.ContentLength = int64(len(ollamaBody))
+	out.Header.Set("Content-Type", "application/json")
+	// 翻译需要读取明文响应
+	out.Header.Del("Accept-Encoding")
+
+	tw := newOpenAIResponseWriter(w, r.URL.Path, info)
+	if info.stream {
+		// 在Ollama NDJSON层面收集和审核流式响应，复用现有的日志与输出审核逻辑
+		op.serveStream(tw, out, policy, reqID, ollamaPath, info.model)
+	} else {
+		op.proxy.ServeHTTP(tw, out)
+	}
+	// 推测执行被拒绝时拒绝响应已直接写出
+	if spec, ok := r.Context().Value(speculationKey).(*speculation); ok && spec.handled {
+		return
+	}
+	tw.finish()
+}
+
+// openAIResponseWriter 将上游的Ollama响应翻译为OpenAI格式，流式响应逐行转换为SSE
+type openAIResponseWriter struct {
+	w       http.ResponseWriter
+	path    string
+	info    openAIStreamInfo
+	header  http.Header
+	status  int
+	started bool
+
+	id      string
+	created int64
+	buf     bytes.Buffer // 非流式响应或流式响应中未完整的行
+	rc      *http.ResponseController
+	done    bool
+}
+
+func newOpenAIResponseWriter(w http.ResponseWriter, path string, info openAIStreamInfo) *openAIResponseWriter {
+	prefix := "chatcmpl-"
+	if path == openAICompletionPath {
+		prefix = "cmpl-"
+	}
+	return &openAIResponseWriter{
+		w:       w,
+		path:    path,
+		info:    info,
+		header:  make(http.Header),
+		status:  http.StatusOK,
+		id:      prefix + randomID(),
+		created: time.Now().Unix(),
+		rc:      http.NewResponseController(w),
+	}
+}
+
+// Header 返回独立的响应头，避免上游的Content-Length等字段泄露给客户端
+func (tw *openAIResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *openAIResponseWriter) WriteHeader(status int) {
+	if tw.started {
+		return
+	}
+	tw.started = true
+	tw.status = status
+}
+
+func (tw *openAIResponseWriter) Write(p []byte) (int, error) {
+	if !tw.started {
+		tw.WriteHeader(http.StatusOK)
+	}
+	tw.buf.Write(p)
+
+	if !tw.info.stream || tw.status != http.StatusOK {
+		return len(p), nil
+	}
+
+	// 流式响应：逐行翻译为SSE事件
+	for {
+		line, err := tw.buf.ReadBytes('\n')
+		if err != nil {
+			// 不完整的行放回缓冲区等待后续数据
+			rest := append([]byte(nil), line...)
+			tw.buf.Reset()
+			tw.buf.Write(rest)
+			break
+		}
+		if err := tw.writeStreamLine(bytes.TrimSpace(line)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (tw *openAIResponseWriter) Flush() {
+	tw.rc.Flush()
+}
+
+// writeStreamLine 将一行Ollama NDJSON翻译为一个或多个SSE事件
+func (tw *openAIResponseWriter) writeStreamLine(line []byte) error {
+	if len(line) == 0 || tw.done {
+		return nil
+	}
+
+	var chunk ollamaResponse
+	if err := json.Unmarshal(line, &chunk); err != nil {
+		log.Printf("[OpenAI] 解析上游流式片段失败: %v", err)
+		return nil
+	}
+
+	if tw.w.Header().Get("Content-Type") == "" {
+		tw.w.Header().Set("Content-Type", "text/event-stream")
+		tw.w.Header().Set("Cache-Control", "no-cache")
+		tw.w.Header().Set("Connection", "keep-alive")
+		tw.w.WriteHeader(http.StatusOK)
+	}
+
+	if chunk.Error != "" {
+		if err := tw.writeEvent(openAIErrorBody(chunk.Error)); err != nil {
+			return err
+		}
+		tw.done = true
+		return tw.writeDone()
+	}
+
+	var finishReason *string
+	if chunk.Done {
+		finishReason = openAIFinishReason(chunk.DoneReason)
+	}
+
+	event := tw.newCompletion(true)
+	if tw.path == openAIChatPath {
+		event.Choices = []openAIChatChoice{{
+			Delta:        &chatMessage{Role: "assistant", Content: chunk.Message.Content},
+			FinishReason: finishReason,
+		}}
+	} else {
+		event.Choices = []openAITextChoice{{
+			Text:         chunk.Response,
+			FinishReason: finishReason,
+		}}
+	}
+	if err := tw.writeEvent(event); err != nil {
+		return err
+	}
+
+	if !chunk.Done {
+		return nil
+	}
+
+	tw.done = true
+	if tw.info.includeUsage {
+		event := tw.newCompletion(true)
+		event.Choices = []struct{}{}
+		event.Usage = newOpenAIUsage(chunk)
+		if err := tw.writeEvent(event); err != nil {
+			return err
+		}
+	}
+	return tw.writeDone()
+}
+
+func (tw *openAIResponseWriter) writeEvent(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(tw.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	tw.rc.Flush()
+	return nil
+}
+
+func (tw *openAIResponseWriter) writeDone() error {
+	if _, err := io.WriteString(tw.w, "data: [DONE]\n\n"); err != nil {
+		return err
+	}
+	tw.rc.Flush()
+	return nil
+}
+
+// finish 在上游处理结束后输出非流式响应或错误
+func (tw *openAIResponseWriter) finish() {
+	if tw.info.stream && tw.status == http.StatusOK {
+		// 处理末尾没有换行的片段
+		if tw.buf.Len() > 0 {
+			tw.writeStreamLine(bytes.TrimSpace(tw.buf.Bytes()))
+			tw.buf.Reset()
+		}
+		if !tw.done && tw.w.Header().Get("Content-Type") != "" {
+			tw.writeDone()
+		}
+		return
+	}
+
+	body := tw.buf.Bytes()
+
+	if tw.status != http.StatusOK {
+		var upstreamErr struct {
+			Error string `json:"error"`
+		}
+		msg := strings.TrimSpace(string(body))
+		if json.Unmarshal(body, &upstreamErr) == nil && upstreamErr.Error != "" {
+			msg = upstreamErr.Error
+		}
+		writeOpenAIError(tw.w, tw.status, msg)
+		return
+	}
+
+	var result interface{}
+	switch tw.path {
+	case openAIModelsPath:
+		result = translateModelList(body)
+	default:
+		var resp ollamaResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			log.Printf("[OpenAI] 解析上游响应失败: %v", err)
+			writeOpenAIError(tw.w, http.StatusInternalServerError, "invalid upstream response")
+			return
+		}
+		result = tw.translateCompletion(resp)
+	}
+
+	tw.w.Header().Set("Content-Type", "application/json")
+	tw.w.WriteHeader(http.StatusOK)
+	json.NewEncoder(tw.w).Encode(result)
+}
+
+// newCompletion 构造带有公共字段的OpenAI响应
+func (tw *openAIResponseWriter) newCompletion(chunk bool) *openAICompletion {
+	object := "text_completion"
+	if tw.path == openAIChatPath {
+		object = "chat.completion"
+		if chunk {
+			object = "chat.completion.chunk"
+		}
+	}
+	return &openAICompletion{
+		ID:                tw.id,
+		Object:            object,
+		Created:           tw.created,
+		Model:             tw.info.model,
+		SystemFingerprint: "fp_ollama",
+	}
+}
+
+// translateCompletion 将非流式的Ollama响应翻译为OpenAI响应
+func (tw *openAIResponseWriter) translateCompletion(resp ollamaResponse) interface{} {
+	result := tw.newCompletion(false)
+	if tw.path == openAIChatPath {
+		result.Choices = []openAIChatChoice{{
+			Message:      &chatMessage{Role: "assistant", Content: resp.Message.Content},
+			FinishReason: openAIFinishReason(resp.DoneReason),
+		}}
+	} else {
+		result.Choices = []openAITextChoice{{
+			Text:         resp.Response,
+			FinishReason: openAIFinishReason(resp.DoneReason),
+		}}
+	}
+	result.Usage = newOpenAIUsage(resp)
+	return result
+}
+
+// translateModelList 将 /api/tags 的响应翻译为 /v1/models 的响应
+func translateModelList(body []byte) interface{} {
+	var tags struct {
+		Models []struct {
+			Name       string `json:"name"`
+			ModifiedAt string `json:"modified_at"`
+		} `json:"models"`
+	}
+	json.Unmarshal(body, &tags)
+
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+
+	data := make([]model, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		created := time.Now().Unix()
+		if t, err := time.Parse(time.RFC3339Nano, m.ModifiedAt); err == nil {
+			created = t.Unix()
+		}
+		data = append(data, model{ID: m.Name, Object: "model", Created: created, OwnedBy: "library"})
+	}
+	return struct {
+		Object string  `json:"object"`
+		Data   []model `json:"data"`
+	}{"list", data}
+}
+
+// 以下结构体保持与OpenAI一致的JSON字段顺序
+
+type openAICompletion struct {
+	ID                string       `json:"id"`
+	Object            string       `json:"object"`
+	Created           int64        `json:"created"`
+	Model             string       `json:"model"`
+	SystemFingerprint string       `json:"system_fingerprint"`
+	Choices           interface{}  `json:"choices"`
+	Usage             *openAIUsage `json:"usage,omitempty"`
+}
+
+type openAIChatChoice struct {
+	Index        int          `json:"index"`
+	Message      *chatMessage `json:"message,omitempty"`
+	Delta        *chatMessage `json:"delta,omitempty"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+type openAITextChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ollamaResponse 是翻译时关心的Ollama响应字段
+type ollamaResponse struct {
+	Model   string `json:"model"`
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+func openAIFinishReason(doneReason string) *string {
+	reason := "stop"
+	if doneReason == "length" {
+		reason = "length"
+	}
+	return &reason
+}
+
+func newOpenAIUsage(resp ollamaResponse) *openAIUsage {
+	return &openAIUsage{
+		PromptTokens:     resp.PromptEvalCount,
+		CompletionTokens: resp.EvalCount,
+		TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+	}
+}
+
+type openAIError struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+func openAIErrorBody(msg string) openAIError {
+	var body openAIError
+	body.Error.Message = msg
+	body.Error.Type = "api_error"
+	return body
+}
+
+// writeOpenAIError 以OpenAI的错误格式返回
+func writeOpenAIError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(openAIErrorBody(msg))
+}
+
+// randomID 生成与Ollama兼容层一致的随机ID
+func randomID() string {
+	return strconv.Itoa(rand.Intn(999))
+}
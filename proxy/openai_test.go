@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTranslateOpenAIRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantPath string
+		want     map[string]interface{}
+		info     openAIStreamInfo
+	}{
+		{
+			name:     "chat",
+			path:     openAIChatPath,
+			body:     `{"model":"llama3","messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hi"}],"temperature":0.2,"max_tokens":64,"stop":"END","response_format":{"type":"json_object"}}`,
+			wantPath: "/api/chat",
+			want: map[string]interface{}{
+				"model": "llama3",
+				"messages": []interface{}{
+					map[string]interface{}{"role": "system", "content": "be nice"},
+					map[string]interface{}{"role": "user", "content": "hi"},
+				},
+				"stream":  false,
+				"format":  "json",
+				"options": map[string]interface{}{"temperature": 0.2, "num_predict": float64(64), "stop": []interface{}{"END"}},
+			},
+			info: openAIStreamInfo{model: "llama3"},
+		},
+		{
+			name:     "chat multimodal stream",
+			path:     openAIChatPath,
+			body:     `{"model":"llava","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":[{"type":"text","text":"what is "},{"type":"text","text":"this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBOR"}},{"type":"image_url","image_url":"https://example.com/a.png"}]}]}`,
+			wantPath: "/api/chat",
+			want: map[string]interface{}{
+				"model": "llava",
+				"messages": []interface{}{
+					map[string]interface{}{"role": "user", "content": "what is this?", "images": []interface{}{"iVBOR"}},
+				},
+				"stream":  true,
+				"options": map[string]interface{}{},
+			},
+			info: openAIStreamInfo{model: "llava", stream: true, includeUsage: true},
+		},
+		{
+			name:     "completion batch prompt",
+			path:     openAICompletionPath,
+			body:     `{"model":"llama3","prompt":["first","second"],"suffix":"}","seed":7,"stop":["a","b"]}`,
+			wantPath: "/api/generate",
+			want: map[string]interface{}{
+				"model":   "llama3",
+				"prompt":  "first",
+				"suffix":  "}",
+				"stream":  false,
+				"options": map[string]interface{}{"seed": float64(7), "stop": []interface{}{"a", "b"}},
+			},
+			info: openAIStreamInfo{model: "llama3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, body, info, err := translateOpenAIRequest(tt.path, []byte(tt.body))
+			if err != nil {
+				t.Fatalf("translateOpenAIRequest: %v", err)
+			}
+			if path != tt.wantPath {
+				t.Errorf("path = %q, want %q", path, tt.wantPath)
+			}
+			if info != tt.info {
+				t.Errorf("info = %+v, want %+v", info, tt.info)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("body = %s", body)
+			}
+		})
+	}
+}
+
+func TestTranslateOpenAIRequestErrors(t *testing.T) {
+	if _, _, _, err := translateOpenAIRequest(openAIChatPath, []byte(`{`)); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+	if _, _, _, err := translateOpenAIRequest("/v1/embeddings", []byte(`{}`)); err == nil {
+		t.Error("expected error for unsupported path")
+	}
+}
+
+func TestOpenAIResponseWriterCompletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		upstream string
+		check    func(t *testing.T, resp map[string]interface{})
+	}{
+		{
+			name:     "chat",
+			path:     openAIChatPath,
+			upstream: `{"model":"llama3","message":{"role":"assistant","content":"Hi!"},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":3}`,
+			check: func(t *testing.T, resp map[string]interface{}) {
+				if resp["object"] != "chat.completion" || !strings.HasPrefix(resp["id"].(string), "chatcmpl-") {
+					t.Errorf("object = %v, id = %v", resp["object"], resp["id"])
+				}
+				choice := resp["choices"].([]interface{})[0].(map[string]interface{})
+				if choice["message"].(map[string]interface{})["content"] != "Hi!" || choice["finish_reason"] != "stop" {
+					t.Errorf("choice = %v", choice)
+				}
+				usage := resp["usage"].(map[string]interface{})
+				if usage["prompt_tokens"] != float64(12) || usage["completion_tokens"] != float64(3) || usage["total_tokens"] != float64(15) {
+					t.Errorf("usage = %v", usage)
+				}
+			},
+		},
+		{
+			name:     "completion truncated",
+			path:     openAICompletionPath,
+			upstream: `{"model":"llama3","response":"Once upon","done":true,"done_reason":"length"}`,
+			check: func(t *testing.T, resp map[string]interface{}) {
+				if resp["object"] != "text_completion" || !strings.HasPrefix(resp["id"].(string), "cmpl-") {
+					t.Errorf("object = %v, id = %v", resp["object"], resp["id"])
+				}
+				choice := resp["choices"].([]interface{})[0].(map[string]interface{})
+				if choice["text"] != "Once upon" || choice["finish_reason"] != "length" {
+					t.Errorf("choice = %v", choice)
+				}
+			},
+		},
+		{
+			name:     "models",
+			path:     openAIModelsPath,
+			upstream: `{"models":[{"name":"llama3:latest","modified_at":"2024-05-01T10:00:00Z"}]}`,
+			check: func(t *testing.T, resp map[string]interface{}) {
+				data := resp["data"].([]interface{})
+				if resp["object"] != "list" || len(data) != 1 {
+					t.Fatalf("resp = %v", resp)
+				}
+				m := data[0].(map[string]interface{})
+				if m["id"] != "llama3:latest" || m["created"] != float64(1714557600) || m["owned_by"] != "library" {
+					t.Errorf("model = %v", m)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tw := newOpenAIResponseWriter(rec, tt.path, openAIStreamInfo{model: "llama3"})
+			tw.Header().Set("Content-Length", "999")
+			tw.Write([]byte(tt.upstream))
+			tw.finish()
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			// 上游的响应头不应泄露给客户端
+			if rec.Header().Get("Content-Length") != "" {
+				t.Errorf("Content-Length leaked: %q", rec.Header().Get("Content-Length"))
+			}
+			var resp map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body.String(), err)
+			}
+			tt.check(t, resp)
+		})
+	}
+}
+
+func TestOpenAIResponseWriterUpstreamError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	tw := newOpenAIResponseWriter(rec, openAIChatPath, openAIStreamInfo{model: "mistral", stream: true})
+	tw.WriteHeader(http.StatusNotFound)
+	tw.Write([]byte(`{"error":"model \"mistral\" not found, try pulling it first"}`))
+	tw.finish()
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d", rec.Code)
+	}
+	var resp openAIError
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Error.Message != `model "mistral" not found, try pulling it first` || resp.Error.Type != "api_error" {
+		t.Errorf("error = %+v", resp.Error)
+	}
+}
+
+// sseEvents 解析SSE响应中的 data 事件
+func sseEvents(t *testing.T, body string) []string {
+	t.Helper()
+	var events []string
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		if !strings.HasPrefix(block, "data: ") {
+			t.Fatalf("unexpected SSE block %q", block)
+		}
+		events = append(events, strings.TrimPrefix(block, "data: "))
+	}
+	return events
+}
+
+func TestOpenAIResponseWriterStream(t *testing.T) {
+	rec := httptest.NewRecorder()
+	tw := newOpenAIResponseWriter(rec, openAIChatPath, openAIStreamInfo{model: "llama3", stream: true, includeUsage: true})
+
+	// 片段跨越多次写入，最后一行没有换行
+	tw.Write([]byte(`{"message":{"content":"Hel"},"done":false}` + "\n" + `{"message":{"content":"lo"},`))
+	tw.Write([]byte(`"done":false}` + "\n"))
+	tw.Write([]byte(`{"message":{"content":""},"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`))
+	tw.finish()
+
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	events := sseEvents(t, rec.Body.String())
+	if len(events) != 5 || events[4] != "[DONE]" {
+		t.Fatalf("events = %q", events)
+	}
+
+	var text strings.Builder
+	for i, data := range events[:3] {
+		var chunk struct {
+			Object  string `json:"object"`
+			Choices []struct {
+				Delta        chatMessage `json:"delta"`
+				FinishReason *string     `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decode %q: %v", data, err)
+		}
+		if chunk.Object != "chat.completion.chunk" || len(chunk.Choices) != 1 {
+			t.Fatalf("chunk = %s", data)
+		}
+		if (chunk.Choices[0].FinishReason != nil) != (i == 2) {
+			t.Errorf("chunk %d finish_reason = %v", i, chunk.Choices[0].FinishReason)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if text.String() != "Hello" {
+		t.Errorf("text = %q", text.String())
+	}
+
+	var usage struct {
+		Choices []interface{} `json:"choices"`
+		Usage   openAIUsage   `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(events[3]), &usage); err != nil {
+		t.Fatalf("decode usage: %v", err)
+	}
+	if len(usage.Choices) != 0 || usage.Usage.TotalTokens != 7 {
+		t.Errorf("usage event = %s", events[3])
+	}
+}
+
+func TestOpenAIResponseWriterStreamError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	tw := newOpenAIResponseWriter(rec, openAICompletionPath, openAIStreamInfo{model: "llama3", stream: true})
+	tw.Write([]byte(`{"response":"a","done":false}` + "\n" + `{"error":"out of memory"}` + "\n" + `{"response":"b","done":false}` + "\n"))
+	tw.finish()
+
+	events := sseEvents(t, rec.Body.String())
+	if len(events) != 3 || events[2] != "[DONE]" {
+		t.Fatalf("events = %q", events)
+	}
+	var resp openAIError
+	if err := json.Unmarshal([]byte(events[1]), &resp); err != nil || resp.Error.Message != "out of memory" {
+		t.Errorf("error event = %s (%v)", events[1], err)
+	}
+}
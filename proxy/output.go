@@ -0,0 +1,364 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/logger"
+)
+
+type contextKey string
+
+// streamCutKey 在请求上下文中保存流式输出的中断信号
+const streamCutKey contextKey = "streamCut"
+
+// streamCut 表示流式输出已被输出审核中断
+type streamCut struct {
+	done atomic.Bool
+}
+
+// cutoffReader 在流式输出被中断后返回EOF，让反向代理正常结束转发并关闭上游连接。
+// 不能通过取消上游请求的上下文来中断，否则反向代理会以 http.ErrAbortHandler 终止连接
+type cutoffReader struct {
+	io.ReadCloser
+	cut *streamCut
+}
+
+func (c *cutoffReader) Read(p []byte) (int, error) {
+	if c.cut.done.Load() {
+		return 0, io.EOF
+	}
+	return c.ReadCloser.Read(p)
+}
+
+// isModeratedPath 判断接口是否需要进行输出审核
+func isModeratedPath(path string) bool {
+	return path == "/api/generate" || path == "/api/chat"
+}
+
+// replacementText 返回违规输出的替换文本，没有配置替换文本时使用策略的拒绝文本
+func (op *OllamaProxy) replacementText(policy *admissionPolicy, reason, model string) string {
+	if op.outputCfg.ReplacementText != "" {
+		return op.outputCfg.ReplacementText
+	}
+	return policy.denial.Message(reason, model)
+}
+
+// moderateResponseBody 审核非流式响应中生成的文本，违规时按配置替换或截断
+func (op *OllamaProxy) moderateResponseBody(ctx context.Context, reqID, path string, body []byte) []byte {
+	policy := op.policyFor(ctx)
+	if !op.outputCfg.Enabled || policy.skip || !isModeratedPath(path) {
+		return body
+	}
+
+	text, _, err := admission.ExtractResponseText(path, body)
+	if err != nil || text == "" {
+		return body
+	}
+
+	entry := logger.OutputModerationLog{
+		Path:         path,
+		Allowed:      true,
+		Action:       "none",
+		CheckedChars: utf8.RuneCountInString(text),
+		Checks:       1,
+		Policy:       policy.name,
+	}
+	defer func() {
+		if op.logger != nil && reqID != "" {
+			op.logger.LogOutputModeration(reqID, entry)
+		}
+	}()
+
+	// 与输入审核一样按文本长度、重试和分窗推算超时，避免较慢的审核模型在长回答上总是超时
+	checkCtx, cancel := context.WithTimeout(ctx, admission.CheckTimeout(policy.cfg, entry.CheckedChars))
+	defer cancel()
+
+	// 出错时的结论已按 failure_mode 处理
+	verdict, err := policy.checker.CheckContent(checkCtx, text)
+	if err != nil {
+		log.Printf("[警告] 输出审核失败: %v", err)
+		entry.Error = err.Error()
+	}
+	if verdict.Allowed {
+		return body
+	}
+
+	reason := verdict.Reason
+	entry.Allowed = false
+	entry.Reason = reason
+	entry.Categories = verdict.Categories
+	entry.Score = verdict.Score
+	entry.Action = op.outputCfg.Action
+	if policy.cfg.Shadow {
+		entry.Action = "shadow"
+		log.Printf("[影子] 非流式输出本应被处理: %s", reason)
+		return body
+	}
+
+	replacement := op.replacementText(policy, reason, "")
+	if op.outputCfg.Action == "truncate" {
+		truncated, err := admission.TruncateToAllowed(checkCtx, policy.checker, text, op.outputCfg.StreamCheckChars)
+		if err != nil {
+			log.Printf("[警告] 截断违规输出失败，改为替换: %v", err)
+			entry.Error = err.Error()
+			entry.Action = "replace"
+		} else {
+			replacement = truncated
+		}
+	}
+
+	rewritten, err := admission.RewriteResponseText(path, body, replacement)
+	if err != nil {
+		log.Printf("[错误] 改写响应失败: %v", err)
+		entry.Error = err.Error()
+		return body
+	}
+	log.Printf("[输出审核] 非流式输出违规: %s, 处理方式=%s", reason, entry.Action)
+	return rewritten
+}
+
+// outputResult 为一次异步输出审核的结果
+type outputResult struct {
+	checked int
+	verdict *admission.Verdict
+	err     error
+}
+
+// outputGuard 位于上游流式响应与客户端之间，按行解析NDJSON片段并定期审核已生成的文本。
+// 审核在后台进行，不阻塞片段的转发；结束片段会等待最后一次审核完成后再下发
+type outputGuard struct {
+	op      *OllamaProxy
+	policy  *admissionPolicy
+	ctx     context.Context
+	w       io.Writer
+	cut     *streamCut
+	reqID   string
+	path    string
+	model   string
+	started time.Time
+
+	line         []byte
+	text         []rune
+	checked      int
+	tokens       int
+	promptTokens int
+
+	pending  chan outputResult
+	inFlight bool
+
+	entry    logger.OutputModerationLog
+	violated bool
+	logged   bool
+}
+
+func (op *OllamaProxy) newOutputGuard(ctx context.Context, policy *admissionPolicy, w io.Writer, cut *streamCut, reqID, path, model string) *outputGuard {
+	return &outputGuard{
+		op:      op,
+		policy:  policy,
+		ctx:     ctx,
+		w:       w,
+		cut:     cut,
+		reqID:   reqID,
+		path:    path,
+		model:   model,
+		started: time.Now(),
+		pending: make(chan outputResult, 1),
+		entry: logger.OutputModerationLog{
+			Path:    path,
+			Model:   model,
+			Stream:  true,
+			Allowed: true,
+			Action:  "none",
+			Policy:  policy.name,
+		},
+	}
+}
+
+func (g *outputGuard) Write(p []byte) (int, error) {
+	// 中断后丢弃上游剩余的数据
+	if g.cut.done.Load() {
+		return len(p), nil
+	}
+
+	data := p
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			g.line = append(g.line, data...)
+			break
+		}
+		g.line = append(g.line, data[:idx+1]...)
+		data = data[idx+1:]
+
+		if err := g.handleLine(g.line); err != nil {
+			return 0, err
+		}
+		g.line = g.line[:0]
+		if g.cut.done.Load() {
+			break
+		}
+	}
+	return len(p), nil
+}
+
+// handleLine 处理一个完整的流式片段
+func (g *outputGuard) handleLine(line []byte) error {
+	text, done, err := admission.ExtractResponseText(g.path, bytes.TrimSpace(line))
+	if err != nil || g.violated {
+		// 无法解析的片段或已记录违规（log策略）时直接转发
+		_, werr := g.w.Write(line)
+		return werr
+	}
+
+	g.text = append(g.text, []rune(text)...)
+	if text != "" {
+		g.tokens++
+	}
+
+	// 收取已完成的审核结果
+	select {
+	case res := <-g.pending:
+		g.inFlight = false
+		g.apply(res)
+	default:
+	}
+
+	if done && !g.violated {
+		// 结束片段下发前等待进行中的审核，并检查剩余未审核的文本
+		if g.inFlight {
+			g.apply(<-g.pending)
+			g.inFlight = false
+		}
+		if !g.violated && g.checked < len(g.text) {
+			g.apply(g.check(string(g.text), len(g.text)))
+		}
+	} else if !g.violated && !g.inFlight && len(g.text)-g.checked >= g.op.outputCfg.StreamCheckChars {
+		g.inFlight = true
+		text, n := string(g.text), len(g.text)
+		go func() { g.pending <- g.check(text, n) }()
+	}
+
+	if g.violated && g.op.outputCfg.StreamPolicy == "cutoff" && !g.policy.cfg.Shadow {
+		return g.cutoff()
+	}
+
+	_, err = g.w.Write(line)
+	if done {
+		g.close()
+	}
+	return err
+}
+
+// check 审核已生成的文本，n为文本的字符数
+func (g *outputGuard) check(text string, n int) outputResult {
+	ctx, cancel := context.WithTimeout(g.ctx, admission.CheckTimeout(g.policy.cfg, n))
+	defer cancel()
+
+	verdict, err := g.policy.checker.CheckContent(ctx, text)
+	return outputResult{checked: n, verdict: verdict, err: err}
+}
+
+// apply 记录一次审核的结果
+func (g *outputGuard) apply(res outputResult) {
+	g.entry.Checks++
+	if res.err != nil {
+		// 出错时的结论已按 failure_mode 处理
+		log.Printf("[警告] 流式输出审核失败: %v", res.err)
+		g.entry.Error = res.err.Error()
+	}
+	if res.checked > g.checked {
+		g.checked = res.checked
+	}
+	g.entry.CheckedChars = g.checked
+	if !res.verdict.Allowed {
+		g.violated = true
+		g.entry.Allowed = false
+		g.entry.Reason = res.verdict.Reason
+		g.entry.Categories = res.verdict.Categories
+		g.entry.Score = res.verdict.Score
+		g.entry.Action = g.op.outputCfg.StreamPolicy
+		if g.policy.cfg.Shadow {
+			g.entry.Action = "shadow"
+		}
+		log.Printf("[输出审核] 流式输出违规: %s, 处理方式=%s", res.verdict.Reason, g.entry.Action)
+	}
+}
+
+// cutoff 中断流式输出：按配置先输出替换文本，再发送一个结束片段，让客户端正常结束
+func (g *outputGuard) cutoff() error {
+	g.cut.done.Store(true)
+
+	if g.op.outputCfg.Action == "replace" {
+		text := "\n\n" + g.op.replacementText(g.policy, g.entry.Reason, g.model)
+		if _, err := g.w.Write(admission.StreamChunk(g.path, g.model, text, nil)); err != nil {
+			return err
+		}
+	}
+
+	metrics := g.op.timing.Estimate(g.model, g.promptTokens, g.tokens)
+	metrics.TotalDuration = time.Since(g.started).Nanoseconds() + metrics.LoadDuration
+	_, err := g.w.Write(admission.StreamChunk(g.path, g.model, "", &metrics))
+	g.close()
+	return err
+}
+
+// close 记录输出审核结果，每个请求只记录一次
+func (g *outputGuard) close() {
+	if g.logged {
+		return
+	}
+	if g.inFlight {
+		// 上游提前结束时等待进行中的审核，保证记录完整
+		g.apply(<-g.pending)
+		g.inFlight = false
+	}
+	if g.entry.Checks == 0 {
+		return
+	}
+	g.logged = true
+	if g.op.logger != nil && g.reqID != "" {
+		g.op.logger.LogOutputModeration(g.reqID, g.entry)
+	}
+}
+
+// serveStream 转发流式请求，并按需挂载输出审核与流式日志收集。
+// w 为最终写出的目标，OpenAI兼容接口传入的是翻译器，审核在Ollama的NDJSON层面进行
+func (op *OllamaProxy) serveStream(w http.ResponseWriter, r *http.Request, policy *admissionPolicy, reqID, path, model string) {
+	var out io.Writer = w
+	if reqID != "" && op.logger != nil {
+		// 使用流式收集器
+		out = io.MultiWriter(w, newStreamCollector(reqID, path, model, op.logger))
+	}
+
+	var guard *outputGuard
+	if op.outputCfg.Enabled && isModeratedPath(path) && !policy.skip {
+		cut := &streamCut{}
+		r = r.WithContext(context.WithValue(r.Context(), streamCutKey, cut))
+		guard = op.newOutputGuard(r.Context(), policy, out, cut, reqID, path, model)
+		// 中断时的结束片段需要与正常结束一样带上提示词的耗时统计
+		if r.Body != nil {
+			bodyBytes, _ := io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			guard.promptTokens = admission.NewDenialRequest(path, bodyBytes, "").PromptTokens
+		}
+		out = guard
+	}
+
+	// 创建代理ResponseWriter
+	op.proxy.ServeHTTP(&streamResponseWriter{
+		ResponseWriter: w,
+		teeWriter:      out,
+	}, r)
+
+	if guard != nil {
+		guard.close()
+	}
+}
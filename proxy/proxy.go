@@ -12,10 +12,12 @@ import (
 	"net/url"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
 	"github.com/mfzzf/LLM_Based_HoneyPot/config"
 	"github.com/mfzzf/LLM_Based_HoneyPot/logger"
+	"github.com/mfzzf/LLM_Based_HoneyPot/review"
 )
 
 // OllamaProxy 表示Ollama代理服务器
@@ -25,10 +27,22 @@ type OllamaProxy struct {
 	proxy      *httputil.ReverseProxy
 	logger     logger.Logger
 	admChecker admission.Checker // 添加准入控制检查器
+	emulator   *ollamaEmulator   // 无后端模式下的Ollama模拟器，为空时转发到targetURL
+	timing     *admission.TimingProfile
+	denier     *admission.Denier
+	outputCfg  config.OutputConfig
+	// speculative 为推测执行模式，上游请求与准入检查同时进行
+	speculative bool
+	detector    *admission.InjectionDetector // 攻击手法识别，为空时不识别
+	review      *review.Queue
+	admin       *review.AdminServer
+	// defaultPolicy 为没有路由匹配时使用的准入策略，routes 为按顺序匹配的路由策略
+	defaultPolicy *admissionPolicy
+	routes        []*admissionPolicy
 }
 
 // NewOllamaProxy 创建一个新的Ollama代理实例
-func NewOllamaProxy(listenAddr, targetAddr string, logger logger.Logger, admCfg config.AdmissionConfig) (*OllamaProxy, error) {
+func NewOllamaProxy(listenAddr, targetAddr string, logger logger.Logger, admCfg config.AdmissionConfig, standaloneCfg config.StandaloneConfig, detectionCfg config.DetectionConfig, dlpCfg config.DLPConfig, reviewCfg config.ReviewConfig) (*OllamaProxy, error) {
 	targetURL, err := url.Parse(targetAddr)
 	if err != nil {
 		return nil, err
@@ -36,6 +50,9 @@ func NewOllamaProxy(listenAddr, targetAddr string, logger logger.Logger, admCfg
 
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
+	// 记录上游的真实耗时，用于生成拒绝响应
+	timing := admission.NewTimingProfile()
+
 	// 自定义请求导向器
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
@@ -43,40 +60,171 @@ func NewOllamaProxy(listenAddr, targetAddr string, logger logger.Logger, admCfg
 		modifyRequest(req, targetURL)
 	}
 
-	// 添加响应修改器
-	proxy.ModifyResponse = func(resp *http.Response) error {
-		// 如果请求上下文中有请求ID，则记录响应
-		if reqID, ok := resp.Request.Context().Value("requestID").(string); ok && logger != nil {
-			// 读取响应体
-			var bodyBytes []byte
-			if resp.Body != nil {
-				bodyBytes, _ = io.ReadAll(resp.Body)
-				// 重置响应体
-				resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
-			}
-			// 记录响应
-			logger.LogResponse(reqID, resp, bodyBytes)
-		}
-		return nil
-	}
-
 	// 创建准入控制检查器
 	var admChecker admission.Checker
+	var shared *admission.Shared
 	if admCfg.Enabled {
 		log.Printf("[初始化] 准入控制已启用: 模型=%s, URL=%s",
 			admCfg.ModelName, admCfg.OllamaURL)
-		admChecker = admission.NewOllamaChecker(admCfg)
+		// 规则集和审核结论缓存由默认策略和各路由策略共用
+		if shared, err = admission.NewShared(admCfg); err != nil {
+			return nil, err
+		}
+		if admChecker, err = admission.NewChecker(admCfg, dlpCfg, shared); err != nil {
+			return nil, err
+		}
+		log.Printf("[初始化] 审核失败处理方式: %s", admCfg.FailureMode)
+		if admCfg.Shadow {
+			log.Printf("[初始化] 准入控制运行于影子模式，只记录审核结论，不拦截请求")
+		}
 	} else {
 		log.Printf("[警告] 准入控制已禁用")
 	}
 
-	return &OllamaProxy{
-		listenAddr: listenAddr,
-		targetURL:  targetURL,
-		proxy:      proxy,
-		logger:     logger,
-		admChecker: admChecker,
-	}, nil
+	// 输出侧准入控制依赖准入控制检查器
+	outputCfg := admCfg.Output
+	if outputCfg.Enabled {
+		if admChecker == nil {
+			log.Printf("[警告] 准入控制已禁用，输出审核不会生效")
+			outputCfg.Enabled = false
+		} else {
+			if outputCfg.Action != "replace" && outputCfg.Action != "truncate" {
+				return nil, fmt.Errorf("无效的输出审核处理方式: %q", outputCfg.Action)
+			}
+			if outputCfg.StreamPolicy != "cutoff" && outputCfg.StreamPolicy != "log" {
+				return nil, fmt.Errorf("无效的流式输出审核策略: %q", outputCfg.StreamPolicy)
+			}
+			if outputCfg.StreamCheckChars <= 0 {
+				outputCfg.StreamCheckChars = 200
+			}
+			log.Printf("[初始化] 输出审核已启用: 处理方式=%s, 流式策略=%s, 检查间隔=%d字符",
+				outputCfg.Action, outputCfg.StreamPolicy, outputCfg.StreamCheckChars)
+		}
+	}
+
+	// 复核队列收集准入控制的决定，依赖准入控制检查器
+	var queue *review.Queue
+	var admin *review.AdminServer
+	if reviewCfg.Enabled {
+		if admChecker == nil {
+			log.Printf("[警告] 准入控制已禁用，复核队列不会生效")
+		} else {
+			queue, err = review.NewQueue(reviewCfg, admCfg.RulesFile)
+			if err != nil {
+				return nil, fmt.Errorf("初始化复核队列失败: %w", err)
+			}
+			admin = review.NewAdminServer(reviewCfg.AdminAddr, reviewCfg.AdminToken, queue, shared.Cache)
+		}
+	}
+
+	// 攻击手法识别只用于情报收集，准入控制禁用时同样生效
+	var detector *admission.InjectionDetector
+	if detectionCfg.Enabled {
+		if detectionCfg.ModelName == "" {
+			detectionCfg.ModelName = admCfg.ModelName
+		}
+		if detectionCfg.OllamaURL == "" {
+			detectionCfg.OllamaURL = admCfg.OllamaURL
+		}
+		detector = admission.NewInjectionDetector(detectionCfg)
+	}
+
+	// 创建Ollama模拟器，模拟器作为反向代理的Transport在进程内处理请求，
+	// 这样响应日志、耗时观测和输出审核对两种模式都一样生效
+	var emulator *ollamaEmulator
+	if standaloneCfg.Enabled {
+		emulator, err = newOllamaEmulator(standaloneCfg, timing)
+		if err != nil {
+			return nil, fmt.Errorf("初始化模拟模式失败: %w", err)
+		}
+		proxy.Transport = emulator
+		log.Printf("[初始化] 无后端模拟模式已启用，不会转发请求到 %s", targetAddr)
+	}
+
+	op := &OllamaProxy{
+		listenAddr:  listenAddr,
+		targetURL:   targetURL,
+		proxy:       proxy,
+		logger:      logger,
+		admChecker:  admChecker,
+		emulator:    emulator,
+		timing:      timing,
+		denier:      admission.NewDenier(timing),
+		outputCfg:   outputCfg,
+		speculative: admChecker != nil && admCfg.Speculative,
+		detector:    detector,
+		review:      queue,
+		admin:       admin,
+	}
+
+	// 默认策略使用准入控制的顶层配置，路由按顺序匹配
+	op.defaultPolicy = &admissionPolicy{name: defaultPolicyName, checker: admChecker, skip: admChecker == nil, cfg: admCfg}
+	if admChecker != nil {
+		if op.defaultPolicy.denial, err = admission.NewDenialTemplate(admCfg.DenialMessage); err != nil {
+			return nil, err
+		}
+		if op.routes, err = newAdmissionPolicies(admCfg, dlpCfg, shared); err != nil {
+			return nil, err
+		}
+	}
+
+	// 添加响应修改器
+	proxy.ModifyResponse = op.modifyResponse
+	proxy.ErrorHandler = op.handleProxyError
+	if op.speculative {
+		log.Printf("[初始化] 推测执行已启用，上游请求与准入检查同时进行")
+	}
+
+	return op, nil
+}
+
+// modifyResponse 记录上游响应，观测耗时统计并执行非流式响应的输出审核
+func (op *OllamaProxy) modifyResponse(resp *http.Response) error {
+	ctx := resp.Request.Context()
+
+	// 推测执行时等待审核结论，拒绝时丢弃上游响应，由 handleProxyError 输出拒绝响应
+	if spec, ok := ctx.Value(speculationKey).(*speculation); ok && !spec.wait() {
+		return errSpeculationDenied
+	}
+
+	// 流式响应不能在此处整体读取，否则客户端要等到生成结束才能收到数据；
+	// 日志和输出审核由streamCollector与outputGuard负责，这里只在转发的同时观测耗时统计
+	if resp.Body != nil && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-ndjson") {
+		var body io.ReadCloser = &timingObserver{ReadCloser: resp.Body, timing: op.timing}
+		if cut, ok := ctx.Value(streamCutKey).(*streamCut); ok {
+			body = &cutoffReader{ReadCloser: body, cut: cut}
+		}
+		resp.Body = body
+		return nil
+	}
+
+	reqID, ok := ctx.Value("requestID").(string)
+	logResponse := ok && op.logger != nil
+	isJSON := strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")
+	if resp.Body == nil || (!logResponse && !isJSON) {
+		return nil
+	}
+
+	// 读取响应体
+	bodyBytes, _ := io.ReadAll(resp.Body)
+
+	if isJSON {
+		op.timing.ObserveResponse(bodyBytes)
+		if resp.StatusCode == http.StatusOK {
+			bodyBytes = op.moderateResponseBody(ctx, reqID, resp.Request.URL.Path, bodyBytes)
+		}
+	}
+
+	// 重置响应体
+	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	resp.ContentLength = int64(len(bodyBytes))
+	resp.Header.Set("Content-Length", fmt.Sprint(len(bodyBytes)))
+
+	// 如果请求上下文中有请求ID，则记录响应
+	if logResponse {
+		op.logger.LogResponse(reqID, resp, bodyBytes, nil)
+	}
+	return nil
 }
 
 // 修改请求
@@ -93,6 +241,7 @@ type streamCollector struct {
 	accumulated []byte
 	path        string
 	model       string
+	firstChunk  time.Time
 }
 
 func newStreamCollector(reqID string, path string, model string, logger logger.Logger) *streamCollector {
@@ -105,6 +254,9 @@ func newStreamCollector(reqID string, path string, model string, logger logger.L
 }
 
 func (sc *streamCollector) Write(p []byte) (int, error) {
+	if sc.firstChunk.IsZero() {
+		sc.firstChunk = time.Now()
+	}
 	// 累积流式响应片段
 	sc.accumulated = append(sc.accumulated, p...)
 
@@ -118,57 +270,61 @@ func (sc *streamCollector) Write(p []byte) (int, error) {
 				URL: &url.URL{Path: sc.path},
 			},
 		}
-
-		// 为聊天响应创建完整的聊天记录
-		if strings.Contains(sc.path, "/api/chat") {
-			var combinedResponse struct {
-				Message struct {
-					Content string `json:"content"`
-				} `json:"message"`
-				Model string `json:"model"`
-				Done  bool   `json:"done"`
-			}
-
-			combinedResponse.Message.Content = sc.getAccumulatedContent()
-			combinedResponse.Model = sc.model
-			combinedResponse.Done = true
-
-			fullResponseBytes, _ := json.Marshal(combinedResponse)
-			sc.logger.LogResponse(sc.reqID, resp, fullResponseBytes)
-		} else {
-			// 对于generate请求，直接使用累积的响应
-			sc.logger.LogResponse(sc.reqID, resp, sc.accumulated)
-		}
+		body, chunks := sc.combine()
+		sc.logger.LogResponse(sc.reqID, resp, body, &logger.StreamStats{Chunks: chunks, FirstChunk: sc.firstChunk})
 	}
 
 	return len(p), nil
 }
 
-// 从流式响应片段中提取内容
-func (sc *streamCollector) getAccumulatedContent() string {
-	var fullContent strings.Builder
+// combine 把流式响应片段合并为一个与非流式响应格式相同的对象：以最后一个片段（包含耗时和token数）为基础，
+// 填入拼接后的完整内容，返回合并后的响应和片段数
+func (sc *streamCollector) combine() ([]byte, int) {
+	var content strings.Builder
+	var final map[string]interface{}
+	chunks := 0
 
 	// 将所有片段解析为单独的JSON对象
 	for _, chunk := range bytes.Split(sc.accumulated, []byte("\n")) {
-		if len(chunk) > 0 {
-			var response map[string]interface{}
-			if err := json.Unmarshal(chunk, &response); err != nil {
-				continue
-			}
+		if len(bytes.TrimSpace(chunk)) == 0 {
+			continue
+		}
+		chunks++
+		var response map[string]interface{}
+		if err := json.Unmarshal(chunk, &response); err != nil {
+			continue
+		}
 
-			if message, ok := response["message"].(map[string]interface{}); ok {
-				if content, ok := message["content"].(string); ok {
-					fullContent.WriteString(content)
-				}
+		if message, ok := response["message"].(map[string]interface{}); ok {
+			if text, ok := message["content"].(string); ok {
+				content.WriteString(text)
 			}
+		} else if text, ok := response["response"].(string); ok {
+			content.WriteString(text)
+		}
+		if done, _ := response["done"].(bool); done {
+			final = response
 		}
 	}
 
-	return fullContent.String()
+	if final == nil {
+		final = map[string]interface{}{"done": true}
+	}
+	if _, ok := final["model"]; !ok {
+		final["model"] = sc.model
+	}
+	if strings.Contains(sc.path, "/api/chat") {
+		final["message"] = map[string]interface{}{"role": "assistant", "content": content.String()}
+	} else {
+		final["response"] = content.String()
+	}
+	data, _ := json.Marshal(final)
+	return data, chunks
 }
 
 // 修改代理请求处理函数，确保准入控制先执行
 func (op *OllamaProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
 	log.Printf("[代理] %s %s", r.Method, r.URL.Path)
 
 	// 记录初始检查条件
@@ -181,7 +337,15 @@ func (op *OllamaProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		reqID = op.logger.LogRequest(r)
 	}
 
-	// 准入控制检查 - 所有POST请求都需要检查
+	// 攻击手法识别在后台进行，不影响请求的处理
+	if op.detector != nil && r.Method == "POST" {
+		bodyBytes, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		go op.detect(reqID, admission.ExtractInput(r.URL.Path, bodyBytes))
+	}
+
+	// 准入控制检查 - 所有POST请求都需要检查。策略只在这里选择一次，向下传递给准入检查和流式输出审核
+	policy := op.defaultPolicy
 	if op.admChecker != nil && r.Method == "POST" {
 		log.Printf("[调试] 开始准入控制检查: 请求路径=%s", r.URL.Path)
 
@@ -196,54 +360,66 @@ func (op *OllamaProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		// 重置请求体
 		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
-		// 执行准入控制检查
-		allowed, reason, err := op.enforceAdmissionCheck(r)
-
-		log.Printf("[调试] 准入检查结果: 允许=%v, 原因=%s, 错误=%v", allowed, reason, err)
+		// 按请求选择准入策略，保存在上下文中供输出审核使用
+		policy = op.selectPolicy(r, bodyBytes)
+		r = r.WithContext(context.WithValue(r.Context(), policyKey, policy))
 
-		// 记录准入控制结果
-		if op.logger != nil && reqID != "" {
-			op.logger.LogAdmission(reqID, allowed, reason)
+		// 执行准入控制检查
+		input := admission.ExtractInput(r.URL.Path, bodyBytes)
+		if policy.skip {
+			log.Printf("[策略] 路由 %s 不需要准入检查: 路径=%s", policy.name, r.URL.Path)
+			if op.logger != nil && reqID != "" {
+				op.logger.LogAdmission(reqID, logger.AdmissionLog{
+					Allowed: true,
+					Content: input.String(),
+					Policy:  policy.name,
+					Skipped: true,
+				})
+			}
+		} else if op.speculative {
+			// 推测执行：上游请求与准入检查同时进行，响应在审核结论到达前暂不下发
+			spec := op.speculate(w, r, policy, reqID, input, bodyBytes, started)
+			defer spec.wait()
+			r = r.WithContext(context.WithValue(spec.upstream, speculationKey, spec))
+		} else if verdict, deny := op.admit(r.Context(), policy, reqID, r.URL.Path, input); deny {
+			// 返回与请求接口和输出方式一致的拒绝响应
+			denial := policy.denialRequest(r.URL.Path, bodyBytes, verdict.Reason)
+			op.denier.WriteResponse(r.Context(), w, denial, started)
+			return
 		}
 
 		// 再次重置请求体
 		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	}
 
-		if err != nil {
-			log.Printf("[警告] 准入控制检查失败: %v", err)
-		} else if !allowed {
-			log.Printf("[拒绝] 请求被准入控制拒绝: %s", reason)
-
-			// 返回拒绝响应
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-
-			response := admission.CreateDeniedResponse(reason, r.URL.Path)
-			w.Write(response)
-			return
-		}
+	// OpenAI兼容接口需要翻译为Ollama请求后再转发
+	if isOpenAIPath(r.URL.Path) {
+		op.handleOpenAI(w, r, policy, reqID)
+		return
 	}
 
 	// 检测是否为流式请求
 	isStreamRequest := false
 
-	// 如果是POST请求且内容类型为JSON，检查是否流式请求
-	if r.Method == "POST" && r.Header.Get("Content-Type") == "application/json" {
+	// 如果是POST请求，检查是否流式请求。Ollama不校验Content-Type，这里也不能依赖它，
+	// 否则修改请求头就能绕过流式日志和输出审核
+	if r.Method == "POST" {
 		bodyBytes, _ := io.ReadAll(r.Body)
 		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 		var requestData map[string]interface{}
 		if err := json.Unmarshal(bodyBytes, &requestData); err == nil {
-			// 检查是否流式请求
-			if stream, ok := requestData["stream"].(bool); ok && stream {
+			// 检查是否流式请求，generate和chat接口未指定stream时Ollama默认流式输出
+			if stream, ok := requestData["stream"].(bool); ok {
+				isStreamRequest = stream
+			} else if r.URL.Path == "/api/chat" || r.URL.Path == "/api/generate" {
 				isStreamRequest = true
 			}
 		}
 	}
 
 	// 处理流式响应 - 只有通过准入控制的请求才会到达这里
-	if isStreamRequest && reqID != "" {
-		// 创建自定义ResponseWriter来收集流式响应
+	if isStreamRequest && (reqID != "" || op.outputCfg.Enabled) {
 		modelName := "unknown"
 		if r.URL.Path == "/api/chat" || r.URL.Path == "/api/generate" {
 			bodyBytes, _ := io.ReadAll(r.Body)
@@ -257,22 +433,12 @@ func (op *OllamaProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// 使用流式收集器
-		collector := newStreamCollector(reqID, r.URL.Path, modelName, op.logger)
-		teeWriter := io.MultiWriter(w, collector)
-
-		// 创建代理ResponseWriter
-		proxyWriter := &streamResponseWriter{
-			ResponseWriter: w,
-			teeWriter:      teeWriter,
-		}
-
 		// 设置上下文
-		ctx := context.WithValue(r.Context(), "requestID", reqID)
-		r = r.WithContext(ctx)
+		if reqID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), "requestID", reqID))
+		}
 
-		// 转发请求
-		op.proxy.ServeHTTP(proxyWriter, r)
+		op.serveStream(w, r, policy, reqID, r.URL.Path, modelName)
 	} else {
 		// 非流式请求，使用标准代理逻辑
 		if reqID != "" {
@@ -283,6 +449,32 @@ func (op *OllamaProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// timingObserver 在转发流式响应的同时，从最后一个片段中观测耗时统计
+type timingObserver struct {
+	io.ReadCloser
+	timing *admission.TimingProfile
+	line   []byte
+}
+
+func (o *timingObserver) Read(p []byte) (int, error) {
+	n, err := o.ReadCloser.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			o.line = append(o.line, data...)
+			break
+		}
+		o.line = append(o.line, data[:idx]...)
+		if bytes.Contains(o.line, []byte(`"done":true`)) {
+			o.timing.ObserveResponse(o.line)
+		}
+		o.line = o.line[:0]
+		data = data[idx+1:]
+	}
+	return n, err
+}
+
 // 自定义ResponseWriter用于处理流式响应
 type streamResponseWriter struct {
 	http.ResponseWriter
@@ -293,40 +485,190 @@ func (w *streamResponseWriter) Write(p []byte) (int, error) {
 	return w.teeWriter.Write(p)
 }
 
-// 添加到OllamaProxy结构体中的方法
-func (op *OllamaProxy) enforceAdmissionCheck(r *http.Request) (bool, string, error) {
-	log.Printf("[强制] 执行强制准入检查")
+// Unwrap 让 http.ResponseController 能够找到底层的Flusher，保证流式片段及时下发
+func (w *streamResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
 
-	if op.admChecker == nil {
-		log.Printf("[错误] 准入控制检查器未初始化")
-		return true, "", nil
+// detect 识别请求中使用的攻击手法，并补充到请求日志中
+func (op *OllamaProxy) detect(reqID string, input admission.Input) {
+	if input.Empty() {
+		return
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
 
-	// 读取请求体
-	bodyBytes, err := io.ReadAll(r.Body)
+	det := op.detector.Detect(ctx, input)
+	if det.Err != nil {
+		log.Printf("[检测] %v", det.Err)
+	}
+	if len(det.Techniques) == 0 && det.Err == nil {
+		return
+	}
+	log.Printf("[检测] 识别出攻击手法: %v, 耗时=%v", det.Tags(), det.Latency)
+
+	var ann logger.RequestAnnotation
+	for _, t := range det.Techniques {
+		ann.Techniques = append(ann.Techniques, logger.TechniqueLog{
+			Tag:         t.Tag,
+			ID:          t.ID,
+			Name:        t.Name,
+			Sources:     t.Sources,
+			SignatureID: t.SignatureID,
+			Role:        t.Role,
+			Confidence:  t.Confidence,
+			Evidence:    t.Evidence,
+		})
+		if !containsString(ann.TechniqueIDs, t.ID) {
+			ann.TechniqueIDs = append(ann.TechniqueIDs, t.ID)
+		}
+	}
+	if det.Err != nil {
+		ann.DetectionError = det.Err.Error()
+	}
+	if op.logger != nil {
+		op.logger.AnnotateRequest(reqID, ann)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// admit 执行准入检查并记录结论，返回结论以及是否需要拒绝请求
+func (op *OllamaProxy) admit(ctx context.Context, policy *admissionPolicy, reqID, path string, input admission.Input) (*admission.Verdict, bool) {
+	verdict, err := op.enforceAdmissionCheck(ctx, policy, input)
+
+	log.Printf("[调试] 准入检查结果: 允许=%v, 原因=%s, 错误=%v", verdict.Allowed, verdict.Reason, err)
+
+	// 记录准入控制结果
+	if op.logger != nil && reqID != "" {
+		entry := admissionLog(verdict, input.String(), err)
+		entry.Shadow = policy.cfg.Shadow
+		entry.Policy = policy.name
+		op.logger.LogAdmission(reqID, entry)
+	}
+	if op.review != nil {
+		op.review.Consider(reqID, path, input, verdict, err)
+	}
+
+	// 出错时的结论已按 failure_mode 处理
 	if err != nil {
-		log.Printf("[错误] 读取请求体失败: %v", err)
-		return true, "", err
+		log.Printf("[警告] 准入控制检查失败: %v", err)
+	}
+	if !verdict.Allowed && policy.cfg.Shadow {
+		log.Printf("[影子] 请求本应被准入控制拒绝: %s", verdict.Reason)
+		return verdict, false
+	}
+	if !verdict.Allowed {
+		log.Printf("[拒绝] 请求被准入控制拒绝: %s", verdict.Reason)
+		return verdict, true
+	}
+	return verdict, false
+}
+
+// enforceAdmissionCheck 按请求的接口调用策略对应的检查方法
+func (op *OllamaProxy) enforceAdmissionCheck(ctx context.Context, policy *admissionPolicy, input admission.Input) (*admission.Verdict, error) {
+	log.Printf("[强制] 执行强制准入检查")
+
+	checker := policy.checker
+	if checker == nil {
+		log.Printf("[错误] 准入控制检查器未初始化")
+		return admission.Allow(), nil
 	}
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
-	// 将整个请求体当作内容进行检查
-	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	// 时限按策略的审核模型、组合阶段和内容需要检查的窗口数计算
+	content := input.String()
+	timeout := admission.CheckTimeout(policy.cfg, utf8.RuneCountInString(content))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	contentToCheck := string(bodyBytes)
-	log.Printf("[强制] 准入检查内容: %s", contentToCheck)
+	log.Printf("[强制] 准入检查内容(%s, 时限=%v): %s", input.Kind, timeout, content)
 
-	allowed, reason, err := op.admChecker.CheckContent(ctx, contentToCheck)
-	log.Printf("[强制] 准入检查结果: 允许=%v, 原因=%s, 错误=%v", allowed, reason, err)
+	verdict, err := admission.CheckInput(ctx, checker, input)
+	log.Printf("[强制] 准入检查结果: 允许=%v, 原因=%s, 类别=%v, 置信度=%.2f, 错误=%v",
+		verdict.Allowed, verdict.Reason, verdict.Categories, verdict.Score, err)
 
-	return allowed, reason, err
+	return verdict, err
+}
+
+// admissionLog 将准入控制结论转换为日志记录
+func admissionLog(verdict *admission.Verdict, content string, err error) logger.AdmissionLog {
+	entry := logger.AdmissionLog{
+		Allowed:     verdict.Allowed,
+		Content:     content,
+		Reason:      verdict.Reason,
+		ModelName:   verdict.Model,
+		Categories:  verdict.Categories,
+		Score:       verdict.Score,
+		Rationale:   verdict.Rationale,
+		RawOutput:   verdict.RawOutput,
+		LatencyMs:   verdict.Latency.Milliseconds(),
+		FailureMode: verdict.FailureMode,
+		RuleID:      verdict.RuleID,
+		Cached:      verdict.Cached,
+		Obfuscation: verdict.Obfuscation,
+		DLPTypes:    verdict.DLPTypes,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	for _, st := range verdict.Stages {
+		cl := logger.CheckerLog{Name: st.Name, Skipped: st.Verdict == nil && st.Err == nil}
+		if st.Verdict != nil {
+			cl.Allowed = st.Verdict.Allowed
+			cl.Undecided = st.Verdict.Undecided
+			cl.Categories = st.Verdict.Categories
+			cl.Score = st.Verdict.Score
+			cl.ModelName = st.Verdict.Model
+			cl.RuleID = st.Verdict.RuleID
+			cl.RawOutput = st.Verdict.RawOutput
+			cl.LatencyMs = st.Verdict.Latency.Milliseconds()
+		}
+		if st.Err != nil {
+			cl.Error = st.Err.Error()
+		}
+		entry.Checkers = append(entry.Checkers, cl)
+	}
+	entry.WindowCount = len(verdict.Windows)
+	for _, w := range verdict.Windows {
+		wl := logger.WindowLog{Index: w.Index, Offset: w.Offset, Chars: w.Chars, Skipped: w.Verdict == nil && w.Err == nil}
+		if w.Verdict != nil {
+			wl.Allowed = w.Verdict.Allowed
+			wl.Categories = w.Verdict.Categories
+			wl.Score = w.Verdict.Score
+			wl.RawOutput = w.Verdict.RawOutput
+			wl.LatencyMs = w.Verdict.Latency.Milliseconds()
+		}
+		if w.Err != nil {
+			wl.Error = w.Err.Error()
+		}
+		entry.Windows = append(entry.Windows, wl)
+	}
+	return entry
 }
 
 // 更新Start方法使用新的处理逻辑
 func (op *OllamaProxy) Start() error {
 	http.HandleFunc("/", op.handleRequest)
 
-	log.Printf("Ollama代理启动于%s，转发至%s", op.listenAddr, op.targetURL)
+	if op.admin != nil {
+		go func() {
+			if err := op.admin.Start(); err != nil {
+				log.Printf("[错误] 管理接口启动失败: %v", err)
+			}
+		}()
+	}
+
+	if op.emulator != nil {
+		log.Printf("Ollama代理启动于%s，运行于无后端模拟模式", op.listenAddr)
+	} else {
+		log.Printf("Ollama代理启动于%s，转发至%s", op.listenAddr, op.targetURL)
+	}
 	return http.ListenAndServe(op.listenAddr, nil)
 }
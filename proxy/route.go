@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// policyKey 在请求上下文中保存选中的准入策略，非流式响应的输出审核在反向代理的回调中使用同一个策略
+const policyKey contextKey = "admissionPolicy"
+
+// defaultPolicyName 为没有路由匹配时使用的策略名称
+const defaultPolicyName = "default"
+
+// admissionPolicy 为一条准入策略：匹配条件、检查器和拒绝文本
+type admissionPolicy struct {
+	name     string
+	route    config.RouteConfig
+	networks []*net.IPNet
+	skip     bool
+	checker  admission.Checker
+	denial   *admission.DenialTemplate
+	// cfg 为策略生效的准入配置，用于计算检查时限和判断是否为影子模式
+	cfg config.AdmissionConfig
+}
+
+// newAdmissionPolicies 为每条路由创建准入策略，各自使用覆盖后的审核模型、组合方式和失败处理方式，
+// 规则集和审核结论缓存与默认策略共用
+func newAdmissionPolicies(admCfg config.AdmissionConfig, dlpCfg config.DLPConfig, shared *admission.Shared) ([]*admissionPolicy, error) {
+	var policies []*admissionPolicy
+	for _, route := range admCfg.Routes {
+		cfg := admCfg.ForRoute(route)
+		p := &admissionPolicy{name: route.Name, route: route, skip: route.Skip, cfg: cfg}
+		for _, n := range route.Networks {
+			_, ipnet, err := net.ParseCIDR(n)
+			if err != nil {
+				return nil, fmt.Errorf("路由 %s 的 networks 无效: %w", route.Name, err)
+			}
+			p.networks = append(p.networks, ipnet)
+		}
+
+		if !route.Skip {
+			checker, err := admission.NewChecker(cfg, dlpCfg, shared)
+			if err != nil {
+				return nil, fmt.Errorf("创建路由 %s 的检查器失败: %w", route.Name, err)
+			}
+			p.checker = checker
+			if p.denial, err = admission.NewDenialTemplate(cfg.DenialMessage); err != nil {
+				return nil, err
+			}
+		}
+		policies = append(policies, p)
+		log.Printf("[策略] 路由 %s: 跳过=%v, 影子模式=%v, 审核模型=%s, 失败处理=%s, 组合阶段数=%d",
+			route.Name, route.Skip, cfg.Shadow, cfg.ModelName, cfg.FailureMode, len(cfg.Chain.Stages))
+	}
+	return policies, nil
+}
+
+// selectPolicy 按接口、请求的模型、客户端和来源网络选择第一条匹配的策略，没有匹配时使用默认策略
+func (op *OllamaProxy) selectPolicy(r *http.Request, body []byte) *admissionPolicy {
+	if len(op.routes) == 0 {
+		return op.defaultPolicy
+	}
+
+	var req struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &req)
+	for _, p := range op.routes {
+		if p.matches(r, req.Model) {
+			return p
+		}
+	}
+	return op.defaultPolicy
+}
+
+// matches 判断请求是否满足策略的全部匹配条件
+func (p *admissionPolicy) matches(r *http.Request, model string) bool {
+	if !matchAny(p.route.Paths, r.URL.Path) || !matchAny(p.route.Models, model) {
+		return false
+	}
+	if !matchAny(p.route.APIKeys, clientAPIKey(r)) || !matchAny(p.route.UserAgents, r.UserAgent()) {
+		return false
+	}
+	if len(p.networks) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return false
+		}
+		for _, n := range p.networks {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchAny 判断值是否匹配任一通配符，没有配置通配符时总是匹配
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAPIKey 返回客户端携带的 API Key
+func clientAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-Api-Key")
+}
+
+// policyFor 返回请求上下文中保存的准入策略，没有时返回默认策略。
+// 只用于反向代理的响应回调，其它地方使用 handleRequest 选出后传入的策略
+func (op *OllamaProxy) policyFor(ctx context.Context) *admissionPolicy {
+	if p, ok := ctx.Value(policyKey).(*admissionPolicy); ok {
+		return p
+	}
+	return op.defaultPolicy
+}
+
+// denialRequest 按策略的拒绝文本模板生成拒绝响应所需的信息
+func (p *admissionPolicy) denialRequest(path string, body []byte, reason string) admission.DenialRequest {
+	denial := admission.NewDenialRequest(path, body, reason)
+	denial.Message = p.denial.Message(reason, denial.Model)
+	return denial
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// denyChecker 拒绝所有内容
+type denyChecker struct{}
+
+func (denyChecker) CheckContent(ctx context.Context, content string) (*admission.Verdict, error) {
+	return &admission.Verdict{Allowed: false, Reason: "blocked"}, nil
+}
+
+func (c denyChecker) CheckPrompt(ctx context.Context, prompt string) (*admission.Verdict, error) {
+	return c.CheckContent(ctx, prompt)
+}
+
+func (c denyChecker) CheckChatMessages(ctx context.Context, messages []admission.Message) (*admission.Verdict, error) {
+	return c.CheckContent(ctx, "")
+}
+
+func TestNewAdmissionPoliciesShadow(t *testing.T) {
+	on, off := true, false
+	admCfg := config.AdmissionConfig{
+		Shadow: true,
+		Routes: []config.RouteConfig{
+			{Name: "inherit", Skip: true},
+			{Name: "enforce", Skip: true, Shadow: &off},
+			{Name: "shadow", Skip: true, Shadow: &on},
+		},
+	}
+	policies, err := newAdmissionPolicies(admCfg, config.DLPConfig{}, nil)
+	if err != nil {
+		t.Fatalf("newAdmissionPolicies: %v", err)
+	}
+	want := map[string]bool{"inherit": true, "enforce": false, "shadow": true}
+	for _, p := range policies {
+		if p.cfg.Shadow != want[p.name] {
+			t.Errorf("policy %s shadow = %v, want %v", p.name, p.cfg.Shadow, want[p.name])
+		}
+	}
+}
+
+func TestAdmitPolicyShadow(t *testing.T) {
+	op := &OllamaProxy{}
+	input := admission.Input{Kind: admission.InputContent, Text: "hello"}
+
+	enforce := &admissionPolicy{name: "enforce", checker: denyChecker{}}
+	if _, deny := op.admit(context.Background(), enforce, "", "/api/generate", input); !deny {
+		t.Error("enforcing policy should deny")
+	}
+
+	shadow := &admissionPolicy{name: "shadow", checker: denyChecker{}, cfg: config.AdmissionConfig{Shadow: true}}
+	verdict, deny := op.admit(context.Background(), shadow, "", "/api/generate", input)
+	if deny || verdict.Allowed {
+		t.Errorf("shadow policy: deny = %v, allowed = %v", deny, verdict.Allowed)
+	}
+}
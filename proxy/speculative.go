@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+)
+
+// speculationKey 在请求上下文中保存推测执行的状态
+const speculationKey contextKey = "speculation"
+
+// errSpeculationDenied 表示推测执行的请求被准入控制拒绝，上游响应需要丢弃
+var errSpeculationDenied = errors.New("请求被准入控制拒绝")
+
+// speculation 表示一次与准入检查同时进行的上游请求。上游的响应在 modifyResponse 中等待审核结论，
+// 此时响应头和正文都还没有下发给客户端，正文留在上游连接中；允许时照常转发，拒绝时取消上游请求
+type speculation struct {
+	ctx      context.Context // 原始请求的上下文，输出拒绝响应时使用，不受取消上游请求的影响
+	upstream context.Context // 上游请求的上下文，拒绝时取消
+	w        http.ResponseWriter
+	started  time.Time
+	body     []byte
+	path     string
+
+	done    chan struct{}
+	denial  admission.DenialRequest
+	denied  bool
+	handled bool // 拒绝响应已写出，只在处理请求的协程中读写
+}
+
+// speculate 在后台执行准入检查，返回的 speculation 的 upstream 上下文用于转发请求
+func (op *OllamaProxy) speculate(w http.ResponseWriter, r *http.Request, policy *admissionPolicy, reqID string, input admission.Input, body []byte, started time.Time) *speculation {
+	upstream, cancel := context.WithCancel(r.Context())
+	spec := &speculation{
+		ctx:      r.Context(),
+		upstream: upstream,
+		w:        w,
+		started:  started,
+		body:     body,
+		path:     r.URL.Path,
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		verdict, deny := op.admit(spec.ctx, policy, reqID, spec.path, input)
+		if deny {
+			spec.denial = policy.denialRequest(spec.path, spec.body, verdict.Reason)
+			spec.denied = true
+		}
+		log.Printf("[推测] 审核结论已到达: 允许=%v, 准入检查耗时=%v", !deny, time.Since(started))
+		close(spec.done)
+		if deny {
+			// 先发布结论再取消，保证等待结论的一方看到的是拒绝而不是普通的取消
+			cancel()
+		}
+	}()
+	return spec
+}
+
+// wait 等待审核结论，返回是否允许
+func (s *speculation) wait() bool {
+	<-s.done
+	return !s.denied
+}
+
+// handleProxyError 处理转发失败。推测执行被拒绝时上游请求已取消或响应已丢弃，
+// 此时客户端还没有收到任何数据，直接输出与同步检查相同的拒绝响应
+func (op *OllamaProxy) handleProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if spec, ok := r.Context().Value(speculationKey).(*speculation); ok && !spec.wait() {
+		log.Printf("[推测] 丢弃上游响应并返回拒绝响应")
+		spec.handled = true
+		op.denier.WriteResponse(spec.ctx, spec.w, spec.denial, spec.started)
+		return
+	}
+
+	log.Printf("[代理] 转发请求失败: %v", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
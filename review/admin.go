@@ -0,0 +1,130 @@
+package review
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+)
+
+// AdminServer 提供复核队列的管理接口，与代理使用不同的监听地址，不会暴露给攻击者
+type AdminServer struct {
+	addr  string
+	token string
+	queue *Queue
+	cache *admission.VerdictCache
+}
+
+// NewAdminServer 创建管理接口，cache 不为空时同时提供审核结论缓存的统计，缓存由全部准入策略共用
+func NewAdminServer(addr, token string, queue *Queue, cache *admission.VerdictCache) *AdminServer {
+	return &AdminServer{addr: addr, token: token, queue: queue, cache: cache}
+}
+
+// Start 启动管理接口
+func (s *AdminServer) Start() error {
+	log.Printf("[复核] 管理接口启动于%s", s.addr)
+	return http.ListenAndServe(s.addr, s.handler())
+}
+
+// handler 返回校验访问令牌后的管理接口路由
+func (s *AdminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /review/items", s.handleList)
+	mux.HandleFunc("GET /review/items/{id}", s.handleGet)
+	mux.HandleFunc("POST /review/items/{id}/label", s.handleLabel)
+	mux.HandleFunc("GET /review/stats", s.handleStats)
+	return s.authorize(mux)
+}
+
+// authorize 校验访问令牌，没有配置令牌时不校验（配置校验保证此时只监听本机）
+func (s *AdminServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				log.Printf("[复核] 管理接口拒绝未授权的请求: %s %s, 来源=%s", r.Method, r.URL.Path, r.RemoteAddr)
+				writeError(w, http.StatusUnauthorized, "未授权")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleList 列出条目，支持 status（pending、reviewed）和 limit 参数
+func (s *AdminServer) handleList(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != StatusPending && status != StatusReviewed {
+		writeError(w, http.StatusBadRequest, "status 无效，可选值为 pending、reviewed")
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit 无效")
+			return
+		}
+		limit = n
+	}
+	items := s.queue.List(status, limit)
+	if items == nil {
+		items = []Item{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+// handleGet 返回一个条目
+func (s *AdminServer) handleGet(w http.ResponseWriter, r *http.Request) {
+	item, err := s.queue.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, item)
+}
+
+// handleLabel 记录复核结果
+func (s *AdminServer) handleLabel(w http.ResponseWriter, r *http.Request) {
+	var req LabelRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "请求体无效: "+err.Error())
+		return
+	}
+
+	item, err := s.queue.Label(r.PathValue("id"), req, r.RemoteAddr)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrReviewed):
+		writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, item)
+	}
+}
+
+// handleStats 返回队列各状态的条目数和审核结论缓存的统计
+func (s *AdminServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]interface{}{"queue": s.queue.Counts()}
+	if s.cache != nil {
+		stats["cache"] = s.cache.Stats()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,66 @@
+package review
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditEntry 为审计日志中的一条复核操作
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	ItemID     string    `json:"item_id"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Reviewer   string    `json:"reviewer"`
+	RemoteAddr string    `json:"remote_addr"`
+	Label      string    `json:"label"`
+	Category   string    `json:"category,omitempty"`
+	Notes      string    `json:"notes,omitempty"`
+	// Verdict 为准入控制原来的结论，便于统计复核推翻的比例
+	Verdict   string `json:"verdict"`
+	RuleID    string `json:"rule_id,omitempty"`
+	KNNSample bool   `json:"knn_sample,omitempty"`
+}
+
+// auditLog 以 JSONL 格式追加记录复核操作，同时输出到终端
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// newAuditLog 打开审计日志文件，path 为空时只输出到终端
+func newAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{}
+	if path == "" {
+		return a, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("打开审计日志文件失败: %w", err)
+	}
+	a.file = f
+	return a, nil
+}
+
+// record 记录一次复核操作
+func (a *auditLog) record(e auditEntry) {
+	log.Printf("[复核] 审计: 操作=%s, 条目=%s, 复核人=%s, 来源=%s, 原结论=%s, 标注=%s, 类别=%s, 规则=%s",
+		e.Action, e.ItemID, e.Reviewer, e.RemoteAddr, e.Verdict, e.Label, e.Category, e.RuleID)
+	if a.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("[复核] 写入审计日志失败: %v", err)
+	}
+}
@@ -0,0 +1,463 @@
+package review
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// 进入复核队列的原因
+const (
+	TriggerError     = "error"     // 审核出错，结论由 failure_mode 决定
+	TriggerUndecided = "undecided" // 检查器无法确定
+	TriggerUncertain = "uncertain" // 违规置信度处于需要复核的区间
+	TriggerSampled   = "sampled"   // 随机抽样
+)
+
+// 条目状态与复核标注
+const (
+	StatusPending  = "pending"
+	StatusReviewed = "reviewed"
+
+	LabelAllow = "allow"
+	LabelDeny  = "deny"
+)
+
+var (
+	// ErrNotFound 表示条目不存在
+	ErrNotFound = errors.New("条目不存在")
+	// ErrReviewed 表示条目已经复核过，复核结果不能覆盖，避免回流的规则和样本前后矛盾
+	ErrReviewed = errors.New("条目已复核")
+)
+
+// Item 为复核队列中的一条准入决定
+type Item struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	RequestID string    `json:"request_id,omitempty"`
+	Path      string    `json:"path"`
+	Trigger   string    `json:"trigger"`
+	Status    string    `json:"status"`
+	// Hits 为相同内容在复核前重复出现的次数
+	Hits int `json:"hits"`
+
+	Kind     string              `json:"kind"`
+	Text     string              `json:"text,omitempty"`
+	Messages []admission.Message `json:"messages,omitempty"`
+
+	Allowed    bool     `json:"allowed"`
+	Categories []string `json:"categories,omitempty"`
+	Score      float64  `json:"score"`
+	Model      string   `json:"model,omitempty"`
+	Rationale  string   `json:"rationale,omitempty"`
+	Error      string   `json:"error,omitempty"`
+
+	Review *Review `json:"review,omitempty"`
+
+	key string
+}
+
+// Input 返回条目的待审核内容
+func (it *Item) Input() admission.Input {
+	return admission.Input{Kind: it.Kind, Text: it.Text, Messages: it.Messages}
+}
+
+// Review 为一次人工复核的结果
+type Review struct {
+	Label    string    `json:"label"`
+	Category string    `json:"category,omitempty"`
+	Notes    string    `json:"notes,omitempty"`
+	Reviewer string    `json:"reviewer"`
+	At       time.Time `json:"at"`
+	// RuleID 为回流到规则文件的规则
+	RuleID string `json:"rule_id,omitempty"`
+	// KNNSample 表示已追加到 kNN 样本文件
+	KNNSample bool `json:"knn_sample,omitempty"`
+}
+
+// LabelRequest 为复核人员提交的标注
+type LabelRequest struct {
+	Label    string `json:"label"`
+	Category string `json:"category"`
+	Notes    string `json:"notes"`
+	Reviewer string `json:"reviewer"`
+	// AddRule 表示把标注作为规则添加到规则文件的最前面，没有指定 Keywords 和 Regex 时按内容完全匹配
+	AddRule  bool     `json:"add_rule"`
+	Keywords []string `json:"keywords"`
+	Regex    string   `json:"regex"`
+}
+
+// Queue 为人工复核队列
+type Queue struct {
+	cfg       config.ReviewConfig
+	rulesFile string
+	audit     *auditLog
+
+	mu      sync.Mutex
+	items   map[string]*Item
+	pending map[string]string // 内容哈希到待复核条目，相同内容只保留一条
+	dirty   bool
+}
+
+// NewQueue 创建复核队列，rulesFile 为准入控制的规则文件，复核结果可以作为规则添加到其中
+func NewQueue(cfg config.ReviewConfig, rulesFile string) (*Queue, error) {
+	if cfg.MaxItems <= 0 {
+		cfg.MaxItems = 10000
+	}
+	audit, err := newAuditLog(cfg.AuditFile)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		cfg:       cfg,
+		rulesFile: rulesFile,
+		audit:     audit,
+		items:     make(map[string]*Item),
+		pending:   make(map[string]string),
+	}
+	if cfg.StoreFile != "" {
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+	}
+	go q.maintain(10 * time.Second)
+
+	log.Printf("[复核] 复核队列已启用: 置信度区间=[%.2f, %.2f], 抽样比例=%.3f, 已有条目=%d",
+		cfg.UncertainLow, cfg.UncertainHigh, cfg.SampleRate, len(q.items))
+	return q, nil
+}
+
+// Consider 根据准入结论决定是否加入复核队列
+func (q *Queue) Consider(reqID, path string, input admission.Input, verdict *admission.Verdict, checkErr error) {
+	if input.Empty() {
+		return
+	}
+
+	var trigger string
+	switch {
+	case checkErr != nil:
+		trigger = TriggerError
+	case verdict.Undecided:
+		trigger = TriggerUndecided
+	case verdict.Score >= q.cfg.UncertainLow && verdict.Score <= q.cfg.UncertainHigh:
+		trigger = TriggerUncertain
+	case mrand.Float64() < q.cfg.SampleRate:
+		trigger = TriggerSampled
+	default:
+		return
+	}
+
+	sum := sha256.Sum256([]byte(input.Kind + "\x00" + input.String()))
+	key := hex.EncodeToString(sum[:])
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if id, ok := q.pending[key]; ok {
+		q.items[id].Hits++
+		q.dirty = true
+		return
+	}
+
+	item := &Item{
+		ID:         newID(),
+		Created:    time.Now(),
+		RequestID:  reqID,
+		Path:       path,
+		Trigger:    trigger,
+		Status:     StatusPending,
+		Hits:       1,
+		Kind:       input.Kind,
+		Text:       input.Text,
+		Messages:   input.Messages,
+		Allowed:    verdict.Allowed,
+		Categories: verdict.Categories,
+		Score:      verdict.Score,
+		Model:      verdict.Model,
+		Rationale:  verdict.Rationale,
+		key:        key,
+	}
+	if checkErr != nil {
+		item.Error = checkErr.Error()
+	}
+	q.items[item.ID] = item
+	q.pending[key] = item.ID
+	q.dirty = true
+	q.evict()
+	log.Printf("[复核] 准入决定加入复核队列: 条目=%s, 原因=%s, 允许=%v, 置信度=%.2f", item.ID, trigger, item.Allowed, item.Score)
+}
+
+// List 返回指定状态的条目，按加入时间从新到旧排列，status 为空时返回全部条目
+func (q *Queue) List(status string, limit int) []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var items []Item
+	for _, it := range q.items {
+		if status == "" || it.Status == status {
+			items = append(items, *it)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Created.After(items[j].Created)
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// Get 返回一个条目
+func (q *Queue) Get(id string) (Item, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	it, ok := q.items[id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	return *it, nil
+}
+
+// Counts 返回各状态的条目数
+func (q *Queue) Counts() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	counts := map[string]int{StatusPending: 0, StatusReviewed: 0}
+	for _, it := range q.items {
+		counts[it.Status]++
+	}
+	return counts
+}
+
+// Label 记录复核结果，按请求把结果回流到规则文件和 kNN 样本文件，并写入审计日志
+func (q *Queue) Label(id string, req LabelRequest, remoteAddr string) (Item, error) {
+	if req.Label != LabelAllow && req.Label != LabelDeny {
+		return Item{}, fmt.Errorf("label 无效: %q，可选值为 %s、%s", req.Label, LabelAllow, LabelDeny)
+	}
+	if req.Reviewer == "" {
+		return Item{}, fmt.Errorf("缺少 reviewer")
+	}
+	if req.AddRule && q.rulesFile == "" {
+		return Item{}, fmt.Errorf("没有配置规则文件，无法添加规则")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	it, ok := q.items[id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	if it.Status == StatusReviewed {
+		return *it, ErrReviewed
+	}
+
+	review := &Review{
+		Label:    req.Label,
+		Category: req.Category,
+		Notes:    req.Notes,
+		Reviewer: req.Reviewer,
+		At:       time.Now(),
+	}
+	if req.AddRule {
+		rule, err := feedbackRule(it, req)
+		if err != nil {
+			return *it, err
+		}
+		if err := admission.InsertRule(q.rulesFile, rule); err != nil {
+			return *it, fmt.Errorf("添加规则失败: %w", err)
+		}
+		review.RuleID = rule.ID
+		log.Printf("[复核] 已添加规则 %s 到 %s: 动作=%s", rule.ID, q.rulesFile, rule.Action)
+	}
+	if q.cfg.KNNSamplesFile != "" {
+		if err := appendSample(q.cfg.KNNSamplesFile, it, review); err != nil {
+			log.Printf("[复核] 追加 kNN 样本失败: %v", err)
+		} else {
+			review.KNNSample = true
+		}
+	}
+
+	it.Review = review
+	it.Status = StatusReviewed
+	delete(q.pending, it.key)
+	q.dirty = true
+
+	q.audit.record(auditEntry{
+		Time:       review.At,
+		Action:     "label",
+		ItemID:     it.ID,
+		RequestID:  it.RequestID,
+		Reviewer:   review.Reviewer,
+		RemoteAddr: remoteAddr,
+		Label:      review.Label,
+		Category:   review.Category,
+		Notes:      review.Notes,
+		Verdict:    verdictLabel(it.Allowed),
+		RuleID:     review.RuleID,
+		KNNSample:  review.KNNSample,
+	})
+	if err := q.saveLocked(); err != nil {
+		log.Printf("[复核] 保存复核队列失败: %v", err)
+	}
+	return *it, nil
+}
+
+// feedbackRule 根据复核结果生成规则，复核为 allow 的内容直接放行，deny 的直接拒绝
+func feedbackRule(it *Item, req LabelRequest) (admission.Rule, error) {
+	rule := admission.Rule{
+		ID:          "review-" + it.ID,
+		Description: fmt.Sprintf("人工复核 %s: %s", req.Reviewer, req.Notes),
+		Action:      admission.RuleAllow,
+		Category:    req.Category,
+		Keywords:    req.Keywords,
+		Regex:       req.Regex,
+	}
+	if req.Label == LabelDeny {
+		rule.Action = admission.RuleDeny
+	}
+	if len(rule.Keywords) == 0 && rule.Regex == "" {
+		text := admission.MatchText(it.Input())
+		if len([]rune(text)) > 2000 {
+			return rule, fmt.Errorf("内容过长，请指定 keywords 或 regex 作为规则条件")
+		}
+		rule.Regex = "^" + regexp.QuoteMeta(text) + "$"
+	}
+	return rule, nil
+}
+
+// appendSample 把复核结果按 knn-build 的样本格式追加到样本文件
+func appendSample(path string, it *Item, review *Review) error {
+	label := "benign"
+	if review.Label == LabelDeny {
+		label = "malicious"
+	}
+	line, err := json.Marshal(map[string]string{
+		"id":       "review-" + it.ID,
+		"text":     it.Input().String(),
+		"label":    label,
+		"category": review.Category,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// evict 超过最大条目数时淘汰最早的条目，优先淘汰已复核的条目
+func (q *Queue) evict() {
+	for len(q.items) > q.cfg.MaxItems {
+		var oldest *Item
+		for _, it := range q.items {
+			if oldest == nil ||
+				(it.Status == StatusReviewed && oldest.Status != StatusReviewed) ||
+				(it.Status == oldest.Status && it.Created.Before(oldest.Created)) {
+				oldest = it
+			}
+		}
+		delete(q.items, oldest.ID)
+		if q.pending[oldest.key] == oldest.ID {
+			delete(q.pending, oldest.key)
+		}
+	}
+}
+
+// load 从持久化文件加载队列
+func (q *Queue) load() error {
+	data, err := os.ReadFile(q.cfg.StoreFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取复核队列文件失败: %w", err)
+	}
+	var items []*Item
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("解析复核队列文件失败: %w", err)
+	}
+	for _, it := range items {
+		sum := sha256.Sum256([]byte(it.Kind + "\x00" + it.Input().String()))
+		it.key = hex.EncodeToString(sum[:])
+		q.items[it.ID] = it
+		if it.Status == StatusPending {
+			q.pending[it.key] = it.ID
+		}
+	}
+	return nil
+}
+
+// saveLocked 将队列写入持久化文件，调用方需要持有锁
+func (q *Queue) saveLocked() error {
+	if q.cfg.StoreFile == "" || !q.dirty {
+		return nil
+	}
+	items := make([]*Item, 0, len(q.items))
+	for _, it := range q.items {
+		items = append(items, it)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Created.Before(items[j].Created)
+	})
+	data, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	tmp := q.cfg.StoreFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.cfg.StoreFile); err != nil {
+		return err
+	}
+	q.dirty = false
+	return nil
+}
+
+// maintain 定期保存队列
+func (q *Queue) maintain(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		q.mu.Lock()
+		err := q.saveLocked()
+		q.mu.Unlock()
+		if err != nil {
+			log.Printf("[复核] 保存复核队列失败: %v", err)
+		}
+	}
+}
+
+// newID 生成随机的条目编号
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func verdictLabel(allowed bool) string {
+	if allowed {
+		return LabelAllow
+	}
+	return LabelDeny
+}
@@ -0,0 +1,229 @@
+package review
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mfzzf/LLM_Based_HoneyPot/admission"
+	"github.com/mfzzf/LLM_Based_HoneyPot/config"
+)
+
+// newTestQueue 创建只在内存中保存条目的复核队列，规则文件、kNN 样本和审计日志写入临时目录
+func newTestQueue(t *testing.T) (*Queue, string) {
+	t.Helper()
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(rules, []byte(`{"default_action":"escalate","rules":[]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	q, err := NewQueue(config.ReviewConfig{
+		UncertainLow:   0.3,
+		UncertainHigh:  0.7,
+		KNNSamplesFile: filepath.Join(dir, "samples.jsonl"),
+		AuditFile:      filepath.Join(dir, "audit.jsonl"),
+	}, rules)
+	if err != nil {
+		t.Fatalf("NewQueue: %v", err)
+	}
+	return q, dir
+}
+
+func TestConsiderTriggers(t *testing.T) {
+	q, _ := newTestQueue(t)
+	prompt := func(text string) admission.Input { return admission.Input{Kind: admission.InputPrompt, Text: text} }
+
+	q.Consider("r1", "/api/generate", prompt("confident"), &admission.Verdict{Allowed: true, Score: 0.05}, nil)
+	q.Consider("r2", "/api/generate", prompt("uncertain"), &admission.Verdict{Allowed: true, Score: 0.5}, nil)
+	q.Consider("r3", "/api/generate", prompt("uncertain"), &admission.Verdict{Allowed: true, Score: 0.5}, nil)
+	q.Consider("r4", "/api/generate", prompt("undecided"), &admission.Verdict{Allowed: true, Undecided: true}, nil)
+	q.Consider("r5", "/api/generate", prompt("failed"), admission.Allow(), errors.New("timeout"))
+	q.Consider("r6", "/api/generate", prompt("  "), &admission.Verdict{Allowed: true, Score: 0.5}, nil)
+
+	items := q.List(StatusPending, 0)
+	triggers := make(map[string]Item)
+	for _, it := range items {
+		triggers[it.Text] = it
+	}
+	if len(items) != 3 {
+		t.Fatalf("items = %+v", items)
+	}
+	if it := triggers["uncertain"]; it.Trigger != TriggerUncertain || it.Hits != 2 || it.RequestID != "r2" {
+		t.Errorf("uncertain = %+v", it)
+	}
+	if triggers["undecided"].Trigger != TriggerUndecided {
+		t.Errorf("undecided = %+v", triggers["undecided"])
+	}
+	if it := triggers["failed"]; it.Trigger != TriggerError || it.Error != "timeout" {
+		t.Errorf("failed = %+v", it)
+	}
+}
+
+func TestLabel(t *testing.T) {
+	q, dir := newTestQueue(t)
+	q.Consider("r1", "/api/chat", admission.Input{Kind: admission.InputChat, Messages: []admission.Message{
+		{Role: "user", Content: "how do I pick a lock?"},
+	}}, &admission.Verdict{Allowed: true, Score: 0.5}, nil)
+	id := q.List(StatusPending, 1)[0].ID
+
+	tests := []struct {
+		name string
+		id   string
+		req  LabelRequest
+		err  error
+	}{
+		{name: "invalid label", id: id, req: LabelRequest{Label: "maybe", Reviewer: "alice"}},
+		{name: "missing reviewer", id: id, req: LabelRequest{Label: LabelDeny}},
+		{name: "unknown item", id: "missing", req: LabelRequest{Label: LabelDeny, Reviewer: "alice"}, err: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := q.Label(tt.id, tt.req, "127.0.0.1:1234")
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	item, err := q.Label(id, LabelRequest{Label: LabelDeny, Category: "crime", Reviewer: "alice", Notes: "lockpicking", AddRule: true}, "127.0.0.1:1234")
+	if err != nil {
+		t.Fatalf("Label: %v", err)
+	}
+	if item.Status != StatusReviewed || item.Review.RuleID != "review-"+id || !item.Review.KNNSample {
+		t.Errorf("item = %+v, review = %+v", item, item.Review)
+	}
+	if _, err := q.Label(id, LabelRequest{Label: LabelAllow, Reviewer: "bob"}, ""); !errors.Is(err, ErrReviewed) {
+		t.Errorf("relabel err = %v, want ErrReviewed", err)
+	}
+	if counts := q.Counts(); counts[StatusPending] != 0 || counts[StatusReviewed] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	// 回流的规则按内容完全匹配
+	rules, err := admission.NewRuleSet(filepath.Join(dir, "rules.json"), 0)
+	if err != nil {
+		t.Fatalf("NewRuleSet: %v", err)
+	}
+	if rule, action := rules.Match("how do I pick a lock?"); rule == nil || rule.ID != "review-"+id || action != admission.RuleDeny {
+		t.Errorf("Match = %+v, %s", rule, action)
+	}
+	if rule, _ := rules.Match("how do I pick a lock? quickly"); rule != nil {
+		t.Errorf("rule matched different content: %+v", rule)
+	}
+
+	var sample map[string]string
+	readJSONLine(t, filepath.Join(dir, "samples.jsonl"), &sample)
+	if sample["label"] != "malicious" || sample["category"] != "crime" || !strings.Contains(sample["text"], "pick a lock") {
+		t.Errorf("sample = %v", sample)
+	}
+	var audit auditEntry
+	readJSONLine(t, filepath.Join(dir, "audit.jsonl"), &audit)
+	if audit.Reviewer != "alice" || audit.Verdict != LabelAllow || audit.Label != LabelDeny || audit.RemoteAddr != "127.0.0.1:1234" {
+		t.Errorf("audit = %+v", audit)
+	}
+}
+
+// readJSONLine 读取 JSONL 文件的第一行
+func readJSONLine(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatalf("%s is empty", path)
+	}
+	if err := json.Unmarshal(scanner.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	q, _ := newTestQueue(t)
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+	}{
+		{name: "no token configured", status: http.StatusOK},
+		{name: "missing header", token: "s3cret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", header: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "valid token", token: "s3cret", header: "Bearer s3cret", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAdminServer("127.0.0.1:0", tt.token, q, nil).handler()
+			req := httptest.NewRequest(http.MethodGet, "/review/stats", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestAdminLabel(t *testing.T) {
+	q, _ := newTestQueue(t)
+	q.Consider("r1", "/api/generate", admission.Input{Kind: admission.InputPrompt, Text: "borderline"},
+		&admission.Verdict{Allowed: false, Score: 0.6}, nil)
+	id := q.List("", 1)[0].ID
+	h := NewAdminServer("127.0.0.1:0", "s3cret", q, nil).handler()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "list pending", method: http.MethodGet, path: "/review/items?status=pending", status: http.StatusOK},
+		{name: "invalid status", method: http.MethodGet, path: "/review/items?status=open", status: http.StatusBadRequest},
+		{name: "invalid limit", method: http.MethodGet, path: "/review/items?limit=-1", status: http.StatusBadRequest},
+		{name: "get", method: http.MethodGet, path: "/review/items/" + id, status: http.StatusOK},
+		{name: "get unknown", method: http.MethodGet, path: "/review/items/nope", status: http.StatusNotFound},
+		{name: "bad body", method: http.MethodPost, path: "/review/items/" + id + "/label", body: "{", status: http.StatusBadRequest},
+		{name: "invalid label", method: http.MethodPost, path: "/review/items/" + id + "/label", body: `{"label":"x","reviewer":"alice"}`, status: http.StatusBadRequest},
+		{name: "label unknown", method: http.MethodPost, path: "/review/items/nope/label", body: `{"label":"allow","reviewer":"alice"}`, status: http.StatusNotFound},
+		{name: "label", method: http.MethodPost, path: "/review/items/" + id + "/label", body: `{"label":"allow","reviewer":"alice"}`, status: http.StatusOK},
+		{name: "label again", method: http.MethodPost, path: "/review/items/" + id + "/label", body: `{"label":"deny","reviewer":"bob"}`, status: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.method, tt.path, tt.body); rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+		})
+	}
+
+	var stats struct {
+		Queue map[string]int `json:"queue"`
+	}
+	if err := json.Unmarshal(do(http.MethodGet, "/review/stats", "").Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if stats.Queue[StatusReviewed] != 1 || stats.Queue[StatusPending] != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}